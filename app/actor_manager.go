@@ -2,47 +2,380 @@ package app
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
 	"sync"
+	"time"
 )
 
+const DefaultLeaseTTL = 10 * time.Second
+
+// LeaseOptions identifies this process to the lease store and sets how long
+// its leases last without being renewed.
+type LeaseOptions struct {
+	Owner string
+	TTL   time.Duration
+}
+
+// leaseOwnerSuffix tells processes apart that share a host name and pid,
+// like containers on the host network all running as pid 1.
+var leaseOwnerSuffix = func() string {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(suffix)
+}()
+
+// DefaultLeaseOwner names the process by host and pid, followed by a random
+// suffix drawn once per process.
+func DefaultLeaseOwner() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), leaseOwnerSuffix)
+}
+
 type ActorManager struct {
-	clients          map[int]*ClientActor
+	clients map[int]*ClientActor
+	// spawning holds the clients whose actor is being spawned, which the
+	// other spawns of the client wait for.
+	spawning         map[int]chan struct{}
 	mutex            sync.Mutex
 	transactionStore TransactionStore
 	clientStore      ClientStore
+	leaseStore       LeaseStore
+	leaseOptions     LeaseOptions
+	snapshotOptions  SnapshotOptions
+	events           EventPublisher
+	// handedOff holds the clients handed off to another process, which are
+	// refused until the time they map to.
+	handedOff map[int]time.Time
+	stopped   bool
+	stop      chan struct{}
 }
 
-func NewActorManager(clientStore ClientStore, transactionStore TransactionStore) *ActorManager {
-	return &ActorManager{
+// NewActorManager creates a manager whose actors each hold the lease of
+// their client, renewed in the background until the actor stops, take
+// snapshots as snapshotOptions says and publish their stored transactions to
+// events, which may be nil.
+func NewActorManager(clientStore ClientStore, transactionStore TransactionStore, leaseStore LeaseStore, leaseOptions LeaseOptions, snapshotOptions SnapshotOptions, events EventPublisher) *ActorManager {
+	if leaseOptions.Owner == "" {
+		leaseOptions.Owner = DefaultLeaseOwner()
+	}
+	if leaseOptions.TTL <= 0 {
+		leaseOptions.TTL = DefaultLeaseTTL
+	}
+
+	m := &ActorManager{
 		clients:          make(map[int]*ClientActor),
+		spawning:         make(map[int]chan struct{}),
+		handedOff:        make(map[int]time.Time),
 		clientStore:      clientStore,
 		transactionStore: transactionStore,
+		leaseStore:       leaseStore,
+		leaseOptions:     leaseOptions,
+		snapshotOptions:  snapshotOptions,
+		events:           events,
+		stop:             make(chan struct{}),
 	}
+
+	go m.renewLeases()
+
+	return m
 }
 
-func (m *ActorManager) Spawn(clientID int) (*ClientActor, error) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+// Spawn returns the actor of the client, spawning it from the stores within
+// ctx when it is not live. The manager is only locked between the store
+// calls, so a slow spawn holds back nothing but the other spawns of the same
+// client.
+func (m *ActorManager) Spawn(ctx context.Context, clientID int) (*ClientActor, error) {
+	for {
+		m.mutex.Lock()
+		if m.stopped {
+			m.mutex.Unlock()
+			return nil, ErrActorStopped
+		}
+
+		if actor, ok := m.clients[clientID]; ok {
+			m.mutex.Unlock()
+			return actor, nil
+		}
+
+		if until, ok := m.handedOff[clientID]; ok {
+			if time.Now().Before(until) {
+				m.mutex.Unlock()
+				return nil, ErrClientHandedOff
+			}
+			delete(m.handedOff, clientID)
+		}
 
-	if actor, ok := m.clients[clientID]; ok {
-		return actor, nil
+		spawning, ok := m.spawning[clientID]
+		if !ok {
+			break
+		}
+		m.mutex.Unlock()
+
+		// the other spawn may fail for reasons of its own, such as its
+		// context, so this one looks again once it is over.
+		select {
+		case <-spawning:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
 	}
 
-	client, err := m.clientStore.GetOne(context.Background(), clientID)
+	spawned := make(chan struct{})
+	m.spawning[clientID] = spawned
+	m.mutex.Unlock()
+
+	defer func() {
+		m.mutex.Lock()
+		delete(m.spawning, clientID)
+		m.mutex.Unlock()
+		close(spawned)
+	}()
+
+	actor, actorCtx, err := m.spawn(ctx, clientID)
 	if err != nil {
 		return nil, err
 	}
 
-	actor := NewClientActor(&client)
-	m.clients[clientID] = actor
-
-	defer actor.Send(ActorMessage{Type: RefreshMessage})
+	// the manager may have stopped, or handed the client off, meanwhile.
+	m.mutex.Lock()
+	if m.stopped {
+		err = ErrActorStopped
+	} else if until, ok := m.handedOff[clientID]; ok && time.Now().Before(until) {
+		err = ErrClientHandedOff
+	} else {
+		m.clients[clientID] = actor
+	}
+	m.mutex.Unlock()
 
-	ctx := &ActorContext{
-		store: m.transactionStore,
+	if err != nil {
+		m.releaseLease(actor.lease)
+		return nil, err
 	}
 
-	go actor.Start(ctx)
+	go actor.Start(actorCtx)
 
 	return actor, nil
 }
+
+// spawn reads the client, takes its lease and rebuilds its actor, which is
+// not started yet.
+func (m *ActorManager) spawn(ctx context.Context, clientID int) (*ClientActor, *ActorContext, error) {
+	client, err := m.clientStore.GetOne(ctx, clientID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lease, err := m.leaseStore.Acquire(ctx, clientID, m.leaseOptions.Owner, m.leaseOptions.TTL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error acquiring lease of client id %d: %w", clientID, err)
+	}
+
+	actor := NewClientActor(&client, lease)
+
+	actorCtx := &ActorContext{
+		store:     m.transactionStore,
+		snapshots: m.snapshotOptions,
+		events:    m.events,
+		onStop:    m.remove,
+	}
+
+	// one that cannot be rebuilt is not kept and gives the lease back.
+	if result := actor.handleRefreshMessage(actorCtx, ActorMessage{ctx: ctx}); result.Error != nil {
+		m.releaseLease(lease)
+		return nil, nil, result.Error
+	}
+	return actor, actorCtx, nil
+}
+
+// Passivate stops the actor of the client, if any, releasing its lease.
+func (m *ActorManager) Passivate(clientID int) {
+	m.mutex.Lock()
+	actor, ok := m.clients[clientID]
+	m.mutex.Unlock()
+
+	if ok {
+		actor.Stop(StopPassivation)
+	}
+}
+
+// HandOff prepares the client to be taken over by another process: its actor,
+// if any, flushes the writes that failed and passivates, releasing the lease,
+// and the client is refused here for a lease TTL, until the router sends it
+// to its new owner. It returns the revision of the client handed off, 0 when
+// no actor was live. If writes are still failing the actor keeps the client.
+func (m *ActorManager) HandOff(clientID int) (int, error) {
+	m.mutex.Lock()
+	if m.stopped {
+		m.mutex.Unlock()
+		return 0, ErrActorStopped
+	}
+	m.handedOff[clientID] = time.Now().Add(m.leaseOptions.TTL)
+	actor, ok := m.clients[clientID]
+	m.mutex.Unlock()
+
+	if !ok {
+		return 0, nil
+	}
+
+	result := actor.Send(ActorMessage{Type: HandoffMessage})
+	if errors.Is(result.Error, ErrActorStopped) {
+		// stopped meanwhile, releasing the lease all the same.
+		<-actor.done
+		return 0, nil
+	}
+	if result.Error != nil {
+		m.mutex.Lock()
+		delete(m.handedOff, clientID)
+		m.mutex.Unlock()
+
+		return 0, fmt.Errorf("error handing off client id %d: %w", clientID, result.Error)
+	}
+
+	// the lease is released once the actor is done.
+	<-actor.done
+	return result.Data.(int), nil
+}
+
+// Hydrate takes over a client handed off by another process, spawning its
+// actor from the store. It fails with ErrStaleHydration if the store does not
+// have the revision the client was handed off at yet.
+func (m *ActorManager) Hydrate(ctx context.Context, clientID int, revision int) (int, error) {
+	m.mutex.Lock()
+	delete(m.handedOff, clientID)
+	m.mutex.Unlock()
+
+	actor, err := m.Spawn(ctx, clientID)
+	if err != nil {
+		return 0, err
+	}
+
+	result := actor.SendContext(ctx, ActorMessage{Type: RefreshMessage})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	hydrated := result.Data.(int)
+	if hydrated < revision {
+		return hydrated, fmt.Errorf("%w: client id %d rebuilt at revision %d, handed off at %d", ErrStaleHydration, clientID, hydrated, revision)
+	}
+	return hydrated, nil
+}
+
+// Shutdown stops every actor and releases their leases. No actor can be
+// spawned afterwards.
+func (m *ActorManager) Shutdown() {
+	m.mutex.Lock()
+	if m.stopped {
+		m.mutex.Unlock()
+		return
+	}
+	m.stopped = true
+	close(m.stop)
+	m.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, actor := range m.ActiveActors() {
+		wg.Add(1)
+		go func(actor *ClientActor) {
+			defer wg.Done()
+			actor.Stop(StopShutdown)
+		}(actor)
+	}
+	wg.Wait()
+}
+
+// remove forgets a stopped actor and releases its lease.
+func (m *ActorManager) remove(actor *ClientActor) {
+	m.mutex.Lock()
+	if m.clients[actor.client.ID] == actor {
+		delete(m.clients, actor.client.ID)
+	}
+	m.mutex.Unlock()
+
+	m.releaseLease(actor.lease)
+}
+
+func (m *ActorManager) releaseLease(lease Lease) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.leaseOptions.TTL)
+	defer cancel()
+
+	if err := m.leaseStore.Release(ctx, lease); err != nil {
+		log.Printf("error releasing lease of client %d: %v\n", lease.ClientID, err)
+	}
+}
+
+// renewLeases renews the lease of every actor three times per TTL. An actor
+// whose lease was lost, or could not be renewed before expiring, is stopped
+// since another process may own its client by now.
+func (m *ActorManager) renewLeases() {
+	interval := m.leaseOptions.TTL / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	expiries := make(map[*ClientActor]time.Time)
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+
+		renewed := make(map[*ClientActor]time.Time)
+
+		for _, actor := range m.ActiveActors() {
+			expiresAt, ok := expiries[actor]
+			if !ok {
+				expiresAt = actor.lease.ExpiresAt
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			lease, err := m.leaseStore.Renew(ctx, actor.lease, m.leaseOptions.TTL)
+			cancel()
+
+			if err == nil {
+				renewed[actor] = lease.ExpiresAt
+				continue
+			}
+
+			if !errors.Is(err, ErrLeaseLost) && time.Now().Before(expiresAt) {
+				log.Printf("error renewing lease of client %d: %v\n", actor.client.ID, err)
+				renewed[actor] = expiresAt
+				continue
+			}
+
+			log.Printf("lease of client %d lost, stopping actor: %v\n", actor.client.ID, err)
+			go actor.Stop(StopLeaseLost)
+		}
+
+		expiries = renewed
+	}
+}
+
+// IsActive reports whether an actor for the client is live in this process.
+func (m *ActorManager) IsActive(clientID int) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	_, ok := m.clients[clientID]
+	return ok
+}
+
+// ActiveActors returns the actors currently live in this process.
+func (m *ActorManager) ActiveActors() []*ClientActor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	actors := make([]*ClientActor, 0, len(m.clients))
+	for _, actor := range m.clients {
+		actors = append(actors, actor)
+	}
+	return actors
+}
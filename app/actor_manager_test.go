@@ -0,0 +1,226 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestActorManagerHandsOffClients(t *testing.T) {
+	clients := NewMemoryClientStore(StoreFaults{})
+	transactions := NewMemoryTransactionStore(StoreFaults{})
+	leases := NewMemoryLeaseStore()
+	clients.Add(context.Background(), Client{ID: 1, CreditLimit: 1000})
+
+	old := NewActorManager(clients, transactions, leases, LeaseOptions{Owner: "old", TTL: time.Minute}, SnapshotOptions{}, nil)
+	defer old.Shutdown()
+	next := NewActorManager(clients, transactions, leases, LeaseOptions{Owner: "next", TTL: time.Minute}, SnapshotOptions{}, nil)
+	defer next.Shutdown()
+
+	actor, err := old.Spawn(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the transaction is acknowledged, but its write is left for later.
+	transactions.SetFaults(StoreFaults{Hook: func(op StoreOperation) error {
+		if op == AddTransactionOperation {
+			return ErrInjectedFault
+		}
+		return nil
+	}})
+	if result := actor.Send(ActorMessage{Type: TransactionMessage, Payload: TransactionRequest{Amount: 100, Type: CreditTransaction, Description: "handoff"}}); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+
+	if _, err := old.HandOff(1); !errors.Is(err, ErrInjectedFault) {
+		t.Fatalf("handoff with a failing write: error = %v, want the write error", err)
+	}
+	if !old.IsActive(1) {
+		t.Fatal("the actor was passivated with a write still failing")
+	}
+	if _, err := next.Hydrate(context.Background(), 1, 0); !errors.Is(err, ErrLeaseHeld) {
+		t.Fatalf("hydrate while the old owner holds the client: error = %v, want ErrLeaseHeld", err)
+	}
+
+	transactions.SetFaults(StoreFaults{})
+
+	revision, err := old.HandOff(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision != 1 || old.IsActive(1) {
+		t.Fatalf("handed off at revision %d, active %v; want revision 1 and the actor passivated", revision, old.IsActive(1))
+	}
+	if _, err := old.Spawn(context.Background(), 1); !errors.Is(err, ErrClientHandedOff) {
+		t.Fatalf("spawn after the handoff: error = %v, want ErrClientHandedOff", err)
+	}
+
+	if hydrated, err := next.Hydrate(context.Background(), 1, revision); err != nil || hydrated != 1 {
+		t.Fatalf("hydrate = %d, %v; want revision 1", hydrated, err)
+	}
+
+	actor, err = next.Spawn(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := actor.Send(ActorMessage{Type: QueryHistoryMessage}).Data.(*TransactionHistory)
+	if history.Balance.Total != 100 {
+		t.Errorf("balance of the new owner = %d, want the 100 flushed by the old one", history.Balance.Total)
+	}
+}
+
+func TestActorManagerRefusesStaleHydration(t *testing.T) {
+	clients := NewMemoryClientStore(StoreFaults{})
+	clients.Add(context.Background(), Client{ID: 1, CreditLimit: 1000})
+
+	m := NewActorManager(clients, NewMemoryTransactionStore(StoreFaults{}), NewMemoryLeaseStore(), LeaseOptions{TTL: time.Minute}, SnapshotOptions{}, nil)
+	defer m.Shutdown()
+
+	if _, err := m.Hydrate(context.Background(), 1, 3); !errors.Is(err, ErrStaleHydration) {
+		t.Errorf("hydrate behind the revision handed off: error = %v, want ErrStaleHydration", err)
+	}
+}
+
+func TestActorManagerKeepsNoActorItCannotRebuild(t *testing.T) {
+	clients := NewMemoryClientStore(StoreFaults{})
+	clients.Add(context.Background(), Client{ID: 1, CreditLimit: 1000})
+	transactions := NewMemoryTransactionStore(StoreFaults{Hook: func(op StoreOperation) error {
+		if op == GetTransactionHistoryOperation {
+			return ErrInjectedFault
+		}
+		return nil
+	}})
+	leases := NewMemoryLeaseStore()
+
+	m := NewActorManager(clients, transactions, leases, LeaseOptions{Owner: "failing", TTL: time.Minute}, SnapshotOptions{}, nil)
+	defer m.Shutdown()
+
+	if _, err := m.Spawn(context.Background(), 1); !errors.Is(err, ErrInjectedFault) {
+		t.Fatalf("spawn with a failing history: error = %v, want the store error", err)
+	}
+	if m.IsActive(1) {
+		t.Error("the actor that could not be rebuilt was kept")
+	}
+	if _, err := leases.Acquire(context.Background(), 1, "other", time.Minute); err != nil {
+		t.Errorf("lease of the failed spawn still held: %v", err)
+	}
+}
+
+func TestActorDropsMessagesPastTheirDeadline(t *testing.T) {
+	clients := NewMemoryClientStore(StoreFaults{})
+	transactions := NewMemoryTransactionStore(StoreFaults{})
+	clients.Add(context.Background(), Client{ID: 1, CreditLimit: 1000})
+
+	m := NewActorManager(clients, transactions, NewMemoryLeaseStore(), LeaseOptions{TTL: time.Minute}, SnapshotOptions{}, nil)
+	defer m.Shutdown()
+
+	actor, err := m.Spawn(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first transaction keeps the actor busy storing it.
+	transactions.SetFaults(StoreFaults{Latency: 200 * time.Millisecond})
+	first := make(chan ActorResult)
+	go func() {
+		first <- actor.Send(ActorMessage{Type: TransactionMessage, Payload: TransactionRequest{Amount: 100, Type: CreditTransaction, Description: "first"}})
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	result := actor.SendContext(ctx, ActorMessage{Type: TransactionMessage, Payload: TransactionRequest{Amount: 50, Type: CreditTransaction, Description: "late"}})
+	if !errors.Is(result.Error, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want DeadlineExceeded", result.Error)
+	}
+
+	if result := <-first; result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	transactions.SetFaults(StoreFaults{})
+
+	history := actor.Send(ActorMessage{Type: QueryHistoryMessage}).Data.(*TransactionHistory)
+	if history.Balance.Total != 100 {
+		t.Errorf("balance = %d, want only the first transaction applied", history.Balance.Total)
+	}
+}
+
+func TestDefaultLeaseOwnerIsDrawnOncePerProcess(t *testing.T) {
+	hostname, _ := os.Hostname()
+	owner := DefaultLeaseOwner()
+
+	if prefix := fmt.Sprintf("%s-%d-", hostname, os.Getpid()); !strings.HasPrefix(owner, prefix) || len(owner) == len(prefix) {
+		t.Errorf("owner = %q, want %q followed by a random suffix", owner, prefix)
+	}
+	if again := DefaultLeaseOwner(); again != owner {
+		t.Errorf("owner changed from %q to %q within the process", owner, again)
+	}
+}
+
+func TestSpawnIsBoundByItsContext(t *testing.T) {
+	clients := NewMemoryClientStore(StoreFaults{Latency: time.Second})
+	m := NewActorManager(clients, NewMemoryTransactionStore(StoreFaults{}), NewMemoryLeaseStore(), LeaseOptions{TTL: time.Minute}, SnapshotOptions{}, nil)
+	defer m.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := m.Spawn(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("spawn returned after %s, past its deadline", elapsed)
+	}
+}
+
+func TestSlowSpawnHoldsBackOnlyItsClient(t *testing.T) {
+	release := make(chan struct{})
+	var reads atomic.Int32
+	clients := NewMemoryClientStore(StoreFaults{Hook: func(op StoreOperation) error {
+		// only the first read, of client 1, hangs.
+		if op == GetClientOperation && reads.Add(1) == 1 {
+			<-release
+		}
+		return nil
+	}})
+	clients.Add(context.Background(), Client{ID: 1, CreditLimit: 1000})
+	clients.Add(context.Background(), Client{ID: 2, CreditLimit: 1000})
+
+	m := NewActorManager(clients, NewMemoryTransactionStore(StoreFaults{}), NewMemoryLeaseStore(), LeaseOptions{TTL: time.Minute}, SnapshotOptions{}, nil)
+	defer m.Shutdown()
+
+	first := make(chan error, 1)
+	go func() {
+		_, err := m.Spawn(context.Background(), 1)
+		first <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// a second spawn of client 1 waits for the first, within its own context.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := m.Spawn(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("second spawn of the hanging client: error = %v, want DeadlineExceeded", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := m.Spawn(ctx, 2); err != nil {
+		t.Fatalf("spawn of another client while the first hangs: %v", err)
+	}
+
+	close(release)
+	if err := <-first; err != nil {
+		t.Fatal(err)
+	}
+	if !m.IsActive(1) || !m.IsActive(2) {
+		t.Error("both actors should be live")
+	}
+}
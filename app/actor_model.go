@@ -2,8 +2,12 @@ package app
 
 import (
 	"context"
+	"errors"
+	"expvar"
 	"fmt"
 	"log"
+	"sync"
+	"time"
 )
 
 type MessageType rune
@@ -12,11 +16,35 @@ const (
 	RefreshMessage      MessageType = 'R'
 	TransactionMessage  MessageType = 'T'
 	QueryHistoryMessage MessageType = 'Q'
+	ReconcileMessage    MessageType = 'C'
+	HandoffMessage      MessageType = 'H'
+	StopMessage         MessageType = 'S'
+)
+
+var ErrActorStopped = fmt.Errorf("actor stopped")
+
+var actorMessagesExpired = expvar.NewInt("actor_messages_expired")
+
+const actorPublishTimeout = 50 * time.Millisecond
+
+// StopReason is the payload of a StopMessage.
+type StopReason int
+
+const (
+	StopPassivation StopReason = iota
+	StopShutdown
+	// StopLeaseLost stops without a snapshot: the state may already be stale.
+	StopLeaseLost
 )
 
 type ActorMessage struct {
 	Type    MessageType
 	Payload any
+
+	// set by SendContext: the message is dropped if ctx is done by the time
+	// the actor takes it, and its result goes to reply.
+	ctx   context.Context
+	reply chan ActorResult
 }
 
 type ActorResult struct {
@@ -25,47 +53,127 @@ type ActorResult struct {
 }
 
 type ActorContext struct {
-	store TransactionStore
+	store     TransactionStore
+	snapshots SnapshotOptions
+	// events receives every transaction once it is stored; it may be nil.
+	events EventPublisher
+	// onStop runs in the actor goroutine once it stops taking messages.
+	onStop func(*ClientActor)
 }
 
 type ClientActor struct {
 	client *Client
+	lease  Lease
 	inbox  chan ActorMessage
-	outbox chan ActorResult
+	done   chan struct{}
+	// stopped is only touched by the actor goroutine.
+	stopped bool
+
+	// writes that failed for reasons other than a revision conflict are kept
+	// for the reconciler. No later revision is written until they are stored.
+	failedWrites []Transaction
+	failedMutex  sync.Mutex
+
+	lastSnapshotRevision int
+	lastSnapshotAt       time.Time
+
+	// the snapshots taken after transactions are saved in the background, so
+	// the store is not on the path of the transaction.
+	snapshotMutex   sync.Mutex
+	pendingSnapshot *Snapshot
+	savingSnapshots bool
+	snapshotsSaved  sync.WaitGroup
 }
 
-func NewClientActor(client *Client) *ClientActor {
+func NewClientActor(client *Client, lease Lease) *ClientActor {
 	actor := &ClientActor{
 		client: client,
+		lease:  lease,
 		inbox:  make(chan ActorMessage),
-		outbox: make(chan ActorResult),
+		done:   make(chan struct{}),
 	}
 
 	return actor
 }
 
+// Send delivers a message and waits for its result, failing with
+// ErrActorStopped once the actor no longer takes messages.
 func (a *ClientActor) Send(msg ActorMessage) ActorResult {
-	a.inbox <- msg
-	return <-a.outbox
+	return a.SendContext(context.Background(), msg)
+}
+
+// SendContext is Send giving up with the error of ctx once it is done. A
+// message the actor has not taken yet is then never applied; one it has
+// taken may still be.
+func (a *ClientActor) SendContext(ctx context.Context, msg ActorMessage) ActorResult {
+	msg.ctx = ctx
+	msg.reply = make(chan ActorResult, 1)
+
+	select {
+	case a.inbox <- msg:
+	case <-a.done:
+		return ActorResult{Error: ErrActorStopped}
+	case <-ctx.Done():
+		return ActorResult{Error: ctx.Err()}
+	}
+
+	select {
+	case result := <-msg.reply:
+		return result
+	case <-ctx.Done():
+		return ActorResult{Error: ctx.Err()}
+	}
+}
+
+// Stop asks the actor to stop and waits until it did.
+func (a *ClientActor) Stop(reason StopReason) {
+	a.Send(ActorMessage{Type: StopMessage, Payload: reason})
+	<-a.done
 }
 
 func (a *ClientActor) Start(ctx *ActorContext) {
-	for msg := range a.inbox {
+	defer close(a.done)
+	if ctx.onStop != nil {
+		defer ctx.onStop(a)
+	}
+	// a write refused for a stale fencing token stops the actor without going
+	// through handleStopMessage, which waits for the snapshots otherwise.
+	defer a.snapshotsSaved.Wait()
+
+	for !a.stopped {
+		msg := <-a.inbox
+
+		// the sender gave up while the message was queued.
+		if err := msg.ctx.Err(); err != nil {
+			actorMessagesExpired.Add(1)
+			msg.reply <- ActorResult{Error: err}
+			continue
+		}
+
 		switch msg.Type {
 		case RefreshMessage:
-			a.outbox <- a.handleRefreshMessage(ctx)
+			msg.reply <- a.handleRefreshMessage(ctx, msg)
 		case TransactionMessage:
-			a.outbox <- a.handleTransactionMessage(ctx, msg)
+			msg.reply <- a.handleTransactionMessage(ctx, msg)
 		case QueryHistoryMessage:
-			a.outbox <- ActorResult{
+			msg.reply <- ActorResult{
 				Data: a.client.GetTransactionHistory(),
 			}
+		case ReconcileMessage:
+			msg.reply <- a.handleReconcileMessage(ctx, msg)
+		case HandoffMessage:
+			msg.reply <- a.handleHandoffMessage(ctx)
+		case StopMessage:
+			a.handleStopMessage(ctx, msg)
+			msg.reply <- ActorResult{}
 		}
 	}
 }
 
-func (a *ClientActor) handleRefreshMessage(ctx *ActorContext) ActorResult {
-	snapshot, transactions, err := ctx.store.GetTransactionHistory(context.Background(), a.client.ID)
+// handleRefreshMessage rebuilds the state from the store, within the context
+// of the message, and answers the revision it reached.
+func (a *ClientActor) handleRefreshMessage(ctx *ActorContext, msg ActorMessage) ActorResult {
+	snapshot, transactions, err := ctx.store.GetTransactionHistory(msg.ctx, a.client.ID)
 	if err != nil {
 		return ActorResult{
 			Error: fmt.Errorf("error fetching transactions for client id %d: %w", a.client.ID, err),
@@ -73,8 +181,117 @@ func (a *ClientActor) handleRefreshMessage(ctx *ActorContext) ActorResult {
 	}
 
 	a.client.RebuildStateFromHistory(snapshot, transactions)
+	a.lastSnapshotRevision = snapshot.Revision
+	a.lastSnapshotAt = snapshot.CreatedAt
 
-	return ActorResult{}
+	return ActorResult{Data: a.client.lastTransactionRevision}
+}
+
+func (a *ClientActor) handleStopMessage(ctx *ActorContext, msg ActorMessage) {
+	a.stopped = true
+	a.snapshotsSaved.Wait()
+
+	switch msg.Payload {
+	case StopPassivation:
+		a.maybeSnapshot(ctx, SnapshotOnPassivation)
+	case StopShutdown:
+		a.maybeSnapshot(ctx, SnapshotOnShutdown)
+	}
+}
+
+// maybeSnapshot saves the current state when the policy asks for it, in the
+// background after a transaction. While writes are failing the state is
+// ahead of the log, so it is not saved.
+func (a *ClientActor) maybeSnapshot(ctx *ActorContext, trigger SnapshotTrigger) {
+	if ctx.snapshots.Policy == nil {
+		return
+	}
+
+	revision := a.client.lastTransactionRevision
+	if revision == 0 || revision == a.lastSnapshotRevision {
+		return
+	}
+
+	a.failedMutex.Lock()
+	failed := len(a.failedWrites)
+	a.failedMutex.Unlock()
+	if failed > 0 {
+		return
+	}
+
+	now := time.Now()
+	progress := SnapshotProgress{
+		Revision:             revision,
+		LastSnapshotRevision: a.lastSnapshotRevision,
+		LastSnapshotAt:       a.lastSnapshotAt,
+		Now:                  now,
+	}
+	if !ctx.snapshots.Policy.ShouldSnapshot(trigger, progress) {
+		return
+	}
+
+	snapshot := a.client.TakeSnapshot(now)
+	if trigger == SnapshotAfterTransaction {
+		// a snapshot failing to save is not retried: the policy asks for
+		// the next one soon enough.
+		a.saveInBackground(ctx, snapshot)
+	} else if !a.saveSnapshot(ctx, snapshot) {
+		return
+	}
+
+	a.lastSnapshotRevision = revision
+	a.lastSnapshotAt = now
+}
+
+// saveSnapshot saves the snapshot and prunes the ones past the retention,
+// telling whether it was saved.
+func (a *ClientActor) saveSnapshot(ctx *ActorContext, snapshot Snapshot) bool {
+	if err := ctx.store.SaveSnapshot(context.Background(), snapshot); err != nil {
+		log.Printf("error taking snapshot for client %d: %v\n", a.client.ID, err)
+		return false
+	}
+
+	if ctx.snapshots.Retain > 0 {
+		if err := ctx.store.PruneSnapshots(context.Background(), a.client.ID, ctx.snapshots.Retain); err != nil {
+			log.Printf("error pruning snapshots of client %d: %v\n", a.client.ID, err)
+		}
+	}
+	return true
+}
+
+// saveInBackground saves the snapshot off the actor goroutine, one at a
+// time. A snapshot still waiting when a newer one is taken is dropped, since
+// the newer one replays less. The actor waits for them when it stops.
+func (a *ClientActor) saveInBackground(ctx *ActorContext, snapshot Snapshot) {
+	a.snapshotMutex.Lock()
+	defer a.snapshotMutex.Unlock()
+
+	a.pendingSnapshot = &snapshot
+	if a.savingSnapshots {
+		return
+	}
+
+	a.savingSnapshots = true
+	a.snapshotsSaved.Add(1)
+	go a.savePendingSnapshots(ctx)
+}
+
+func (a *ClientActor) savePendingSnapshots(ctx *ActorContext) {
+	defer a.snapshotsSaved.Done()
+
+	for {
+		a.snapshotMutex.Lock()
+		snapshot := a.pendingSnapshot
+		a.pendingSnapshot = nil
+		if snapshot == nil {
+			a.savingSnapshots = false
+			a.snapshotMutex.Unlock()
+			return
+		}
+		a.snapshotMutex.Unlock()
+
+		a.saveSnapshot(ctx, *snapshot)
+	}
 }
 
 func (a *ClientActor) handleTransactionMessage(ctx *ActorContext, msg ActorMessage) ActorResult {
@@ -85,6 +302,22 @@ func (a *ClientActor) handleTransactionMessage(ctx *ActorContext, msg ActorMessa
 		}
 	}
 
+	if checker, ok := ctx.store.(AvailabilityChecker); ok {
+		if err := checker.Available(); err != nil {
+			return ActorResult{
+				Error: err,
+			}
+		}
+	}
+
+	// the next revision is only written once the failed ones are stored, so
+	// the log never has a gap.
+	if err := a.flushFailedWrites(ctx); err != nil {
+		return ActorResult{
+			Error: fmt.Errorf("%w: %w", ErrStoreUnavailable, err),
+		}
+	}
+
 	transaction, err := a.client.ProcessTransaction(req)
 
 	if err != nil {
@@ -93,11 +326,37 @@ func (a *ClientActor) handleTransactionMessage(ctx *ActorContext, msg ActorMessa
 		}
 	}
 
-	go func() {
-		if err := ctx.store.Add(context.Background(), a.client.Balance, transaction); err != nil {
-			log.Println(fmt.Errorf("error adding transaction to store for client id %d: %s", a.client.ID, err.Error()))
+	transaction.FencingToken = a.lease.Token
+
+	if err := ctx.store.Add(context.Background(), transaction); err != nil {
+		// the lease was granted to someone else, who now owns the client.
+		if errors.Is(err, ErrStaleFencingToken) {
+			log.Printf("lease of client id %d was taken over, stopping actor\n", a.client.ID)
+			a.stopped = true
+			return ActorResult{
+				Error: err,
+			}
+		}
+
+		// another owner already wrote this revision: our state is stale and
+		// the transaction must not be acknowledged.
+		if errors.Is(err, ErrRevisionConflict) {
+			log.Printf("revision %d of client id %d was written by another owner, rebuilding state\n", transaction.Revision, a.client.ID)
+			a.rebuildAfterConflict(ctx)
+			return ActorResult{
+				Error: err,
+			}
 		}
-	}()
+
+		log.Println(fmt.Errorf("error adding transaction to store for client id %d: %s", a.client.ID, err.Error()))
+
+		a.failedMutex.Lock()
+		a.failedWrites = append(a.failedWrites, transaction)
+		a.failedMutex.Unlock()
+	} else {
+		a.publish(ctx, transaction)
+		a.maybeSnapshot(ctx, SnapshotAfterTransaction)
+	}
 
 	return ActorResult{
 		Data: SuccessTransactionResult{
@@ -106,3 +365,153 @@ func (a *ClientActor) handleTransactionMessage(ctx *ActorContext, msg ActorMessa
 		},
 	}
 }
+
+// publish hands a stored transaction to the event publisher. Since the actor
+// is the only writer of its client, events leave in revision order. It gives
+// up after actorPublishTimeout, so a slow publisher never stalls the actor.
+//
+// This path is best-effort: an event dropped here is gone, so subscribers
+// that need every event must catch up from the store, or read the outbox,
+// which each store but the memory one writes along with the transaction.
+func (a *ClientActor) publish(ctx *ActorContext, transaction Transaction) {
+	if ctx.events == nil {
+		return
+	}
+
+	publishCtx, cancel := context.WithTimeout(context.Background(), actorPublishTimeout)
+	defer cancel()
+
+	if err := ctx.events.Publish(publishCtx, NewTransactionEvent(transaction)); err != nil {
+		log.Printf("error publishing revision %d of client id %d: %v\n", transaction.Revision, a.client.ID, err)
+	}
+}
+
+// rebuildAfterConflict reloads the state from the store, dropping the failed
+// writes, which can no longer be appended after the other owner's.
+func (a *ClientActor) rebuildAfterConflict(ctx *ActorContext) {
+	a.failedMutex.Lock()
+	a.failedWrites = nil
+	a.failedMutex.Unlock()
+
+	if result := a.handleRefreshMessage(ctx, ActorMessage{ctx: context.Background()}); result.Error != nil {
+		log.Println(result.Error)
+	}
+}
+
+func (a *ClientActor) handleReconcileMessage(ctx *ActorContext, msg ActorMessage) ActorResult {
+	action, ok := msg.Payload.(ReconcileAction)
+	if !ok {
+		return ActorResult{
+			Error: fmt.Errorf("invalid payload for actor message type %c", msg.Type),
+		}
+	}
+
+	report := ReconciliationReport{
+		ClientID: a.client.ID,
+		Balance:  a.client.Balance,
+		Revision: a.client.lastTransactionRevision,
+	}
+
+	snapshot, transactions, err := ctx.store.GetTransactionHistory(context.Background(), a.client.ID)
+	if err != nil {
+		return ActorResult{
+			Error: fmt.Errorf("error fetching transactions for client id %d: %w", a.client.ID, err),
+		}
+	}
+
+	stored := Client{ID: a.client.ID, CreditLimit: a.client.CreditLimit}
+	stored.RebuildStateFromHistory(snapshot, transactions)
+
+	report.StoredBalance = stored.Balance
+	report.StoredRevision = stored.lastTransactionRevision
+
+	a.failedMutex.Lock()
+	failed := a.failedWrites
+	a.failedWrites = nil
+	a.failedMutex.Unlock()
+
+	report.FailedWrites = len(failed)
+
+	if !report.Diverged() {
+		return ActorResult{Data: report}
+	}
+
+	switch action {
+	case ReconcileRepersist:
+		failed, report.Repersisted, report.Rebuilt, err = a.repersist(ctx, failed)
+		if err != nil {
+			log.Println(err)
+		}
+	case ReconcileRebuild:
+		a.client.RebuildStateFromHistory(snapshot, transactions)
+		a.lastSnapshotRevision = snapshot.Revision
+		a.lastSnapshotAt = snapshot.CreatedAt
+		report.Rebuilt = true
+		failed = nil
+	}
+
+	if len(failed) > 0 {
+		a.failedMutex.Lock()
+		a.failedWrites = append(failed, a.failedWrites...)
+		a.failedMutex.Unlock()
+	}
+
+	return ActorResult{Data: report}
+}
+
+// repersist appends the failed writes to the store in order, returning the
+// ones left after an error. A revision conflict means another owner wrote
+// meanwhile, so the state is rebuilt and the writes are dropped.
+func (a *ClientActor) repersist(ctx *ActorContext, failed []Transaction) (left []Transaction, repersisted int, rebuilt bool, err error) {
+	for len(failed) > 0 {
+		transaction := failed[0]
+		if err := ctx.store.Add(context.Background(), transaction); err != nil {
+			if errors.Is(err, ErrRevisionConflict) {
+				a.rebuildAfterConflict(ctx)
+				return nil, repersisted, true, nil
+			}
+			return failed, repersisted, false, fmt.Errorf("error re-persisting transaction revision %d for client id %d: %w", transaction.Revision, a.client.ID, err)
+		}
+		a.publish(ctx, transaction)
+		failed = failed[1:]
+		repersisted++
+	}
+	return nil, repersisted, false, nil
+}
+
+// flushFailedWrites re-persists the failed writes, keeping the ones left
+// after an error for the next attempt.
+func (a *ClientActor) flushFailedWrites(ctx *ActorContext) error {
+	a.failedMutex.Lock()
+	failed := a.failedWrites
+	a.failedWrites = nil
+	a.failedMutex.Unlock()
+
+	if len(failed) == 0 {
+		return nil
+	}
+
+	failed, _, _, err := a.repersist(ctx, failed)
+	if err != nil {
+		a.failedMutex.Lock()
+		a.failedWrites = append(failed, a.failedWrites...)
+		a.failedMutex.Unlock()
+	}
+	return err
+}
+
+// handleHandoffMessage flushes the failed writes and passivates the actor,
+// so the next owner of the client finds every acknowledged transaction in
+// the store. It answers the revision handed off, or an error if writes are
+// still failing, in which case the actor keeps running.
+func (a *ClientActor) handleHandoffMessage(ctx *ActorContext) ActorResult {
+	if err := a.flushFailedWrites(ctx); err != nil {
+		return ActorResult{
+			Error: err,
+		}
+	}
+
+	a.handleStopMessage(ctx, ActorMessage{Type: StopMessage, Payload: StopPassivation})
+
+	return ActorResult{Data: a.client.lastTransactionRevision}
+}
@@ -0,0 +1,236 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// spawnTestActor starts the actor of a client with a credit limit of 1000
+// over the stores.
+func spawnTestActor(t *testing.T, transactions TransactionStore) *ClientActor {
+	t.Helper()
+
+	clients := NewMemoryClientStore(StoreFaults{})
+	clients.Add(context.Background(), Client{ID: 1, CreditLimit: 1000})
+
+	m := NewActorManager(clients, transactions, NewMemoryLeaseStore(), LeaseOptions{TTL: time.Minute}, SnapshotOptions{}, nil)
+	t.Cleanup(m.Shutdown)
+
+	actor, err := m.Spawn(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return actor
+}
+
+func credit(amount int) ActorMessage {
+	return ActorMessage{Type: TransactionMessage, Payload: TransactionRequest{Amount: amount, Type: CreditTransaction, Description: "test"}}
+}
+
+func TestActorRebuildsOnRevisionConflict(t *testing.T) {
+	transactions := NewMemoryTransactionStore(StoreFaults{})
+	actor := spawnTestActor(t, transactions)
+
+	// another owner writes revision 1 behind the actor's back.
+	if err := transactions.Add(context.Background(), Transaction{ClientID: 1, Revision: 1, Amount: 500, Type: CreditTransaction, Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if result := actor.Send(credit(100)); !errors.Is(result.Error, ErrRevisionConflict) {
+		t.Fatalf("error = %v, want ErrRevisionConflict", result.Error)
+	}
+
+	history := actor.Send(ActorMessage{Type: QueryHistoryMessage}).Data.(*TransactionHistory)
+	if history.Balance.Total != 500 {
+		t.Errorf("balance after the conflict = %d, want the 500 of the other owner", history.Balance.Total)
+	}
+
+	result := actor.Send(credit(100))
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if balance := result.Data.(SuccessTransactionResult).Balance; balance != 600 {
+		t.Errorf("balance = %d, want 600", balance)
+	}
+}
+
+func TestActorHoldsWritesBehindAFailedOne(t *testing.T) {
+	transactions := NewMemoryTransactionStore(StoreFaults{})
+	actor := spawnTestActor(t, transactions)
+
+	transactions.SetFaults(StoreFaults{Hook: func(op StoreOperation) error {
+		if op == AddTransactionOperation {
+			return ErrInjectedFault
+		}
+		return nil
+	}})
+
+	// the failed write is acknowledged and kept for later.
+	if result := actor.Send(credit(100)); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if result := actor.Send(credit(50)); !errors.Is(result.Error, ErrStoreUnavailable) {
+		t.Fatalf("write behind a failed one: error = %v, want ErrStoreUnavailable", result.Error)
+	}
+
+	transactions.SetFaults(StoreFaults{})
+
+	result := actor.Send(credit(50))
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if balance := result.Data.(SuccessTransactionResult).Balance; balance != 150 {
+		t.Errorf("balance = %d, want 150", balance)
+	}
+
+	var revisions []int
+	transactions.StreamTransactions(context.Background(), 1, TransactionFilter{}, func(t Transaction) error {
+		revisions = append(revisions, t.Revision)
+		return nil
+	})
+	if len(revisions) != 2 || revisions[0] != 1 || revisions[1] != 2 {
+		t.Errorf("stored revisions = %v, want [1 2]", revisions)
+	}
+}
+
+func TestActorWithSlowStore(t *testing.T) {
+	transactions := NewMemoryTransactionStore(StoreFaults{Latency: 10 * time.Millisecond})
+	actor := spawnTestActor(t, transactions)
+
+	results := make(chan ActorResult)
+	for i := 0; i < 5; i++ {
+		go func() { results <- actor.Send(credit(10)) }()
+	}
+	for i := 0; i < 5; i++ {
+		if result := <-results; result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+
+	var revisions []int
+	transactions.StreamTransactions(context.Background(), 1, TransactionFilter{}, func(t Transaction) error {
+		revisions = append(revisions, t.Revision)
+		return nil
+	})
+	for i, revision := range revisions {
+		if revision != i+1 {
+			t.Fatalf("stored revisions = %v, want 1 to 5 in order", revisions)
+		}
+	}
+	if len(revisions) != 5 {
+		t.Errorf("stored %d transactions, want 5", len(revisions))
+	}
+}
+
+func TestActorRepersistsFailedWrites(t *testing.T) {
+	transactions := NewMemoryTransactionStore(StoreFaults{})
+	actor := spawnTestActor(t, transactions)
+
+	transactions.SetFaults(StoreFaults{ErrorRate: 1})
+	if result := actor.Send(credit(100)); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+
+	if result := actor.Send(ActorMessage{Type: ReconcileMessage, Payload: ReconcileRepersist}); !errors.Is(result.Error, ErrInjectedFault) {
+		t.Fatalf("reconcile while the store is down: error = %v, want ErrInjectedFault", result.Error)
+	}
+
+	transactions.SetFaults(StoreFaults{})
+
+	result := actor.Send(ActorMessage{Type: ReconcileMessage, Payload: ReconcileRepersist}).Data.(ReconciliationReport)
+	if result.FailedWrites != 1 || result.Repersisted != 1 || result.StoredRevision != 0 {
+		t.Errorf("unexpected report %+v", result)
+	}
+
+	result = actor.Send(ActorMessage{Type: ReconcileMessage, Payload: ReconcileAlert}).Data.(ReconciliationReport)
+	if result.Diverged() || result.StoredBalance != 100 {
+		t.Errorf("still diverged after re-persisting: %+v", result)
+	}
+}
+
+func TestActorRebuildsAfterDroppedWrites(t *testing.T) {
+	transactions := NewMemoryTransactionStore(StoreFaults{})
+	actor := spawnTestActor(t, transactions)
+
+	transactions.SetFaults(StoreFaults{DropRate: 1})
+	if result := actor.Send(credit(100)); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	transactions.SetFaults(StoreFaults{})
+
+	report := actor.Send(ActorMessage{Type: ReconcileMessage, Payload: ReconcileRebuild}).Data.(ReconciliationReport)
+	if !report.Diverged() || !report.Rebuilt || report.Balance != 100 || report.StoredBalance != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	history := actor.Send(ActorMessage{Type: QueryHistoryMessage}).Data.(*TransactionHistory)
+	if history.Balance.Total != 0 {
+		t.Errorf("balance after the rebuild = %d, want the 0 of the store", history.Balance.Total)
+	}
+}
+
+func TestActorManagerWithUnavailableStore(t *testing.T) {
+	clients := NewMemoryClientStore(StoreFaults{})
+	clients.Add(context.Background(), Client{ID: 1, CreditLimit: 1000})
+	clients.SetFaults(StoreFaults{ErrorRate: 1})
+
+	m := NewActorManager(clients, NewMemoryTransactionStore(StoreFaults{}), NewMemoryLeaseStore(), LeaseOptions{TTL: time.Minute}, SnapshotOptions{}, nil)
+	defer m.Shutdown()
+
+	if _, err := m.Spawn(context.Background(), 1); !errors.Is(err, ErrInjectedFault) {
+		t.Fatalf("spawn with the client store down: error = %v, want ErrInjectedFault", err)
+	}
+	if m.IsActive(1) {
+		t.Fatal("an actor was left behind by the failed spawn")
+	}
+
+	clients.SetFaults(StoreFaults{})
+	if _, err := m.Spawn(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestActorSavesSnapshotsOffTheTransactionPath(t *testing.T) {
+	clients := NewMemoryClientStore(StoreFaults{})
+	clients.Add(context.Background(), Client{ID: 1, CreditLimit: 1000})
+	transactions := NewMemoryTransactionStore(StoreFaults{})
+
+	m := NewActorManager(clients, transactions, NewMemoryLeaseStore(), LeaseOptions{TTL: time.Minute}, SnapshotOptions{Policy: EveryEventsPolicy{N: 1}, Retain: 1}, nil)
+	defer m.Shutdown()
+
+	actor, err := m.Spawn(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the snapshots take until released to save.
+	release := make(chan struct{})
+	transactions.SetFaults(StoreFaults{Hook: func(op StoreOperation) error {
+		if op == SaveSnapshotOperation {
+			<-release
+		}
+		return nil
+	}})
+
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		result := actor.SendContext(ctx, credit(100))
+		cancel()
+		if result.Error != nil {
+			t.Fatalf("transaction %d waiting on the snapshot: %v", i+1, result.Error)
+		}
+	}
+
+	close(release)
+	m.Passivate(1)
+
+	snapshots, err := transactions.ListSnapshots(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 || snapshots[0].Revision != 3 {
+		t.Errorf("snapshots after passivation = %+v, want only the one of revision 3", snapshots)
+	}
+}
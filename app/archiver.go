@@ -0,0 +1,240 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const DefaultArchiveRetention = 90 * 24 * time.Hour
+
+var (
+	errArchiveStop = errors.New("stop")
+	errPageFull    = errors.New("page full")
+)
+
+type ArchiveReport struct {
+	ClientID        int
+	Segments        []ArchiveSegment
+	Archived        int
+	Deleted         int64
+	ArchivedThrough int
+}
+
+// Archiver moves the transactions that rebuilds no longer read, because a
+// snapshot covers them, and that are older than the retention window out of
+// the store and into the archive.
+type Archiver struct {
+	clients      ClientStore
+	transactions TransactionStore
+	archive      *TransactionArchive
+	retention    time.Duration
+}
+
+func NewArchiver(clients ClientStore, transactions TransactionStore, archive *TransactionArchive, retention time.Duration) *Archiver {
+	if retention <= 0 {
+		retention = DefaultArchiveRetention
+	}
+
+	return &Archiver{
+		clients:      clients,
+		transactions: transactions,
+		archive:      archive,
+		retention:    retention,
+	}
+}
+
+// Archive writes the archivable transactions of the client, a segment per
+// month, and deletes every archived one from the store. A run that stopped
+// after the index was written but before the delete is finished by the next.
+func (a *Archiver) Archive(ctx context.Context, clientID int, now time.Time) (report ArchiveReport, err error) {
+	report.ClientID = clientID
+
+	snapshots, err := a.transactions.ListSnapshots(ctx, clientID)
+	if err != nil {
+		return report, err
+	}
+
+	var lastSnapshot Snapshot
+	for _, snapshot := range snapshots {
+		if snapshot.Revision > lastSnapshot.Revision {
+			lastSnapshot = snapshot
+		}
+	}
+
+	covered := lastSnapshot.ReplayFrom() - 1
+	cutoff := now.Add(-a.retention)
+
+	archived, err := a.archive.ArchivedThrough(clientID)
+	if err != nil {
+		return report, err
+	}
+
+	// only a run of consecutive revisions right after the archived ones is
+	// taken, so the archive never has holes.
+	var pending []Transaction
+	next := archived + 1
+
+	if covered >= next {
+		err = a.transactions.StreamTransactions(ctx, clientID, TransactionFilter{FromRevision: next}, func(t Transaction) error {
+			if t.Revision != next || t.Revision > covered || !t.Timestamp.Before(cutoff) {
+				return errArchiveStop
+			}
+			pending = append(pending, t)
+			next++
+			return nil
+		})
+		if err != nil && err != errArchiveStop {
+			return report, err
+		}
+	}
+
+	if len(pending) > 0 {
+		for _, group := range groupByMonth(pending) {
+			segment, err := a.archive.write(clientID, group[0].Timestamp.UTC().Format(ArchiveMonthLayout), group)
+			if err != nil {
+				return report, fmt.Errorf("error writing archive segment: %w", err)
+			}
+			if err := a.archive.Verify(ctx, segment); err != nil {
+				return report, fmt.Errorf("error verifying archive segment: %w", err)
+			}
+			report.Segments = append(report.Segments, segment)
+		}
+
+		if err := a.archive.add(report.Segments); err != nil {
+			return report, fmt.Errorf("error updating archive index: %w", err)
+		}
+
+		report.Archived = len(pending)
+		archived = pending[len(pending)-1].Revision
+	}
+
+	report.ArchivedThrough = archived
+	if archived == 0 {
+		return report, nil
+	}
+
+	report.Deleted, err = a.transactions.DeleteTransactions(ctx, clientID, archived)
+	return report, err
+}
+
+// ArchiveAll archives every client, calling fn with each report.
+func (a *Archiver) ArchiveAll(ctx context.Context, now time.Time, fn func(ArchiveReport)) error {
+	clients, err := a.clients.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, client := range clients {
+		report, err := a.Archive(ctx, client.ID, now)
+		if err != nil {
+			return fmt.Errorf("error archiving client %d: %w", client.ID, err)
+		}
+		fn(report)
+	}
+	return nil
+}
+
+func groupByMonth(transactions []Transaction) [][]Transaction {
+	var groups [][]Transaction
+	month := ""
+
+	for _, t := range transactions {
+		m := t.Timestamp.UTC().Format(ArchiveMonthLayout)
+		if m != month {
+			groups = append(groups, nil)
+			month = m
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], t)
+	}
+	return groups
+}
+
+// archivedTransactionStore reads the archived transactions of a client
+// before the ones still in the store, so exports, verification and the pages
+// of ReadTransactionPage see the whole history.
+type archivedTransactionStore struct {
+	TransactionStore
+	archive *TransactionArchive
+}
+
+func NewArchivedTransactionStore(store TransactionStore, archive *TransactionArchive) TransactionStore {
+	return &archivedTransactionStore{
+		TransactionStore: store,
+		archive:          archive,
+	}
+}
+
+func (s *archivedTransactionStore) Available() error {
+	if checker, ok := s.TransactionStore.(AvailabilityChecker); ok {
+		return checker.Available()
+	}
+	return nil
+}
+
+// CompactedThrough is 0 when the archive holds every transaction compaction
+// dropped from the store.
+func (s *archivedTransactionStore) CompactedThrough(clientID int) (int, error) {
+	reporter, ok := s.TransactionStore.(CompactionReporter)
+	if !ok {
+		return 0, nil
+	}
+
+	compacted, err := reporter.CompactedThrough(clientID)
+	if err != nil || compacted == 0 {
+		return 0, err
+	}
+
+	archived, err := s.archive.ArchivedThrough(clientID)
+	if err != nil || archived >= compacted {
+		return 0, err
+	}
+	return compacted, nil
+}
+
+func (s *archivedTransactionStore) StreamTransactions(ctx context.Context, clientID int, filter TransactionFilter, fn func(Transaction) error) error {
+	archived, err := s.archive.Stream(ctx, clientID, filter, fn)
+	if err != nil {
+		return err
+	}
+
+	// archived transactions may still be in the store, until they are
+	// deleted.
+	if filter.FromRevision <= archived {
+		filter.FromRevision = archived + 1
+	}
+	return s.TransactionStore.StreamTransactions(ctx, clientID, filter, fn)
+}
+
+// TransactionPage is a page of the history of a client, in revision order.
+type TransactionPage struct {
+	Transactions []Transaction
+	// NextRevision is the cursor of the next page, 0 after the last one.
+	NextRevision int
+}
+
+// ReadTransactionPage returns up to limit transactions of the client from
+// revision fromRevision on. Read from the store of NewArchivedTransactionStore,
+// a page starts in the archive and carries on in the store when it crosses
+// the archived revisions.
+func ReadTransactionPage(ctx context.Context, store TransactionStore, clientID int, fromRevision int, limit int) (TransactionPage, error) {
+	var page TransactionPage
+	if limit <= 0 {
+		return page, fmt.Errorf("invalid page limit %d", limit)
+	}
+
+	// one transaction past the page tells where the next one starts.
+	err := store.StreamTransactions(ctx, clientID, TransactionFilter{FromRevision: fromRevision}, func(t Transaction) error {
+		if len(page.Transactions) == limit {
+			page.NextRevision = t.Revision
+			return errPageFull
+		}
+		page.Transactions = append(page.Transactions, t)
+		return nil
+	})
+	if err != nil && err != errPageFull {
+		return page, err
+	}
+	return page, nil
+}
@@ -0,0 +1,137 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiverMovesCoveredTransactions(t *testing.T) {
+	ctx := context.Background()
+
+	clients := NewMemoryClientStore(StoreFaults{})
+	clients.Add(ctx, Client{ID: 1, CreditLimit: 1000})
+	transactions := NewMemoryTransactionStore(StoreFaults{})
+
+	// two months of old transactions, then one from today.
+	client := Client{ID: 1, CreditLimit: 1000}
+	start := time.Date(2024, 1, 30, 12, 0, 0, 0, time.UTC)
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 6; i++ {
+		timestamp := start.Add(time.Duration(i) * 24 * time.Hour)
+		if i == 5 {
+			timestamp = now
+		}
+		transaction, err := client.ProcessTransactionAt(TransactionRequest{Amount: 10, Type: CreditTransaction, Description: "x"}, timestamp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transactions.Add(ctx, transaction)
+
+		if transaction.Revision == 4 {
+			transactions.SaveSnapshot(ctx, client.TakeSnapshot(timestamp))
+		}
+	}
+
+	archive, err := OpenTransactionArchive(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	archiver := NewArchiver(clients, transactions, archive, 24*time.Hour)
+
+	report, err := archiver.Archive(ctx, 1, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// revision 5 is old enough but not covered by the snapshot.
+	if report.Archived != 4 || report.Deleted != 4 || report.ArchivedThrough != 4 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if len(report.Segments) != 2 || report.Segments[0].Month != "2024-01" || report.Segments[1].Month != "2024-02" {
+		t.Errorf("unexpected segments %+v", report.Segments)
+	}
+
+	store := NewArchivedTransactionStore(transactions, archive)
+
+	var revisions []int
+	err = store.StreamTransactions(ctx, 1, TransactionFilter{FromRevision: 2}, func(t Transaction) error {
+		revisions = append(revisions, t.Revision)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 5 || revisions[0] != 2 || revisions[4] != 6 {
+		t.Errorf("revisions = %v, want 2 to 6", revisions)
+	}
+
+	// a damaged segment is refused.
+	path := filepath.Join(archive.dir, report.Segments[0].File)
+	data, _ := os.ReadFile(path)
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(path, data, 0o644)
+
+	err = store.StreamTransactions(ctx, 1, TransactionFilter{}, func(Transaction) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "corrupt") {
+		t.Errorf("error = %v, want a corrupt segment", err)
+	}
+}
+
+func TestReadTransactionPageCrossesTheArchive(t *testing.T) {
+	ctx := context.Background()
+
+	clients := NewMemoryClientStore(StoreFaults{})
+	clients.Add(ctx, Client{ID: 1, CreditLimit: 1000})
+	transactions := NewMemoryTransactionStore(StoreFaults{})
+
+	// revisions 1 to 4 are archived, 5 to 7 stay in the store.
+	seedLedger(t, transactions, 7, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), func(client *Client, transaction Transaction) {
+		if transaction.Revision == 4 {
+			transactions.SaveSnapshot(ctx, client.TakeSnapshot(transaction.Timestamp))
+		}
+	})
+
+	archive, err := OpenTransactionArchive(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := NewArchiver(clients, transactions, archive, 24*time.Hour).Archive(ctx, 1, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ArchivedThrough != 4 {
+		t.Fatalf("archived through %d, want 4", report.ArchivedThrough)
+	}
+
+	store := NewArchivedTransactionStore(transactions, archive)
+
+	var pages [][]int
+	for cursor := 1; cursor != 0; {
+		page, err := ReadTransactionPage(ctx, store, 1, cursor, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var revisions []int
+		for _, transaction := range page.Transactions {
+			revisions = append(revisions, transaction.Revision)
+		}
+		pages = append(pages, revisions)
+		cursor = page.NextRevision
+	}
+
+	// the second page starts in the archive and ends in the store.
+	if got := fmt.Sprint(pages); got != "[[1 2 3] [4 5 6] [7]]" {
+		t.Errorf("pages = %s, want [[1 2 3] [4 5 6] [7]]", got)
+	}
+
+	if _, err := ReadTransactionPage(ctx, store, 1, 1, 0); err == nil {
+		t.Error("a page of no transactions was read")
+	}
+}
@@ -0,0 +1,134 @@
+package app
+
+import (
+	"expvar"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 5 * time.Second
+)
+
+var (
+	circuitOpen     = expvar.NewInt("store_circuit_open")
+	circuitOpened   = expvar.NewInt("store_circuit_opened")
+	circuitRejected = expvar.NewInt("store_circuit_rejected")
+)
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpenState
+	circuitHalfOpen
+)
+
+// CircuitBreaker fails calls fast with ErrStoreUnavailable once threshold
+// calls in a row failed. After the cooldown a single call goes through as a
+// probe: it closes the circuit if it succeeds, and opens it again otherwise.
+type CircuitBreaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	mutex    sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+}
+
+func NewCircuitBreaker(name string, threshold int, cooldown time.Duration) *CircuitBreaker {
+	if threshold <= 0 {
+		threshold = DefaultBreakerThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = DefaultBreakerCooldown
+	}
+
+	return &CircuitBreaker{
+		name:      name,
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// Allow returns ErrStoreUnavailable while the circuit is open. Every call it
+// lets through must be followed by Record or Abandon.
+func (b *CircuitBreaker) Allow() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case circuitOpenState:
+		if time.Since(b.openedAt) >= b.cooldown {
+			b.state = circuitHalfOpen
+			return nil
+		}
+	case circuitHalfOpen:
+		// the probe is still running.
+	default:
+		return nil
+	}
+
+	circuitRejected.Add(1)
+	return ErrStoreUnavailable
+}
+
+// Open tells whether calls are being refused, without letting a probe
+// through.
+func (b *CircuitBreaker) Open() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case circuitOpenState:
+		return time.Since(b.openedAt) < b.cooldown
+	case circuitHalfOpen:
+		return true
+	}
+	return false
+}
+
+// Abandon ends a call that was allowed without counting it either way, as
+// when its caller gave up before the store answered. An abandoned probe frees
+// its slot, so the next call probes again.
+func (b *CircuitBreaker) Abandon() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == circuitHalfOpen {
+		b.state = circuitOpenState
+	}
+}
+
+// Record counts the outcome of a call that was allowed.
+func (b *CircuitBreaker) Record(failed bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if !failed {
+		if b.state != circuitClosed {
+			log.Printf("%s is back, closing the circuit\n", b.name)
+			circuitOpen.Add(-1)
+		}
+		b.state = circuitClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	switch {
+	case b.state == circuitHalfOpen:
+		b.state = circuitOpenState
+		b.openedAt = time.Now()
+	case b.state == circuitClosed && b.failures >= b.threshold:
+		log.Printf("%s failed %d times in a row, opening the circuit for %s\n", b.name, b.failures, b.cooldown)
+		b.state = circuitOpenState
+		b.openedAt = time.Now()
+		circuitOpen.Add(1)
+		circuitOpened.Add(1)
+	}
+}
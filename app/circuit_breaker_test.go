@@ -0,0 +1,119 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	breaker := NewCircuitBreaker("test", 2, 50*time.Millisecond)
+	guard := mongoGuard{MongoOptions{OperationTimeout: 10 * time.Millisecond, Breaker: breaker}}
+
+	hang := func() error {
+		ctx, finish, err := guard.start(context.Background())
+		if err != nil {
+			return err
+		}
+		<-ctx.Done()
+		return finish(ctx.Err())
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := hang(); !errors.Is(err, ErrStoreUnavailable) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("error = %v, want a timeout reported as unavailable", err)
+		}
+	}
+
+	if !breaker.Open() || guard.Available() == nil {
+		t.Fatal("the circuit is not open after two timeouts")
+	}
+	if _, _, err := guard.start(context.Background()); err != ErrStoreUnavailable {
+		t.Errorf("error = %v, want the call refused", err)
+	}
+
+	// after the cooldown a single probe goes through.
+	time.Sleep(60 * time.Millisecond)
+	if breaker.Open() {
+		t.Fatal("the circuit is still open after the cooldown")
+	}
+
+	_, finish, err := guard.start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := guard.start(context.Background()); err != ErrStoreUnavailable {
+		t.Errorf("error = %v, want a second call refused while probing", err)
+	}
+
+	if err := finish(nil); err != nil {
+		t.Fatal(err)
+	}
+	if breaker.Open() {
+		t.Error("the circuit is still open after a successful probe")
+	}
+
+	// a caller giving up does not count against the store.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		opCtx, finish, err := guard.start(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+		if err := finish(opCtx.Err()); errors.Is(err, ErrStoreUnavailable) {
+			t.Errorf("a cancelled call was reported as unavailable")
+		}
+	}
+	if breaker.Open() {
+		t.Error("cancelled calls opened the circuit")
+	}
+}
+
+func TestCircuitBreakerIgnoresAbandonedCalls(t *testing.T) {
+	breaker := NewCircuitBreaker("test", 2, 50*time.Millisecond)
+	guard := mongoGuard{MongoOptions{Breaker: breaker}}
+
+	abandon := func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		opCtx, finish, err := guard.start(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+		finish(opCtx.Err())
+	}
+	fail := func() {
+		_, finish, err := guard.start(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		finish(context.DeadlineExceeded)
+	}
+
+	// an abandoned call between two failures does not reset the streak.
+	fail()
+	abandon()
+	fail()
+	if !breaker.Open() {
+		t.Fatal("the circuit is not open after two failures around an abandoned call")
+	}
+
+	// an abandoned probe neither closes the circuit nor keeps the slot.
+	time.Sleep(60 * time.Millisecond)
+	abandon()
+	if breaker.state == circuitClosed {
+		t.Fatal("an abandoned probe closed the circuit")
+	}
+
+	_, finish, err := guard.start(context.Background())
+	if err != nil {
+		t.Fatalf("error = %v, want the next call to probe", err)
+	}
+	finish(nil)
+	if breaker.Open() {
+		t.Error("the circuit is still open after a successful probe")
+	}
+}
@@ -14,6 +14,12 @@ type Client struct {
 }
 
 func (c *Client) ProcessTransaction(req TransactionRequest) (result Transaction, err error) {
+	return c.ProcessTransactionAt(req, time.Now())
+}
+
+// ProcessTransactionAt applies req as if it happened at timestamp, which lets
+// historical transactions be replayed with their original dates.
+func (c *Client) ProcessTransactionAt(req TransactionRequest, timestamp time.Time) (result Transaction, err error) {
 	if req.Amount <= 0 {
 		return result, fmt.Errorf("o valor não pode ser menor que zero")
 	}
@@ -40,7 +46,7 @@ func (c *Client) ProcessTransaction(req TransactionRequest) (result Transaction,
 		Amount:      req.Amount,
 		Type:        req.Type,
 		Description: req.Description,
-		Timestamp:   time.Now(),
+		Timestamp:   timestamp,
 		Revision:    c.lastTransactionRevision,
 	}
 
@@ -49,11 +55,20 @@ func (c *Client) ProcessTransaction(req TransactionRequest) (result Transaction,
 	return transaction, nil
 }
 
+// RebuildStateFromHistory restores the state saved in lastSnapshot and
+// applies the transactions after it. A full state snapshot already has the
+// history; for older ones it is rebuilt from the transactions before it.
 func (c *Client) RebuildStateFromHistory(lastSnapshot Snapshot, transactions []Transaction) {
 	c.Balance = lastSnapshot.Balance
 	c.lastTransactionRevision = lastSnapshot.Revision
 	c.history.Clear()
 
+	fullState := lastSnapshot.IsFullState()
+	if fullState {
+		c.CreditLimit = lastSnapshot.CreditLimit
+		c.history.LastTransactions = append(c.history.LastTransactions, lastSnapshot.History...)
+	}
+
 	for _, t := range transactions {
 		if t.Revision > c.lastTransactionRevision {
 			if t.Type == CreditTransaction {
@@ -63,12 +78,27 @@ func (c *Client) RebuildStateFromHistory(lastSnapshot Snapshot, transactions []T
 			}
 
 			c.lastTransactionRevision = t.Revision
+		} else if fullState {
+			continue
 		}
 
 		c.history.RegisterTransaction(t)
 	}
 }
 
+// TakeSnapshot captures the whole state of the client.
+func (c *Client) TakeSnapshot(now time.Time) Snapshot {
+	return Snapshot{
+		Version:     FullStateSnapshotVersion,
+		ClientID:    c.ID,
+		Revision:    c.lastTransactionRevision,
+		Balance:     c.Balance,
+		CreditLimit: c.CreditLimit,
+		History:     append([]TransactionSummary(nil), c.history.LastTransactions...),
+		CreatedAt:   now,
+	}
+}
+
 func (c *Client) GetTransactionHistory() *TransactionHistory {
 	h := c.history
 	h.Balance.Date = time.Now()
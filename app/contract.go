@@ -8,22 +8,212 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-var ErrNotFound = fmt.Errorf("not found")
+var (
+	ErrNotFound          = fmt.Errorf("not found")
+	ErrRevisionConflict  = fmt.Errorf("revision conflict")
+	ErrStaleFencingToken = fmt.Errorf("stale fencing token")
+	ErrLeaseHeld         = fmt.Errorf("lease held by another owner")
+	ErrLeaseLost         = fmt.Errorf("lease lost")
+	ErrDeleteUnsupported = fmt.Errorf("the store cannot delete transactions")
+	ErrStoreUnavailable  = fmt.Errorf("store unavailable")
+	ErrClientHandedOff   = fmt.Errorf("client handed off to another owner")
+	ErrStaleHydration    = fmt.Errorf("store behind the revision handed off")
+)
+
+// RevisionConflictError is returned by TransactionStore.Add when the client
+// already has a transaction with the same revision, which means some other
+// writer owns the client. It matches ErrRevisionConflict with errors.Is.
+type RevisionConflictError struct {
+	ClientID int
+	Revision int
+}
+
+func (e *RevisionConflictError) Error() string {
+	return fmt.Sprintf("revision conflict: client id %d already has revision %d", e.ClientID, e.Revision)
+}
+
+func (e *RevisionConflictError) Is(target error) bool {
+	return target == ErrRevisionConflict
+}
+
+const (
+	// BalanceSnapshotVersion snapshots only hold the balance at a revision,
+	// so the transactions before it are read again to rebuild the history.
+	BalanceSnapshotVersion = 1
+	// FullStateSnapshotVersion snapshots hold the whole state of the client.
+	FullStateSnapshotVersion = 2
+)
 
 type Snapshot struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty"`
-	ClientID  int                `bson:"client_id"`
-	Revision  int                `bson:"revision"`
-	Balance   int                `bson:"balance"`
-	CreatedAt time.Time          `bson:"created_at"`
+	ID          primitive.ObjectID   `bson:"_id,omitempty"`
+	Version     int                  `bson:"event_version"`
+	ClientID    int                  `bson:"client_id"`
+	Revision    int                  `bson:"revision"`
+	Balance     int                  `bson:"balance"`
+	CreditLimit int                  `bson:"credit_limit,omitempty"`
+	History     []TransactionSummary `bson:"history,omitempty"`
+	CreatedAt   time.Time            `bson:"created_at"`
+}
+
+func (s Snapshot) IsFullState() bool {
+	return s.Version >= FullStateSnapshotVersion
+}
+
+// ReplayFrom is the first revision a rebuild from the snapshot has to read.
+func (s Snapshot) ReplayFrom() int {
+	if s.IsFullState() {
+		return s.Revision + 1
+	}
+	return s.Revision - HistorySize
+}
+
+// TransactionFilter narrows a scan over a client's transaction log. Zero
+// values leave the corresponding bound open; From is inclusive and To is
+// exclusive.
+type TransactionFilter struct {
+	From         time.Time
+	To           time.Time
+	FromRevision int
 }
 
 type TransactionStore interface {
-	Add(ctx context.Context, currentBalance int, transaction Transaction) error
+	// Add appends a transaction, failing with a RevisionConflictError when the
+	// revision already exists and with ErrStaleFencingToken when its fencing
+	// token is older than the latest lease of the client.
+	Add(ctx context.Context, transaction Transaction) error
+	// GetTransactionHistory returns the snapshot with the highest revision
+	// and the transactions needed to rebuild the client from it.
 	GetTransactionHistory(ctx context.Context, clientID int) (lastSnapshot Snapshot, transactions []Transaction, err error)
+	// StreamTransactions calls fn for every transaction of the client matching
+	// the filter, in revision order. Iteration stops at the first error.
+	StreamTransactions(ctx context.Context, clientID int, filter TransactionFilter, fn func(Transaction) error) error
+	ListSnapshots(ctx context.Context, clientID int) ([]Snapshot, error)
+	// ReplaceSnapshots discards every snapshot of the client and stores the
+	// given ones instead.
+	ReplaceSnapshots(ctx context.Context, clientID int, snapshots []Snapshot) error
+	SaveSnapshot(ctx context.Context, snapshot Snapshot) error
+	// PruneSnapshots deletes all but the keep snapshots of the client with
+	// the highest revisions.
+	PruneSnapshots(ctx context.Context, clientID int, keep int) error
+	// DeleteTransactions removes the transactions of the client up to and
+	// including revision, once they are archived. Stores that cannot fail
+	// with ErrDeleteUnsupported.
+	DeleteTransactions(ctx context.Context, clientID int, revision int) (int64, error)
+}
+
+// AvailabilityChecker is implemented by the stores that know when their
+// database is unreachable. Actors check it before applying a transaction, so
+// it is refused instead of being acknowledged and persisted later.
+type AvailabilityChecker interface {
+	// Available returns ErrStoreUnavailable while writes are known to fail.
+	Available() error
+}
+
+// CompactionReporter is implemented by the stores whose compaction drops the
+// transactions covered by a snapshot. Replays of a compacted client start
+// from the first snapshot a rebuild from which reads none of them.
+type CompactionReporter interface {
+	// CompactedThrough returns the last revision of the client dropped by
+	// compaction, 0 when none was.
+	CompactedThrough(clientID int) (int, error)
+}
+
+// Lease grants a single owner the right to write the transactions of a
+// client until ExpiresAt. Token grows with every grant, so the stores can
+// fence off writes from owners whose lease was taken over.
+type Lease struct {
+	ClientID  int       `bson:"_id"`
+	Owner     string    `bson:"owner"`
+	Token     int64     `bson:"token"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+type LeaseStore interface {
+	// Acquire grants the lease of the client to owner when it is free,
+	// expired or already held by owner, failing with ErrLeaseHeld otherwise.
+	// Every grant gets a new token.
+	Acquire(ctx context.Context, clientID int, owner string, ttl time.Duration) (Lease, error)
+	// Renew extends the lease, failing with ErrLeaseLost once it was granted
+	// again, to the same owner or not.
+	Renew(ctx context.Context, lease Lease, ttl time.Duration) (Lease, error)
+	// Release expires the lease right away. The token is kept so that the
+	// next grant still gets a greater one.
+	Release(ctx context.Context, lease Lease) error
+	// Token returns the token of the last grant of the client's lease, 0
+	// when it was never granted.
+	Token(ctx context.Context, clientID int) (int64, error)
 }
 
 type ClientStore interface {
 	Add(ctx context.Context, client Client) error
 	GetOne(ctx context.Context, clientId int) (client Client, err error)
+	GetAll(ctx context.Context) (clients []Client, err error)
+}
+
+// OutboxEntry is a stored transaction waiting to be published, written
+// together with the transaction itself. ID is assigned by the store and only
+// means something to it.
+type OutboxEntry struct {
+	ID           string      `bson:"_id,omitempty"`
+	ClientID     int         `bson:"client_id"`
+	Revision     int         `bson:"revision"`
+	EventType    string      `bson:"event_type"`
+	EventVersion int         `bson:"event_version"`
+	Transaction  Transaction `bson:"transaction"`
+	CreatedAt    time.Time   `bson:"created_at"`
+	Delivered    bool        `bson:"delivered"`
+	DeliveredAt  time.Time   `bson:"delivered_at,omitempty"`
+}
+
+// OutboxBacklog sums up the undelivered entries; Oldest is zero when there
+// are none.
+type OutboxBacklog struct {
+	Pending int64
+	Oldest  time.Time
+}
+
+type OutboxStore interface {
+	// Pending returns up to limit undelivered entries, each client's in
+	// revision order.
+	Pending(ctx context.Context, limit int) ([]OutboxEntry, error)
+	MarkDelivered(ctx context.Context, ids []string) error
+	Backlog(ctx context.Context) (OutboxBacklog, error)
+}
+
+// StatementView is the statement of a client projected from its
+// transactions. Revision is the last transaction applied, from which the
+// projection resumes.
+type StatementView struct {
+	ClientID         int                  `bson:"_id"`
+	Revision         int                  `bson:"revision"`
+	CreditLimit      int                  `bson:"credit_limit"`
+	Balance          int                  `bson:"balance"`
+	LastTransactions []TransactionSummary `bson:"last_transactions"`
+	UpdatedAt        time.Time            `bson:"updated_at"`
+}
+
+// DailyTotals sums the transactions of a client in a UTC day, formatted as
+// 2006-01-02. Revision is the last transaction counted.
+type DailyTotals struct {
+	ClientID int    `bson:"client_id"`
+	Day      string `bson:"day"`
+	Credits  int    `bson:"credits"`
+	Debits   int    `bson:"debits"`
+	Count    int    `bson:"count"`
+	Revision int    `bson:"revision"`
+}
+
+type ProjectionStore interface {
+	GetStatement(ctx context.Context, clientID int) (StatementView, error)
+	// SaveStatement stores the view unless one with the same or a later
+	// revision is already stored.
+	SaveStatement(ctx context.Context, view StatementView) error
+	// AddToDailyTotals counts the transaction in the totals of its day unless
+	// it, or a later one, was already counted.
+	AddToDailyTotals(ctx context.Context, transaction Transaction) error
+	// ListDailyTotals returns the totals of the days in [from, to], oldest
+	// first.
+	ListDailyTotals(ctx context.Context, clientID int, from, to string) ([]DailyTotals, error)
+	// Reset deletes the read models of the client.
+	Reset(ctx context.Context, clientID int) error
 }
@@ -0,0 +1,249 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func streamRevisions(t *testing.T, transactions TransactionStore, filter TransactionFilter) string {
+	t.Helper()
+
+	var revisions []int
+	err := transactions.StreamTransactions(context.Background(), 1, filter, func(t Transaction) error {
+		revisions = append(revisions, t.Revision)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprint(revisions)
+}
+
+// testTransactionStore runs the TransactionStore contract against a store
+// with nothing for client 1.
+func testTransactionStore(t *testing.T, transactions TransactionStore) {
+	ctx := context.Background()
+	start := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	for revision := 1; revision <= 4; revision++ {
+		transaction := Transaction{ClientID: 1, Revision: revision, Amount: revision * 100, Type: CreditTransaction, Description: fmt.Sprint("t", revision), Timestamp: start.Add(time.Duration(revision) * time.Hour)}
+		if err := transactions.Add(ctx, transaction); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := transactions.Add(ctx, Transaction{ClientID: 1, Revision: 2, Amount: 1, Type: DebitTransaction, Description: "again", Timestamp: start})
+	if !errors.Is(err, ErrRevisionConflict) {
+		t.Errorf("adding revision 2 again: error = %v, want ErrRevisionConflict", err)
+	}
+
+	var stored []Transaction
+	transactions.StreamTransactions(ctx, 1, TransactionFilter{}, func(t Transaction) error {
+		stored = append(stored, t)
+		return nil
+	})
+	if len(stored) != 4 {
+		t.Fatalf("%d transactions stored, want 4", len(stored))
+	}
+	if first := stored[0]; first.Amount != 100 || first.Type != CreditTransaction || first.Description != "t1" || !first.Timestamp.Equal(start.Add(time.Hour)) {
+		t.Errorf("first transaction read back as %+v", first)
+	}
+
+	if revisions := streamRevisions(t, transactions, TransactionFilter{FromRevision: 3}); revisions != "[3 4]" {
+		t.Errorf("revisions from 3 = %s, want [3 4]", revisions)
+	}
+	if revisions := streamRevisions(t, transactions, TransactionFilter{From: start.Add(2 * time.Hour), To: start.Add(4 * time.Hour)}); revisions != "[2 3]" {
+		t.Errorf("revisions between the second and the fourth hour = %s, want [2 3]", revisions)
+	}
+
+	history := []TransactionSummary{{Amount: 200, Type: CreditTransaction, Description: "t2", Timestamp: start.Add(2 * time.Hour)}}
+	if err := transactions.SaveSnapshot(ctx, Snapshot{Version: FullStateSnapshotVersion, ClientID: 1, Revision: 2, Balance: 300, CreditLimit: 1000, History: history, CreatedAt: start}); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, replayed, err := transactions.GetTransactionHistory(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Revision != 2 || snapshot.CreditLimit != 1000 || len(snapshot.History) != 1 || snapshot.History[0].Description != "t2" {
+		t.Errorf("snapshot read back as %+v", snapshot)
+	}
+	if len(replayed) != 2 || replayed[0].Revision != 3 {
+		t.Errorf("replaying %d transactions after the snapshot, want revisions 3 and 4", len(replayed))
+	}
+
+	if err := transactions.ReplaceSnapshots(ctx, 1, []Snapshot{{Version: FullStateSnapshotVersion, Revision: 3, Balance: 600, CreditLimit: 1000, CreatedAt: start}}); err != nil {
+		t.Fatal(err)
+	}
+	snapshots, err := transactions.ListSnapshots(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 || snapshots[0].Revision != 3 || snapshots[0].ClientID != 1 {
+		t.Errorf("snapshots after the replace = %+v, want the one of revision 3", snapshots)
+	}
+
+	deleted, err := transactions.DeleteTransactions(ctx, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revisions := streamRevisions(t, transactions, TransactionFilter{}); deleted != 2 || revisions != "[3 4]" {
+		t.Errorf("deleted %d up to revision 2, leaving %s; want 2 deleted and [3 4]", deleted, revisions)
+	}
+}
+
+// testClientStore runs the ClientStore contract against an empty store.
+func testClientStore(t *testing.T, clients ClientStore) {
+	ctx := context.Background()
+
+	for _, client := range []Client{{ID: 2, CreditLimit: 500}, {ID: 1, CreditLimit: 1000}, {ID: 1, CreditLimit: 1}} {
+		if err := clients.Add(ctx, client); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client, err := clients.GetOne(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.CreditLimit != 1000 {
+		t.Errorf("credit limit = %d, want the 1000 of the first add", client.CreditLimit)
+	}
+
+	if _, err := clients.GetOne(ctx, 3); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown client: error = %v, want ErrNotFound", err)
+	}
+
+	all, err := clients.GetAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].ID != 1 || all[1].ID != 2 {
+		t.Errorf("clients = %+v, want 1 and 2", all)
+	}
+}
+
+// legacyTransactionRows are written the way they were before the event type
+// columns, under a balance only snapshot at revision 2.
+var legacyTransactionRows = []Transaction{
+	{Revision: 1, Amount: 100, Type: CreditTransaction, Description: "a"},
+	{Revision: 2, Amount: 200, Type: CreditTransaction, Description: "b"},
+	{Revision: 3, Amount: 50, Type: DebitTransaction, Description: "c"},
+}
+
+// testLegacyRows rebuilds client 1 from the legacy rows.
+func testLegacyRows(t *testing.T, transactions TransactionStore) {
+	snapshot, replayed, err := transactions.GetTransactionHistory(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Version != BalanceSnapshotVersion || snapshot.Revision != 2 {
+		t.Errorf("snapshot read back as %+v, want a balance snapshot of revision 2", snapshot)
+	}
+
+	client := Client{ID: 1, CreditLimit: 1000}
+	client.RebuildStateFromHistory(snapshot, replayed)
+	if client.Balance != 250 || client.lastTransactionRevision != 3 {
+		t.Errorf("rebuilt balance %d at revision %d, want 250 at 3", client.Balance, client.lastTransactionRevision)
+	}
+	if history := client.GetTransactionHistory().LastTransactions; len(history) != 3 || history[0].Description != "c" {
+		t.Errorf("rebuilt history %+v, want the 3 transactions", history)
+	}
+}
+
+// testFullStateSnapshots rebuilds client 1 from a balance snapshot, then
+// from the full state snapshot taken after it, against a store with nothing
+// for the client.
+func testFullStateSnapshots(t *testing.T, transactions TransactionStore) {
+	ctx := context.Background()
+	start := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	for revision := 1; revision <= HistorySize+2; revision++ {
+		transaction := Transaction{ClientID: 1, Revision: revision, Amount: 10, Type: CreditTransaction, Description: fmt.Sprint(revision), Timestamp: start.Add(time.Duration(revision) * time.Minute)}
+		if err := transactions.Add(ctx, transaction); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rebuild := func(wantVersion, wantReplayed int) Client {
+		t.Helper()
+
+		snapshot, replayed, err := transactions.GetTransactionHistory(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if snapshot.Version != wantVersion {
+			t.Errorf("last snapshot is version %d, want %d", snapshot.Version, wantVersion)
+		}
+		if len(replayed) != wantReplayed {
+			t.Errorf("replaying %d transactions after a version %d snapshot, want %d", len(replayed), snapshot.Version, wantReplayed)
+		}
+
+		client := Client{ID: 1, CreditLimit: 500}
+		client.RebuildStateFromHistory(snapshot, replayed)
+
+		history := client.GetTransactionHistory()
+		if client.Balance != 120 || len(history.LastTransactions) != HistorySize || history.LastTransactions[0].Description != "12" {
+			t.Errorf("rebuilt balance %d with history %+v, want 120 with revisions 12 to 3", client.Balance, history.LastTransactions)
+		}
+		return client
+	}
+
+	// a balance snapshot only saves reading the balance back.
+	balanceOnly := Snapshot{Version: BalanceSnapshotVersion, ClientID: 1, Revision: HistorySize + 1, Balance: 110, CreatedAt: start}
+	if err := transactions.SaveSnapshot(ctx, balanceOnly); err != nil {
+		t.Fatal(err)
+	}
+	client := rebuild(BalanceSnapshotVersion, HistorySize+2)
+
+	client.CreditLimit = 1000
+	if err := transactions.SaveSnapshot(ctx, client.TakeSnapshot(start)); err != nil {
+		t.Fatal(err)
+	}
+	if client := rebuild(FullStateSnapshotVersion, 0); client.CreditLimit != 1000 {
+		t.Errorf("credit limit %d restored from the snapshot, want 1000", client.CreditLimit)
+	}
+
+	snapshots, err := transactions.ListSnapshots(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []string
+	for _, snapshot := range snapshots {
+		versions = append(versions, fmt.Sprintf("%d@%d", snapshot.Version, snapshot.Revision))
+	}
+	slices.Sort(versions)
+	if fmt.Sprint(versions) != "[1@11 2@12]" {
+		t.Errorf("snapshots = %v, want [1@11 2@12]", versions)
+	}
+}
+
+func TestMemoryFullStateSnapshots(t *testing.T) {
+	testFullStateSnapshots(t, NewMemoryTransactionStore(StoreFaults{}))
+}
+
+func TestMemoryTransactionStore(t *testing.T) {
+	testTransactionStore(t, NewMemoryTransactionStore(StoreFaults{}))
+}
+
+func TestMemoryClientStore(t *testing.T) {
+	testClientStore(t, NewMemoryClientStore(StoreFaults{}))
+}
+
+func TestSnapshotReplayFrom(t *testing.T) {
+	for _, c := range []struct {
+		snapshot Snapshot
+		want     int
+	}{
+		{Snapshot{Version: BalanceSnapshotVersion, Revision: 25}, 25 - HistorySize},
+		{Snapshot{Version: FullStateSnapshotVersion, Revision: 25}, 26},
+	} {
+		if got := c.snapshot.ReplayFrom(); got != c.want {
+			t.Errorf("version %d snapshot of revision %d replays from %d, want %d", c.snapshot.Version, c.snapshot.Revision, got, c.want)
+		}
+	}
+}
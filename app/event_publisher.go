@@ -0,0 +1,241 @@
+package app
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// TransactionEvent is what downstream consumers receive once a transaction
+// is stored. The revision of the transaction is its sequence number within
+// the client: consumers see them in order, but may see one more than once.
+type TransactionEvent struct {
+	EventType    string `json:"event_type"`
+	EventVersion int    `json:"event_version"`
+	Transaction
+}
+
+func NewTransactionEvent(transaction Transaction) TransactionEvent {
+	return TransactionEvent{
+		EventType:    TransactionEventType,
+		EventVersion: TransactionEventVersion,
+		Transaction:  transaction,
+	}
+}
+
+var (
+	ErrPublisherClosed = fmt.Errorf("event publisher closed")
+	ErrEventQueueFull  = fmt.Errorf("event queue full")
+)
+
+type EventPublisher interface {
+	Publish(ctx context.Context, event TransactionEvent) error
+}
+
+// MultiEventPublisher publishes every event to each of its publishers.
+type MultiEventPublisher []EventPublisher
+
+func (p MultiEventPublisher) Publish(ctx context.Context, event TransactionEvent) error {
+	for _, publisher := range p {
+		if err := publisher.Publish(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LocalEventPublisher fans events out to subscribers in this process. A
+// subscriber that does not keep up holds back the publisher.
+type LocalEventPublisher struct {
+	mutex       sync.RWMutex
+	subscribers map[*localSubscription]struct{}
+}
+
+type localSubscription struct {
+	events chan TransactionEvent
+	done   chan struct{}
+	once   sync.Once
+}
+
+func NewLocalEventPublisher() *LocalEventPublisher {
+	return &LocalEventPublisher{
+		subscribers: make(map[*localSubscription]struct{}),
+	}
+}
+
+// Subscribe returns the events published from now on and a function that
+// ends the subscription.
+func (p *LocalEventPublisher) Subscribe(buffer int) (<-chan TransactionEvent, func()) {
+	sub := &localSubscription{
+		events: make(chan TransactionEvent, buffer),
+		done:   make(chan struct{}),
+	}
+
+	p.mutex.Lock()
+	p.subscribers[sub] = struct{}{}
+	p.mutex.Unlock()
+
+	unsubscribe := func() {
+		sub.once.Do(func() {
+			close(sub.done)
+
+			p.mutex.Lock()
+			delete(p.subscribers, sub)
+			p.mutex.Unlock()
+		})
+	}
+
+	return sub.events, unsubscribe
+}
+
+func (p *LocalEventPublisher) Publish(ctx context.Context, event TransactionEvent) error {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	for sub := range p.subscribers {
+		select {
+		case sub.events <- event:
+		case <-sub.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+const (
+	DefaultEventBuffer = 1024
+
+	eventRetryMinBackoff = 100 * time.Millisecond
+	eventRetryMaxBackoff = 5 * time.Second
+)
+
+var (
+	eventsPublished     = expvar.NewInt("events_published")
+	eventsPublishErrors = expvar.NewInt("events_publish_errors")
+	eventsPending       = expvar.NewInt("events_pending")
+	eventsDropped       = expvar.NewInt("events_dropped")
+)
+
+// EventDispatcher queues events and publishes them one at a time, in the
+// order they were queued, retrying each until it is delivered. It never holds
+// back the publisher: events that find the queue full are dropped, and the
+// ones still queued when the process dies are lost. It only suits consumers
+// that catch up on missed events from the store, like the projector; events
+// that must leave at least once go through the outbox.
+type EventDispatcher struct {
+	publisher EventPublisher
+	queue     chan TransactionEvent
+	stop      chan struct{}
+	done      chan struct{}
+	mutex     sync.RWMutex
+	closed    bool
+	stopOnce  sync.Once
+}
+
+func NewEventDispatcher(publisher EventPublisher, buffer int) *EventDispatcher {
+	if buffer <= 0 {
+		buffer = DefaultEventBuffer
+	}
+
+	d := &EventDispatcher{
+		publisher: publisher,
+		queue:     make(chan TransactionEvent, buffer),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	go d.run()
+
+	return d
+}
+
+// Publish queues the event, failing with ErrEventQueueFull instead of
+// waiting when there is no room.
+func (d *EventDispatcher) Publish(ctx context.Context, event TransactionEvent) error {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if d.closed {
+		return ErrPublisherClosed
+	}
+
+	select {
+	case d.queue <- event:
+		eventsPending.Add(1)
+		return nil
+	default:
+		eventsDropped.Add(1)
+		return ErrEventQueueFull
+	}
+}
+
+// Close stops taking events and waits until the queued ones are published
+// or ctx is done.
+func (d *EventDispatcher) Close(ctx context.Context) error {
+	d.mutex.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	d.mutex.Unlock()
+
+	select {
+	case <-d.done:
+		return nil
+	case <-ctx.Done():
+		d.stopOnce.Do(func() { close(d.stop) })
+		<-d.done
+		return ctx.Err()
+	}
+}
+
+func (d *EventDispatcher) run() {
+	defer close(d.done)
+
+	for event := range d.queue {
+		if !d.deliver(event) {
+			return
+		}
+		eventsPending.Add(-1)
+	}
+}
+
+// deliver retries the event with exponential backoff until it is published
+// or the dispatcher is stopped.
+func (d *EventDispatcher) deliver(event TransactionEvent) bool {
+	backoff := eventRetryMinBackoff
+
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-d.stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		err := d.publisher.Publish(ctx, event)
+		cancel()
+
+		if err == nil {
+			eventsPublished.Add(1)
+			return true
+		}
+
+		eventsPublishErrors.Add(1)
+		log.Printf("error publishing revision %d of client %d, retrying in %s: %v\n", event.Revision, event.ClientID, backoff, err)
+
+		select {
+		case <-d.stop:
+			return false
+		case <-time.After(backoff):
+		}
+
+		backoff = min(2*backoff, eventRetryMaxBackoff)
+	}
+}
@@ -0,0 +1,251 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// natsStandIn is a NATS server that records what is published to it. It
+// drops the connection instead of answering the PING of the publishes
+// listed in fail.
+type natsStandIn struct {
+	listener net.Listener
+
+	mutex     sync.Mutex
+	subjects  []string
+	events    []TransactionEvent
+	fail      map[int]bool
+	publishes int
+}
+
+func startNATSStandIn(t *testing.T) *natsStandIn {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &natsStandIn{listener: listener, fail: make(map[int]bool)}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *natsStandIn) url() string {
+	return "nats://" + s.listener.Addr().String()
+}
+
+func (s *natsStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+
+	fmt.Fprint(conn, "INFO {\"server_id\":\"stand-in\"}\r\n")
+	reader := bufio.NewReader(conn)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "PUB":
+			var size int
+			fmt.Sscan(fields[2], &size)
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return
+			}
+
+			var event TransactionEvent
+			json.Unmarshal(payload[:size], &event)
+
+			s.mutex.Lock()
+			s.publishes++
+			failed := s.fail[s.publishes]
+			if !failed {
+				s.subjects = append(s.subjects, fields[1])
+				s.events = append(s.events, event)
+			}
+			s.mutex.Unlock()
+
+			if failed {
+				return
+			}
+		case "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		}
+	}
+}
+
+func (s *natsStandIn) received() ([]string, []TransactionEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.subjects...), append([]TransactionEvent(nil), s.events...)
+}
+
+func testEvent(clientID, revision int) TransactionEvent {
+	return NewTransactionEvent(Transaction{
+		ClientID:    clientID,
+		Amount:      revision * 10,
+		Type:        CreditTransaction,
+		Description: "teste",
+		Revision:    revision,
+	})
+}
+
+func TestNATSEventPublisher(t *testing.T) {
+	server := startNATSStandIn(t)
+	publisher := NewNATSEventPublisher(server.url(), "")
+
+	for revision := 1; revision <= 3; revision++ {
+		if err := publisher.Publish(context.Background(), testEvent(2, revision)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	subjects, events := server.received()
+	if len(events) != 3 {
+		t.Fatalf("received %d events, want 3", len(events))
+	}
+	for i, event := range events {
+		if subjects[i] != "transactions.2" {
+			t.Errorf("subject = %s, want transactions.2", subjects[i])
+		}
+		if event.Revision != i+1 || event.ClientID != 2 || event.EventType != TransactionEventType {
+			t.Errorf("unexpected event %+v", event)
+		}
+	}
+}
+
+func TestEventDispatcherRetriesInOrder(t *testing.T) {
+	server := startNATSStandIn(t)
+	server.fail[2] = true
+	server.fail[3] = true
+
+	dispatcher := NewEventDispatcher(NewNATSEventPublisher(server.url(), ""), 0)
+	for revision := 1; revision <= 5; revision++ {
+		if err := dispatcher.Publish(context.Background(), testEvent(1, revision)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := dispatcher.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	_, events := server.received()
+	if len(events) != 5 {
+		t.Fatalf("received %d events, want 5", len(events))
+	}
+	for i, event := range events {
+		if event.Revision != i+1 {
+			t.Errorf("event %d has revision %d", i, event.Revision)
+		}
+	}
+
+	if err := dispatcher.Publish(context.Background(), testEvent(1, 6)); !errors.Is(err, ErrPublisherClosed) {
+		t.Errorf("error = %v, want ErrPublisherClosed", err)
+	}
+}
+
+// blockingPublisher holds every event until released.
+type blockingPublisher struct {
+	release chan struct{}
+}
+
+func (p *blockingPublisher) Publish(ctx context.Context, event TransactionEvent) error {
+	select {
+	case <-p.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestEventDispatcherDropsWhenFull(t *testing.T) {
+	publisher := &blockingPublisher{release: make(chan struct{})}
+	dispatcher := NewEventDispatcher(publisher, 1)
+
+	// the first event is being delivered and the second fills the queue.
+	dispatcher.Publish(context.Background(), testEvent(1, 1))
+	time.Sleep(20 * time.Millisecond)
+	if err := dispatcher.Publish(context.Background(), testEvent(1, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	dropped := eventsDropped.Value()
+	started := time.Now()
+	if err := dispatcher.Publish(context.Background(), testEvent(1, 3)); !errors.Is(err, ErrEventQueueFull) {
+		t.Errorf("error = %v, want ErrEventQueueFull", err)
+	}
+	if time.Since(started) > 10*time.Millisecond {
+		t.Error("publish waited for room in the queue")
+	}
+	if eventsDropped.Value() != dropped+1 {
+		t.Error("the dropped event was not counted")
+	}
+
+	close(publisher.release)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := dispatcher.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalEventPublisherFanOut(t *testing.T) {
+	publisher := NewLocalEventPublisher()
+
+	first, unsubscribeFirst := publisher.Subscribe(10)
+	second, unsubscribeSecond := publisher.Subscribe(10)
+	defer unsubscribeFirst()
+
+	for revision := 1; revision <= 3; revision++ {
+		if err := publisher.Publish(context.Background(), testEvent(3, revision)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, events := range []<-chan TransactionEvent{first, second} {
+		for revision := 1; revision <= 3; revision++ {
+			if event := <-events; event.Revision != revision {
+				t.Errorf("revision = %d, want %d", event.Revision, revision)
+			}
+		}
+	}
+
+	// a subscriber that is gone must not hold back the others.
+	unsubscribeSecond()
+	for revision := 4; revision <= 20; revision++ {
+		if err := publisher.Publish(context.Background(), testEvent(3, revision)); err != nil {
+			t.Fatal(err)
+		}
+		<-first
+	}
+}
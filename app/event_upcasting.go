@@ -0,0 +1,216 @@
+package app
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Every persisted document carries its type and the version of its shape.
+// Documents written before versioning have neither and count as version 0.
+const (
+	EventTypeField    = "event_type"
+	EventVersionField = "event_version"
+
+	TransactionEventType = "transaction"
+	SnapshotEventType    = "snapshot"
+
+	TransactionEventVersion = 1
+)
+
+var ErrUnsupportedEventVersion = fmt.Errorf("unsupported event version")
+
+// Upcaster turns a document into a later version of its shape, setting
+// EventVersionField to that version.
+type Upcaster func(doc bson.M) (bson.M, error)
+
+type upcasterKey struct {
+	eventType string
+	version   int
+}
+
+// UpcasterRegistry holds the upcasters applied to stored documents before
+// they are decoded, so that replay only ever sees shapes the code knows.
+type UpcasterRegistry struct {
+	current   map[string]int
+	upcasters map[upcasterKey]Upcaster
+}
+
+func NewUpcasterRegistry() *UpcasterRegistry {
+	return &UpcasterRegistry{
+		current:   make(map[string]int),
+		upcasters: make(map[upcasterKey]Upcaster),
+	}
+}
+
+// SetCurrentVersion sets the version written for eventType. Documents of
+// later versions come from newer code and are refused.
+func (r *UpcasterRegistry) SetCurrentVersion(eventType string, version int) {
+	r.current[eventType] = version
+}
+
+// Register adds the upcaster for documents of eventType at version.
+func (r *UpcasterRegistry) Register(eventType string, version int, upcaster Upcaster) {
+	r.upcasters[upcasterKey{eventType, version}] = upcaster
+}
+
+// Upcast applies upcasters to doc until none is registered for its version.
+func (r *UpcasterRegistry) Upcast(eventType string, doc bson.M) (bson.M, error) {
+	if t, ok := doc[EventTypeField]; ok && t != eventType {
+		return nil, fmt.Errorf("expected a %s document, got %v", eventType, t)
+	}
+
+	for {
+		version, err := documentVersion(doc)
+		if err != nil {
+			return nil, err
+		}
+		if version > r.current[eventType] {
+			return nil, fmt.Errorf("%w: %s version %d is newer than %d", ErrUnsupportedEventVersion, eventType, version, r.current[eventType])
+		}
+
+		upcaster, ok := r.upcasters[upcasterKey{eventType, version}]
+		if !ok {
+			return doc, nil
+		}
+
+		if doc, err = upcaster(doc); err != nil {
+			return nil, fmt.Errorf("error upcasting %s from version %d: %w", eventType, version, err)
+		}
+
+		next, err := documentVersion(doc)
+		if err != nil {
+			return nil, err
+		}
+		if next <= version {
+			return nil, fmt.Errorf("upcaster of %s version %d did not advance the version", eventType, version)
+		}
+	}
+}
+
+// Decode upcasts raw when it is not at the current version and decodes it
+// into v.
+func (r *UpcasterRegistry) Decode(eventType string, raw bson.Raw, v any) error {
+	if version, ok := raw.Lookup(EventVersionField).AsInt64OK(); ok && int(version) == r.current[eventType] {
+		if t, ok := raw.Lookup(EventTypeField).StringValueOK(); ok && t == eventType {
+			return bson.Unmarshal(raw, v)
+		}
+	}
+
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+
+	doc, err := r.Upcast(eventType, doc)
+	if err != nil {
+		return err
+	}
+
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, v)
+}
+
+// UpcastRecord brings v, read from a store that keeps the event type and
+// version beside the fields rather than in a document, to the current
+// version of eventType. An empty storedType and a zero storedVersion stand
+// for records written before versioning. The fields go through the
+// upcasters under their bson names.
+func (r *UpcasterRegistry) UpcastRecord(eventType string, storedType string, storedVersion int, v any) error {
+	if storedType == eventType && storedVersion == r.current[eventType] {
+		return nil
+	}
+
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	var doc bson.M
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	delete(doc, EventTypeField)
+	if storedType != "" {
+		doc[EventTypeField] = storedType
+	}
+	delete(doc, EventVersionField)
+	if storedVersion != 0 {
+		doc[EventVersionField] = storedVersion
+	}
+
+	if doc, err = r.Upcast(eventType, doc); err != nil {
+		return err
+	}
+
+	if data, err = bson.Marshal(doc); err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, v)
+}
+
+// upcastRow is UpcastRecord for the nullable event type and version columns
+// of the SQL stores.
+func upcastRow(eventType string, storedType *string, storedVersion *int, v any) error {
+	var t string
+	if storedType != nil {
+		t = *storedType
+	}
+	var version int
+	if storedVersion != nil {
+		version = *storedVersion
+	}
+	return eventUpcasters.UpcastRecord(eventType, t, version, v)
+}
+
+func documentVersion(doc bson.M) (int, error) {
+	switch v := doc[EventVersionField].(type) {
+	case nil:
+		return 0, nil
+	case int32:
+		return int(v), nil
+	case int64:
+		return int(v), nil
+	case int:
+		return v, nil
+	case float64:
+		return int(v), nil
+	default:
+		return 0, fmt.Errorf("invalid %s %v", EventVersionField, v)
+	}
+}
+
+// eventUpcasters is the registry the stores decode with.
+var eventUpcasters = newEventUpcasters()
+
+func newEventUpcasters() *UpcasterRegistry {
+	r := NewUpcasterRegistry()
+
+	r.SetCurrentVersion(TransactionEventType, TransactionEventVersion)
+	r.Register(TransactionEventType, 0, func(doc bson.M) (bson.M, error) {
+		doc[EventTypeField] = TransactionEventType
+		doc[EventVersionField] = 1
+		return doc, nil
+	})
+
+	// the first snapshots only had a balance; the ones written before the
+	// version moved to EventVersionField kept it in "version".
+	r.SetCurrentVersion(SnapshotEventType, FullStateSnapshotVersion)
+	r.Register(SnapshotEventType, 0, func(doc bson.M) (bson.M, error) {
+		version := BalanceSnapshotVersion
+		if v, err := documentVersion(bson.M{EventVersionField: doc["version"]}); err == nil && v > 0 {
+			version = v
+		}
+		delete(doc, "version")
+
+		doc[EventTypeField] = SnapshotEventType
+		doc[EventVersionField] = version
+		return doc, nil
+	})
+
+	return r
+}
@@ -0,0 +1,271 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// loadFixture reads the Extended JSON documents of a file in testdata, as
+// they were stored by older versions.
+func loadFixture(t *testing.T, name string) []bson.Raw {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fixture struct {
+		Events []bson.Raw `bson:"events"`
+	}
+	if err := bson.UnmarshalExtJSON(data, false, &fixture); err != nil {
+		t.Fatalf("error reading %s: %v", name, err)
+	}
+	return fixture.Events
+}
+
+func decodeTransactions(t *testing.T, events []bson.Raw) []Transaction {
+	t.Helper()
+
+	transactions := make([]Transaction, len(events))
+	for i, raw := range events {
+		if err := eventUpcasters.Decode(TransactionEventType, raw, &transactions[i]); err != nil {
+			t.Fatalf("error decoding transaction %d: %v", i, err)
+		}
+	}
+	return transactions
+}
+
+func decodeSnapshots(t *testing.T, events []bson.Raw) []Snapshot {
+	t.Helper()
+
+	snapshots := make([]Snapshot, len(events))
+	for i, raw := range events {
+		if err := eventUpcasters.Decode(SnapshotEventType, raw, &snapshots[i]); err != nil {
+			t.Fatalf("error decoding snapshot %d: %v", i, err)
+		}
+	}
+	return snapshots
+}
+
+func TestReplayLegacyTransactions(t *testing.T) {
+	transactions := decodeTransactions(t, loadFixture(t, "transactions_v0.json"))
+
+	client := Client{ID: 1, CreditLimit: 100000}
+	client.RebuildStateFromHistory(Snapshot{}, transactions)
+
+	if client.Balance != 850 {
+		t.Errorf("balance = %d, want 850", client.Balance)
+	}
+	if client.lastTransactionRevision != 4 {
+		t.Errorf("revision = %d, want 4", client.lastTransactionRevision)
+	}
+
+	history := client.GetTransactionHistory().LastTransactions
+	if len(history) != 4 || history[0].Description != "pix" || history[3].Description != "deposito" {
+		t.Errorf("unexpected history %+v", history)
+	}
+}
+
+func TestReplayLegacySnapshots(t *testing.T) {
+	transactions := decodeTransactions(t, loadFixture(t, "transactions_v0.json"))
+	snapshots := decodeSnapshots(t, loadFixture(t, "snapshots_v0.json"))
+
+	balanceOnly, fullState := snapshots[0], snapshots[1]
+
+	if balanceOnly.Version != BalanceSnapshotVersion || balanceOnly.IsFullState() {
+		t.Errorf("unversioned snapshot decoded as version %d", balanceOnly.Version)
+	}
+	if fullState.Version != FullStateSnapshotVersion || len(fullState.History) != 3 {
+		t.Errorf("snapshot with a version field decoded as version %d with %d history items", fullState.Version, len(fullState.History))
+	}
+
+	tests := []struct {
+		name     string
+		snapshot Snapshot
+	}{
+		{"balance only", balanceOnly},
+		{"full state", fullState},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var replay []Transaction
+			for _, transaction := range transactions {
+				if transaction.Revision >= tt.snapshot.ReplayFrom() {
+					replay = append(replay, transaction)
+				}
+			}
+
+			client := Client{ID: 1, CreditLimit: 100000}
+			client.RebuildStateFromHistory(tt.snapshot, replay)
+
+			if client.Balance != 850 {
+				t.Errorf("balance = %d, want 850", client.Balance)
+			}
+			if n := len(client.GetTransactionHistory().LastTransactions); n != 4 {
+				t.Errorf("history has %d items, want 4", n)
+			}
+		})
+	}
+}
+
+func TestDecodeCurrentVersion(t *testing.T) {
+	raw, err := bson.Marshal(mongoTransactionDocument{
+		EventType:    TransactionEventType,
+		EventVersion: TransactionEventVersion,
+		Transaction:  Transaction{ClientID: 1, Amount: 10, Type: CreditTransaction, Description: "a", Revision: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var transaction Transaction
+	if err := eventUpcasters.Decode(TransactionEventType, raw, &transaction); err != nil {
+		t.Fatal(err)
+	}
+	if transaction.Amount != 10 || transaction.Revision != 1 {
+		t.Errorf("unexpected transaction %+v", transaction)
+	}
+
+	raw, err = bson.Marshal(newMongoSnapshotDocument(Snapshot{ClientID: 1, Revision: 1, Balance: 10}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var snapshot Snapshot
+	if err := eventUpcasters.Decode(SnapshotEventType, raw, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Version != BalanceSnapshotVersion || snapshot.Balance != 10 {
+		t.Errorf("unexpected snapshot %+v", snapshot)
+	}
+}
+
+func TestDecodeRejectsUnknownDocuments(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  bson.M
+	}{
+		{"newer version", bson.M{EventTypeField: TransactionEventType, EventVersionField: TransactionEventVersion + 1}},
+		{"other type", bson.M{EventTypeField: SnapshotEventType, EventVersionField: TransactionEventVersion}},
+		{"invalid version", bson.M{EventVersionField: "one"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := bson.Marshal(tt.doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var transaction Transaction
+			if err := eventUpcasters.Decode(TransactionEventType, raw, &transaction); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+
+	raw, _ := bson.Marshal(bson.M{EventVersionField: TransactionEventVersion + 1})
+	var transaction Transaction
+	if err := eventUpcasters.Decode(TransactionEventType, raw, &transaction); !errors.Is(err, ErrUnsupportedEventVersion) {
+		t.Errorf("error = %v, want ErrUnsupportedEventVersion", err)
+	}
+}
+
+func TestUpcastChain(t *testing.T) {
+	registry := NewUpcasterRegistry()
+	registry.SetCurrentVersion("deposit", 3)
+	registry.Register("deposit", 1, func(doc bson.M) (bson.M, error) {
+		doc["cents"] = doc["value"]
+		delete(doc, "value")
+		doc[EventVersionField] = 2
+		return doc, nil
+	})
+	registry.Register("deposit", 2, func(doc bson.M) (bson.M, error) {
+		doc["amount"] = doc["cents"]
+		delete(doc, "cents")
+		doc[EventVersionField] = 3
+		return doc, nil
+	})
+
+	doc, err := registry.Upcast("deposit", bson.M{EventVersionField: 1, "value": 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc["amount"] != 42 || doc[EventVersionField] != 3 {
+		t.Errorf("unexpected document %v", doc)
+	}
+
+	registry.Register("deposit", 0, func(doc bson.M) (bson.M, error) {
+		return doc, nil
+	})
+	if _, err := registry.Upcast("deposit", bson.M{}); err == nil {
+		t.Error("expected an error from an upcaster that does not advance the version")
+	}
+}
+
+func TestFileStoreUpcastsRecords(t *testing.T) {
+	legacy := []byte(`{"client_id": 1, "valor": 10, "tipo": "c", "descricao": "a", "realizada_em": "2024-01-01T00:00:00Z", "revision": 1}`)
+
+	transaction, err := decodeFileTransaction(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transaction.Amount != 10 || transaction.Revision != 1 || transaction.Description != "a" {
+		t.Errorf("unexpected legacy transaction %+v", transaction)
+	}
+
+	newer := []byte(`{"event_type": "transaction", "event_version": 2, "client_id": 1, "valor": 10, "tipo": "c", "descricao": "a", "revision": 1}`)
+	if _, err := decodeFileTransaction(newer); !errors.Is(err, ErrUnsupportedEventVersion) {
+		t.Errorf("error = %v, want ErrUnsupportedEventVersion", err)
+	}
+
+	// snapshots written before versioning have neither type nor version.
+	s, err := OpenFileTransactionStore(FileStoreOptions{Dir: t.TempDir(), Fsync: FsyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.loadSnapshot(&fileSegment{path: "snapshots"}, 0, []byte(`{"snapshot": {"ClientID": 1, "Revision": 4, "Balance": 40}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot := s.getLastSnapshot(1); snapshot.Version != BalanceSnapshotVersion || snapshot.Balance != 40 {
+		t.Errorf("unexpected legacy snapshot %+v", snapshot)
+	}
+}
+
+func TestArchiveUpcastsSegments(t *testing.T) {
+	legacy := []byte(`{"client_id": 1, "valor": 10, "tipo": "c", "descricao": "a", "realizada_em": "2024-01-01T00:00:00Z", "revision": 1}`)
+
+	transaction, err := decodeArchivedTransaction(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transaction.Amount != 10 || transaction.Revision != 1 || transaction.Description != "a" {
+		t.Errorf("unexpected legacy transaction %+v", transaction)
+	}
+
+	line, err := json.Marshal(NewTransactionEvent(transaction))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(line, []byte(`"event_type":"transaction"`)) {
+		t.Errorf("archived line %s has no event type", line)
+	}
+	if again, err := decodeArchivedTransaction(line); err != nil || again != transaction {
+		t.Errorf("read back %+v (%v), want %+v", again, err, transaction)
+	}
+
+	newer := []byte(`{"event_type": "transaction", "event_version": 2, "client_id": 1, "valor": 10, "tipo": "c", "descricao": "a", "revision": 1}`)
+	if _, err := decodeArchivedTransaction(newer); !errors.Is(err, ErrUnsupportedEventVersion) {
+		t.Errorf("error = %v, want ErrUnsupportedEventVersion", err)
+	}
+}
@@ -0,0 +1,424 @@
+package app
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Records are framed as a 4 byte little endian payload length, the CRC-32C
+// of the payload and the payload itself.
+const (
+	fileRecordHeaderSize = 8
+	fileMaxRecordSize    = 1 << 20
+	fileSegmentExt       = ".log"
+)
+
+type FsyncPolicy string
+
+const (
+	// FsyncAlways syncs every record before the write returns.
+	FsyncAlways FsyncPolicy = "always"
+	// FsyncInterval syncs in the background, losing at most one interval of
+	// writes on a machine crash.
+	FsyncInterval FsyncPolicy = "interval"
+	// FsyncNever leaves flushing to the operating system.
+	FsyncNever FsyncPolicy = "never"
+)
+
+var (
+	fileLogCRCTable = crc32.MakeTable(crc32.Castagnoli)
+	errTornRecord   = errors.New("torn record")
+)
+
+type fileSegment struct {
+	id   uint64
+	path string
+	file *os.File
+	size int64
+
+	// readers counts the streams still reading the segment. A removed
+	// segment is unlinked right away but only closed after the last of them.
+	mutex   sync.Mutex
+	readers int
+	removed bool
+}
+
+// acquire keeps the segment open until the matching release.
+func (s *fileSegment) acquire() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.readers++
+}
+
+func (s *fileSegment) release() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.readers--
+	if s.readers == 0 && s.removed {
+		s.file.Close()
+	}
+}
+
+// remove deletes the segment file, closing it unless it is still read.
+func (s *fileSegment) remove() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.removed = true
+	if s.readers == 0 {
+		s.file.Close()
+	}
+	return os.Remove(s.path)
+}
+
+func (s *fileSegment) readRecord(offset int64) ([]byte, error) {
+	r := io.NewSectionReader(s.file, offset, fileRecordHeaderSize+fileMaxRecordSize)
+	payload, _, err := readFileRecord(r)
+	return payload, err
+}
+
+// fileSegmentLog is a directory of append-only segments. Only the last
+// segment is written to; it is sealed and a new one started once it grows
+// past maxSize.
+type fileSegmentLog struct {
+	mutex    sync.Mutex
+	dir      string
+	maxSize  int64
+	fsync    FsyncPolicy
+	segments []*fileSegment
+	dirty    bool
+}
+
+// openFileSegmentLog opens or creates the log in dir and replays every
+// record through onRecord. A torn record at the end of the last segment,
+// left by a crash in the middle of a write, is truncated away.
+func openFileSegmentLog(dir string, maxSize int64, fsync FsyncPolicy, onRecord func(segment *fileSegment, offset int64, payload []byte) error) (*fileSegmentLog, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, fileSegmentExt) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, fileSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	l := &fileSegmentLog{dir: dir, maxSize: maxSize, fsync: fsync}
+
+	for i, id := range ids {
+		segment, err := l.openSegment(id)
+		if err != nil {
+			l.close()
+			return nil, err
+		}
+		l.segments = append(l.segments, segment)
+
+		if err := l.recoverSegment(segment, i == len(ids)-1, onRecord); err != nil {
+			l.close()
+			return nil, err
+		}
+	}
+
+	if len(l.segments) == 0 {
+		if err := l.roll(); err != nil {
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+func (l *fileSegmentLog) segmentPath(id uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d%s", id, fileSegmentExt))
+}
+
+func (l *fileSegmentLog) openSegment(id uint64) (*fileSegment, error) {
+	path := l.segmentPath(id)
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &fileSegment{id: id, path: path, file: file, size: info.Size()}, nil
+}
+
+func (l *fileSegmentLog) recoverSegment(segment *fileSegment, last bool, onRecord func(*fileSegment, int64, []byte) error) error {
+	r := bufio.NewReader(io.NewSectionReader(segment.file, 0, segment.size))
+	offset := int64(0)
+
+	for offset < segment.size {
+		payload, n, err := readFileRecord(r)
+		if err != nil {
+			if !last {
+				return fmt.Errorf("segment %s is corrupted at offset %d: %w", segment.path, offset, err)
+			}
+
+			log.Printf("truncating torn write at offset %d of %s (%d bytes lost)\n", offset, segment.path, segment.size-offset)
+			if err := segment.file.Truncate(offset); err != nil {
+				return err
+			}
+			if err := segment.file.Sync(); err != nil {
+				return err
+			}
+			segment.size = offset
+			break
+		}
+
+		if err := onRecord(segment, offset, payload); err != nil {
+			return err
+		}
+		offset += int64(n)
+	}
+
+	return nil
+}
+
+// roll seals the active segment and starts a new one.
+func (l *fileSegmentLog) roll() error {
+	id := uint64(1)
+	if n := len(l.segments); n > 0 {
+		id = l.segments[n-1].id + 1
+		if err := l.segments[n-1].file.Sync(); err != nil {
+			return err
+		}
+	}
+
+	segment, err := l.openSegment(id)
+	if err != nil {
+		return err
+	}
+	l.segments = append(l.segments, segment)
+	return nil
+}
+
+func (l *fileSegmentLog) active() *fileSegment {
+	return l.segments[len(l.segments)-1]
+}
+
+func (l *fileSegmentLog) append(payload []byte) (segment *fileSegment, offset int64, err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.maxSize > 0 && l.active().size >= l.maxSize {
+		if err := l.roll(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	segment = l.active()
+	offset = segment.size
+
+	n, err := segment.file.WriteAt(encodeFileRecord(payload), offset)
+	if err != nil {
+		// leave no partial record behind for the next append to build on.
+		segment.file.Truncate(offset)
+		return nil, 0, err
+	}
+	segment.size += int64(n)
+
+	switch l.fsync {
+	case FsyncAlways:
+		if err := segment.file.Sync(); err != nil {
+			return nil, 0, err
+		}
+	case FsyncInterval:
+		l.dirty = true
+	}
+
+	return segment, offset, nil
+}
+
+func (l *fileSegmentLog) sync() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if !l.dirty {
+		return nil
+	}
+	l.dirty = false
+	return l.active().file.Sync()
+}
+
+// sealed returns the segments that no longer receive writes.
+func (l *fileSegmentLog) sealed() []*fileSegment {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return append([]*fileSegment(nil), l.segments[:len(l.segments)-1]...)
+}
+
+func (l *fileSegmentLog) removeSegment(segment *fileSegment) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for i, s := range l.segments {
+		if s == segment && i < len(l.segments)-1 {
+			l.segments = append(l.segments[:i], l.segments[i+1:]...)
+			return segment.remove()
+		}
+	}
+
+	return fmt.Errorf("segment %s is not a sealed segment of %s", segment.path, l.dir)
+}
+
+// rewrite atomically replaces the whole log with a single segment holding
+// payloads, returning where each of them was written.
+func (l *fileSegmentLog) rewrite(payloads [][]byte) (*fileSegment, []int64, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	id := l.active().id + 1
+	path := l.segmentPath(id)
+	tmp := path + ".tmp"
+
+	file, err := os.Create(tmp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	offsets := make([]int64, len(payloads))
+	w := bufio.NewWriter(file)
+	offset := int64(0)
+
+	for i, payload := range payloads {
+		offsets[i] = offset
+		n, err := w.Write(encodeFileRecord(payload))
+		if err != nil {
+			file.Close()
+			os.Remove(tmp)
+			return nil, nil, err
+		}
+		offset += int64(n)
+	}
+
+	if err := w.Flush(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return nil, nil, err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return nil, nil, err
+	}
+	file.Close()
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, nil, err
+	}
+	// the rename is only durable once the directory is synced.
+	if err := syncDir(l.dir); err != nil {
+		return nil, nil, err
+	}
+
+	segment, err := l.openSegment(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, old := range l.segments {
+		old.file.Close()
+		os.Remove(old.path)
+	}
+	l.segments = []*fileSegment{segment}
+	l.dirty = false
+
+	if err := syncDir(l.dir); err != nil {
+		return nil, nil, err
+	}
+	return segment, offsets, nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (l *fileSegmentLog) close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var firstErr error
+	for i, segment := range l.segments {
+		if i == len(l.segments)-1 && l.fsync != FsyncNever {
+			if err := segment.file.Sync(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		if err := segment.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func encodeFileRecord(payload []byte) []byte {
+	record := make([]byte, fileRecordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(payload, fileLogCRCTable))
+	copy(record[fileRecordHeaderSize:], payload)
+	return record
+}
+
+func readFileRecord(r io.Reader) (payload []byte, n int, err error) {
+	var header [fileRecordHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, 0, fmt.Errorf("%w: short header: %v", errTornRecord, err)
+	}
+
+	size := binary.LittleEndian.Uint32(header[0:4])
+	checksum := binary.LittleEndian.Uint32(header[4:8])
+
+	if size > fileMaxRecordSize {
+		return nil, 0, fmt.Errorf("%w: record of %d bytes", errTornRecord, size)
+	}
+
+	payload = make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, 0, fmt.Errorf("%w: short payload: %v", errTornRecord, err)
+	}
+
+	if crc32.Checksum(payload, fileLogCRCTable) != checksum {
+		return nil, 0, fmt.Errorf("%w: checksum mismatch", errTornRecord)
+	}
+
+	return payload, fileRecordHeaderSize + int(size), nil
+}
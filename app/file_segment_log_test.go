@@ -0,0 +1,141 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openTestSegmentLog opens the log in dir, returning the payloads replayed.
+func openTestSegmentLog(t *testing.T, dir string, maxSize int64) (*fileSegmentLog, []string, error) {
+	t.Helper()
+
+	var payloads []string
+	l, err := openFileSegmentLog(dir, maxSize, FsyncNever, func(segment *fileSegment, offset int64, payload []byte) error {
+		payloads = append(payloads, string(payload))
+		return nil
+	})
+	if err == nil {
+		t.Cleanup(func() { l.close() })
+	}
+	return l, payloads, err
+}
+
+func appendTestRecords(t *testing.T, l *fileSegmentLog, payloads ...string) {
+	t.Helper()
+	for _, payload := range payloads {
+		if _, _, err := l.append([]byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadFileRecordChecksTheCRC(t *testing.T) {
+	record := encodeFileRecord([]byte("payload"))
+
+	payload, n, err := readFileRecord(strings.NewReader(string(record)))
+	if err != nil || string(payload) != "payload" || n != len(record) {
+		t.Fatalf("read %q of %d bytes, %v; want the payload of %d bytes", payload, n, err, len(record))
+	}
+
+	record[len(record)-1] ^= 0xff
+	if _, _, err := readFileRecord(strings.NewReader(string(record))); !errors.Is(err, errTornRecord) || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("flipped payload: error = %v, want a checksum mismatch", err)
+	}
+}
+
+func TestFileSegmentLogTruncatesTornWrites(t *testing.T) {
+	dir := t.TempDir()
+
+	l, _, err := openTestSegmentLog(t, dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendTestRecords(t, l, "a", "b")
+	path, size := l.active().path, l.active().size
+	l.close()
+
+	// a crash in the middle of writing "c" leaves half of its record.
+	torn := encodeFileRecord([]byte("c"))
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write(torn[:len(torn)-1])
+	file.Close()
+
+	l, payloads, err := openTestSegmentLog(t, dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(payloads) != "[a b]" {
+		t.Errorf("replayed %v, want [a b]", payloads)
+	}
+	if info, _ := os.Stat(path); info.Size() != size {
+		t.Errorf("segment of %d bytes after recovery, want the %d before the torn write", info.Size(), size)
+	}
+
+	appendTestRecords(t, l, "c")
+	l.close()
+
+	if _, payloads, err = openTestSegmentLog(t, dir, 0); err != nil || fmt.Sprint(payloads) != "[a b c]" {
+		t.Errorf("replayed %v, %v after appending again; want [a b c]", payloads, err)
+	}
+}
+
+func TestFileSegmentLogRefusesCorruptSealedSegments(t *testing.T) {
+	dir := t.TempDir()
+
+	// every record rolls into a segment of its own.
+	l, _, err := openTestSegmentLog(t, dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendTestRecords(t, l, "a", "b")
+	sealed := l.sealed()[0].path
+	l.close()
+
+	data, err := os.ReadFile(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(sealed, data, 0o644)
+
+	if _, _, err := openTestSegmentLog(t, dir, 1); err == nil || !strings.Contains(err.Error(), "corrupted") {
+		t.Errorf("corrupt sealed segment: error = %v, want it refused", err)
+	}
+}
+
+func TestFileSegmentLogRewrite(t *testing.T) {
+	dir := t.TempDir()
+
+	l, _, err := openTestSegmentLog(t, dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendTestRecords(t, l, "a", "b", "c")
+
+	segment, offsets, err := l.rewrite([][]byte{[]byte("x"), []byte("y")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"x", "y"} {
+		if payload, err := segment.readRecord(offsets[i]); err != nil || string(payload) != want {
+			t.Errorf("record at offset %d = %q, %v; want %q", offsets[i], payload, err, want)
+		}
+	}
+	l.close()
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != filepath.Base(segment.path) {
+		t.Errorf("files after the rewrite = %v, want only %s", entries, filepath.Base(segment.path))
+	}
+
+	if _, payloads, err := openTestSegmentLog(t, dir, 1); err != nil || fmt.Sprint(payloads) != "[x y]" {
+		t.Errorf("replayed %v, %v after the rewrite; want [x y]", payloads, err)
+	}
+}
@@ -0,0 +1,755 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type SegmentLayout string
+
+const (
+	// GlobalSegments appends every client to the same sequence of segments.
+	GlobalSegments SegmentLayout = "global"
+	// ClientSegments keeps a sequence of segments per client.
+	ClientSegments SegmentLayout = "client"
+
+	DefaultFileSegmentSize = 64 << 20
+)
+
+type FileStoreOptions struct {
+	Dir             string
+	Layout          SegmentLayout
+	Fsync           FsyncPolicy
+	FsyncInterval   time.Duration
+	SegmentSize     int64
+	CompactInterval time.Duration
+	// Leases, when set, fences off writes below the token of the current
+	// lease of their client, before the new owner writes anything.
+	Leases LeaseStore
+}
+
+// fileRecordRef locates a transaction inside a segment. The whole log is
+// indexed in memory at startup; payloads are only read on demand.
+type fileRecordRef struct {
+	segment   *fileSegment
+	offset    int64
+	revision  int
+	timestamp int64
+}
+
+// fileTransactionRecord is a record of the event log. The ones written
+// before versioning are plain transactions. Fence is the fencing token of
+// the write, so the store still refuses stale writers after a restart.
+type fileTransactionRecord struct {
+	EventType    string `json:"event_type,omitempty"`
+	EventVersion int    `json:"event_version,omitempty"`
+	Fence        int64  `json:"fence,omitempty"`
+	Transaction
+}
+
+// decodeFileTransaction decodes a record of the event log, upcasting it to
+// the current version.
+func decodeFileTransaction(payload []byte) (Transaction, error) {
+	var record fileTransactionRecord
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return Transaction{}, err
+	}
+
+	err := eventUpcasters.UpcastRecord(TransactionEventType, record.EventType, record.EventVersion, &record.Transaction)
+	return record.Transaction, err
+}
+
+// fileSnapshotRecord is a record of the snapshot log. A reset record opens
+// every rewritten log so a crash between writing the new segment and
+// deleting the old ones cannot resurrect discarded snapshots. A prune record
+// drops the older snapshots of a client until compaction rewrites the log.
+type fileSnapshotRecord struct {
+	Reset     bool               `json:"reset,omitempty"`
+	EventType string             `json:"event_type,omitempty"`
+	Snapshot  *Snapshot          `json:"snapshot,omitempty"`
+	Prune     *fileSnapshotPrune `json:"prune,omitempty"`
+	Compacted *fileCompaction    `json:"compacted,omitempty"`
+}
+
+// fileSnapshotPrune keeps the Keep snapshots of the client with the highest
+// revisions.
+type fileSnapshotPrune struct {
+	ClientID int `json:"client_id"`
+	Keep     int `json:"keep"`
+}
+
+// fileCompaction records that compaction dropped the events of the client
+// up to Revision. It is written before the segments are deleted.
+type fileCompaction struct {
+	ClientID int `json:"client_id"`
+	Revision int `json:"revision"`
+}
+
+func newFileSnapshotRecord(snapshot Snapshot) fileSnapshotRecord {
+	return fileSnapshotRecord{EventType: SnapshotEventType, Snapshot: &snapshot}
+}
+
+// FileTransactionStore is a TransactionStore over append-only segment files.
+// Since actors keep their state in memory, the store only needs to append
+// and to replay a client from its last snapshot, which plain files do well.
+type FileTransactionStore struct {
+	options FileStoreOptions
+
+	logsMutex sync.Mutex
+	logs      map[int]*fileSegmentLog
+
+	writersMutex sync.Mutex
+	writers      map[int]*fileClientWriter
+
+	indexMutex sync.RWMutex
+	index      map[int][]fileRecordRef
+	snapshots  map[int][]Snapshot
+	// compacted maps each client to the revision its events were compacted
+	// through.
+	compacted map[int]int
+	// pruned counts the records of the snapshot log dropped by prunes since
+	// it was last rewritten.
+	pruned int
+
+	snapshotLog *fileSegmentLog
+	lock        *os.File
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// fileClientWriter makes the checks and the append of Add atomic for a
+// client, without holding up the writes of the others. fence is the greatest
+// fencing token written for the client.
+type fileClientWriter struct {
+	mutex sync.Mutex
+	fence int64
+}
+
+func OpenFileTransactionStore(options FileStoreOptions) (*FileTransactionStore, error) {
+	if options.Layout == "" {
+		options.Layout = GlobalSegments
+	}
+	if options.Fsync == "" {
+		options.Fsync = FsyncInterval
+	}
+	if options.FsyncInterval <= 0 {
+		options.FsyncInterval = time.Second
+	}
+	if options.SegmentSize <= 0 {
+		options.SegmentSize = DefaultFileSegmentSize
+	}
+
+	switch options.Layout {
+	case GlobalSegments, ClientSegments:
+	default:
+		return nil, fmt.Errorf("invalid segment layout %q", options.Layout)
+	}
+
+	switch options.Fsync {
+	case FsyncAlways, FsyncInterval, FsyncNever:
+	default:
+		return nil, fmt.Errorf("invalid fsync policy %q", options.Fsync)
+	}
+
+	if err := os.MkdirAll(options.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	lock, err := lockDir(options.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileTransactionStore{
+		options:   options,
+		logs:      make(map[int]*fileSegmentLog),
+		index:     make(map[int][]fileRecordRef),
+		writers:   make(map[int]*fileClientWriter),
+		snapshots: make(map[int][]Snapshot),
+		compacted: make(map[int]int),
+		lock:      lock,
+		stop:      make(chan struct{}),
+	}
+
+	if err := s.openLogs(); err != nil {
+		s.closeLogs()
+		lock.Close()
+		return nil, err
+	}
+
+	if options.Fsync == FsyncInterval {
+		s.runEvery(options.FsyncInterval, s.sync)
+	}
+	if options.CompactInterval > 0 {
+		s.runEvery(options.CompactInterval, func() {
+			if err := s.Compact(); err != nil {
+				log.Printf("error compacting event log: %v\n", err)
+			}
+		})
+	}
+
+	return s, nil
+}
+
+func (s *FileTransactionStore) openLogs() error {
+	var err error
+
+	s.snapshotLog, err = openFileSegmentLog(filepath.Join(s.options.Dir, "snapshots"), 0, FsyncAlways, s.loadSnapshot)
+	if err != nil {
+		return err
+	}
+
+	if s.options.Layout == GlobalSegments {
+		s.logs[0], err = openFileSegmentLog(filepath.Join(s.options.Dir, "events"), s.options.SegmentSize, s.options.Fsync, s.loadTransaction)
+		return err
+	}
+
+	dir := filepath.Join(s.options.Dir, "clients")
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, entry := range entries {
+		clientID, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+
+		s.logs[clientID], err = openFileSegmentLog(filepath.Join(dir, entry.Name()), s.options.SegmentSize, s.options.Fsync, s.loadTransaction)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *FileTransactionStore) loadTransaction(segment *fileSegment, offset int64, payload []byte) error {
+	var record fileTransactionRecord
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return fmt.Errorf("error decoding transaction at offset %d of %s: %w", offset, segment.path, err)
+	}
+
+	s.insertRef(record.ClientID, fileRecordRef{
+		segment:   segment,
+		offset:    offset,
+		revision:  record.Revision,
+		timestamp: record.Timestamp.UnixNano(),
+	})
+
+	if w := s.writer(record.ClientID); record.Fence > w.fence {
+		w.fence = record.Fence
+	}
+	return nil
+}
+
+// insertRef adds ref to the index of the client, keeping it sorted by
+// revision: retried writes land after the ones that followed them.
+func (s *FileTransactionStore) insertRef(clientID int, ref fileRecordRef) {
+	refs := s.index[clientID]
+	i := sort.Search(len(refs), func(i int) bool { return refs[i].revision > ref.revision })
+	s.index[clientID] = slices.Insert(refs, i, ref)
+}
+
+func (s *FileTransactionStore) loadSnapshot(segment *fileSegment, offset int64, payload []byte) error {
+	var record fileSnapshotRecord
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return fmt.Errorf("error decoding snapshot at offset %d of %s: %w", offset, segment.path, err)
+	}
+
+	if record.Reset {
+		s.snapshots = make(map[int][]Snapshot)
+		s.compacted = make(map[int]int)
+		s.pruned = 0
+	}
+	if record.Snapshot != nil {
+		if err := eventUpcasters.UpcastRecord(SnapshotEventType, record.EventType, record.Snapshot.Version, record.Snapshot); err != nil {
+			return fmt.Errorf("error decoding snapshot at offset %d of %s: %w", offset, segment.path, err)
+		}
+		s.snapshots[record.Snapshot.ClientID] = append(s.snapshots[record.Snapshot.ClientID], *record.Snapshot)
+	}
+	if record.Prune != nil {
+		s.pruneSnapshots(record.Prune.ClientID, record.Prune.Keep)
+	}
+	if record.Compacted != nil {
+		s.compacted[record.Compacted.ClientID] = record.Compacted.Revision
+	}
+	return nil
+}
+
+// logFor returns the log that receives the writes of a client, creating its
+// directory on first use with per-client segments.
+func (s *FileTransactionStore) logFor(clientID int) (*fileSegmentLog, error) {
+	s.logsMutex.Lock()
+	defer s.logsMutex.Unlock()
+
+	if s.options.Layout == GlobalSegments {
+		return s.logs[0], nil
+	}
+
+	if l, ok := s.logs[clientID]; ok {
+		return l, nil
+	}
+
+	dir := filepath.Join(s.options.Dir, "clients", strconv.Itoa(clientID))
+	l, err := openFileSegmentLog(dir, s.options.SegmentSize, s.options.Fsync, s.loadTransaction)
+	if err != nil {
+		return nil, err
+	}
+	s.logs[clientID] = l
+	return l, nil
+}
+
+func (s *FileTransactionStore) Add(ctx context.Context, transaction Transaction) error {
+	payload, err := json.Marshal(fileTransactionRecord{
+		EventType:    TransactionEventType,
+		EventVersion: TransactionEventVersion,
+		Fence:        transaction.FencingToken,
+		Transaction:  transaction,
+	})
+	if err != nil {
+		return err
+	}
+
+	l, err := s.logFor(transaction.ClientID)
+	if err != nil {
+		return err
+	}
+
+	w := s.writer(transaction.ClientID)
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if err := checkFence(ctx, s.options.Leases, transaction, w.fence); err != nil {
+		return err
+	}
+
+	if s.hasRevision(transaction.ClientID, transaction.Revision) {
+		return &RevisionConflictError{ClientID: transaction.ClientID, Revision: transaction.Revision}
+	}
+
+	segment, offset, err := l.append(payload)
+	if err != nil {
+		return err
+	}
+
+	s.indexMutex.Lock()
+	s.insertRef(transaction.ClientID, fileRecordRef{
+		segment:   segment,
+		offset:    offset,
+		revision:  transaction.Revision,
+		timestamp: transaction.Timestamp.UnixNano(),
+	})
+	s.indexMutex.Unlock()
+
+	w.fence = transaction.FencingToken
+	return nil
+}
+
+func (s *FileTransactionStore) writer(clientID int) *fileClientWriter {
+	s.writersMutex.Lock()
+	defer s.writersMutex.Unlock()
+
+	w, ok := s.writers[clientID]
+	if !ok {
+		w = &fileClientWriter{}
+		s.writers[clientID] = w
+	}
+	return w
+}
+
+// hasRevision tells whether the index of a client already holds revision.
+func (s *FileTransactionStore) hasRevision(clientID int, revision int) bool {
+	s.indexMutex.RLock()
+	defer s.indexMutex.RUnlock()
+
+	refs := s.index[clientID]
+	i := sort.Search(len(refs), func(i int) bool { return refs[i].revision >= revision })
+	return i < len(refs) && refs[i].revision == revision
+}
+
+func (s *FileTransactionStore) GetTransactionHistory(ctx context.Context, clientID int) (lastSnapshot Snapshot, transactions []Transaction, err error) {
+	lastSnapshot = s.getLastSnapshot(clientID)
+
+	if lastSnapshot.Revision > 0 {
+		log.Printf("loading state of client %d from snapshot revision %d:\n", clientID, lastSnapshot.Revision)
+	}
+
+	err = s.StreamTransactions(ctx, clientID, TransactionFilter{FromRevision: lastSnapshot.ReplayFrom()}, func(t Transaction) error {
+		transactions = append(transactions, t)
+		return nil
+	})
+	if err != nil {
+		return lastSnapshot, nil, err
+	}
+
+	return lastSnapshot, transactions, nil
+}
+
+func (s *FileTransactionStore) StreamTransactions(ctx context.Context, clientID int, filter TransactionFilter, fn func(Transaction) error) error {
+	// the segments are acquired with the index locked, so a compaction
+	// removing them meanwhile leaves them open until the stream is done.
+	s.indexMutex.RLock()
+	refs := append([]fileRecordRef(nil), s.index[clientID]...)
+	segments := make(map[*fileSegment]bool)
+	for _, ref := range refs {
+		if !segments[ref.segment] {
+			segments[ref.segment] = true
+			ref.segment.acquire()
+		}
+	}
+	s.indexMutex.RUnlock()
+
+	defer func() {
+		for segment := range segments {
+			segment.release()
+		}
+	}()
+
+	for _, ref := range refs {
+		if filter.FromRevision > 0 && ref.revision < filter.FromRevision {
+			continue
+		}
+		if !filter.From.IsZero() && ref.timestamp < filter.From.UnixNano() {
+			continue
+		}
+		if !filter.To.IsZero() && ref.timestamp >= filter.To.UnixNano() {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		payload, err := ref.segment.readRecord(ref.offset)
+		if err != nil {
+			return fmt.Errorf("error reading revision %d of client %d from %s: %w", ref.revision, clientID, ref.segment.path, err)
+		}
+
+		t, err := decodeFileTransaction(payload)
+		if err != nil {
+			return fmt.Errorf("error decoding revision %d of client %d from %s: %w", ref.revision, clientID, ref.segment.path, err)
+		}
+
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *FileTransactionStore) ListSnapshots(ctx context.Context, clientID int) ([]Snapshot, error) {
+	s.indexMutex.RLock()
+	snapshots := append([]Snapshot(nil), s.snapshots[clientID]...)
+	s.indexMutex.RUnlock()
+
+	sort.SliceStable(snapshots, func(i, j int) bool { return snapshots[i].Revision < snapshots[j].Revision })
+	return snapshots, nil
+}
+
+func (s *FileTransactionStore) ReplaceSnapshots(ctx context.Context, clientID int, snapshots []Snapshot) error {
+	s.indexMutex.Lock()
+	defer s.indexMutex.Unlock()
+
+	replaced := make(map[int][]Snapshot, len(s.snapshots))
+	for id, list := range s.snapshots {
+		if id != clientID {
+			replaced[id] = list
+		}
+	}
+	for _, snapshot := range snapshots {
+		snapshot.ClientID = clientID
+		replaced[clientID] = append(replaced[clientID], snapshot)
+	}
+
+	if err := s.rewriteSnapshotLog(replaced); err != nil {
+		return err
+	}
+
+	s.snapshots = replaced
+	return nil
+}
+
+// Compact deletes sealed segments whose transactions are all covered by the
+// last snapshot of their client, recording the revision each client was
+// compacted through, and then rewrites the snapshot log without the
+// snapshots pruned since the last compaction. Only the events a rebuild
+// still reads are kept, so exports and verification of a compacted client
+// start from the snapshot that covers the events dropped.
+func (s *FileTransactionStore) Compact() error {
+	s.logsMutex.Lock()
+	logs := make([]*fileSegmentLog, 0, len(s.logs))
+	for _, l := range s.logs {
+		logs = append(logs, l)
+	}
+	s.logsMutex.Unlock()
+
+	for _, l := range logs {
+		for _, segment := range l.sealed() {
+			if !s.isCovered(segment) {
+				continue
+			}
+
+			if err := s.dropSegment(segment); err != nil {
+				return err
+			}
+
+			if err := l.removeSegment(segment); err != nil {
+				return err
+			}
+			log.Printf("compacted event log segment %s\n", segment.path)
+		}
+	}
+
+	s.indexMutex.Lock()
+	defer s.indexMutex.Unlock()
+
+	if s.pruned == 0 {
+		return nil
+	}
+	return s.rewriteSnapshotLog(s.snapshots)
+}
+
+// dropSegment records how far the clients of segment are compacted and
+// removes its transactions from the index.
+func (s *FileTransactionStore) dropSegment(segment *fileSegment) error {
+	s.indexMutex.Lock()
+	defer s.indexMutex.Unlock()
+
+	for clientID, refs := range s.index {
+		revision := s.compacted[clientID]
+		for _, ref := range refs {
+			if ref.segment == segment && ref.revision > revision {
+				revision = ref.revision
+			}
+		}
+		if revision == s.compacted[clientID] {
+			continue
+		}
+
+		payload, err := json.Marshal(fileSnapshotRecord{Compacted: &fileCompaction{ClientID: clientID, Revision: revision}})
+		if err != nil {
+			return err
+		}
+		if _, _, err := s.snapshotLog.append(payload); err != nil {
+			return err
+		}
+		s.compacted[clientID] = revision
+	}
+
+	for clientID, refs := range s.index {
+		kept := refs[:0]
+		for _, ref := range refs {
+			if ref.segment != segment {
+				kept = append(kept, ref)
+			}
+		}
+		s.index[clientID] = kept
+	}
+
+	return nil
+}
+
+// CompactedThrough returns the last revision of the client dropped by
+// Compact, 0 when none was.
+func (s *FileTransactionStore) CompactedThrough(clientID int) (int, error) {
+	s.indexMutex.RLock()
+	defer s.indexMutex.RUnlock()
+
+	return s.compacted[clientID], nil
+}
+
+func (s *FileTransactionStore) isCovered(segment *fileSegment) bool {
+	s.indexMutex.RLock()
+	defer s.indexMutex.RUnlock()
+
+	for clientID, refs := range s.index {
+		var lastSnapshot Snapshot
+		for _, snapshot := range s.snapshots[clientID] {
+			if snapshot.Revision > lastSnapshot.Revision {
+				lastSnapshot = snapshot
+			}
+		}
+
+		for _, ref := range refs {
+			if ref.segment == segment && ref.revision >= lastSnapshot.ReplayFrom() {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (s *FileTransactionStore) Close() error {
+	close(s.stop)
+	s.done.Wait()
+
+	err := s.closeLogs()
+	s.lock.Close()
+	return err
+}
+
+func (s *FileTransactionStore) closeLogs() error {
+	s.logsMutex.Lock()
+	defer s.logsMutex.Unlock()
+
+	var firstErr error
+	for _, l := range s.logs {
+		if err := l.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if s.snapshotLog != nil {
+		if err := s.snapshotLog.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (s *FileTransactionStore) sync() {
+	s.logsMutex.Lock()
+	defer s.logsMutex.Unlock()
+
+	for _, l := range s.logs {
+		if err := l.sync(); err != nil {
+			log.Printf("error syncing event log %s: %v\n", l.dir, err)
+		}
+	}
+}
+
+func (s *FileTransactionStore) runEvery(interval time.Duration, fn func()) {
+	s.done.Add(1)
+
+	go func() {
+		defer s.done.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	}()
+}
+
+func (s *FileTransactionStore) getLastSnapshot(clientID int) (lastSnapshot Snapshot) {
+	s.indexMutex.RLock()
+	defer s.indexMutex.RUnlock()
+
+	for _, snapshot := range s.snapshots[clientID] {
+		if snapshot.Revision > lastSnapshot.Revision {
+			lastSnapshot = snapshot
+		}
+	}
+	return lastSnapshot
+}
+
+func (s *FileTransactionStore) SaveSnapshot(ctx context.Context, snapshot Snapshot) error {
+	payload, err := json.Marshal(newFileSnapshotRecord(snapshot))
+	if err != nil {
+		return err
+	}
+
+	s.indexMutex.Lock()
+	defer s.indexMutex.Unlock()
+
+	if _, _, err := s.snapshotLog.append(payload); err != nil {
+		return err
+	}
+
+	s.snapshots[snapshot.ClientID] = append(s.snapshots[snapshot.ClientID], snapshot)
+	return nil
+}
+
+// PruneSnapshots appends a prune record to the snapshot log; the pruned
+// snapshots are only dropped from the file when Compact rewrites it.
+func (s *FileTransactionStore) PruneSnapshots(ctx context.Context, clientID int, keep int) error {
+	s.indexMutex.Lock()
+	defer s.indexMutex.Unlock()
+
+	if len(s.snapshots[clientID]) <= keep {
+		return nil
+	}
+
+	payload, err := json.Marshal(fileSnapshotRecord{Prune: &fileSnapshotPrune{ClientID: clientID, Keep: keep}})
+	if err != nil {
+		return err
+	}
+	if _, _, err := s.snapshotLog.append(payload); err != nil {
+		return err
+	}
+
+	s.pruneSnapshots(clientID, keep)
+	return nil
+}
+
+// pruneSnapshots keeps the keep snapshots of the client with the highest
+// revisions.
+func (s *FileTransactionStore) pruneSnapshots(clientID int, keep int) {
+	snapshots := s.snapshots[clientID]
+	if len(snapshots) <= keep {
+		return
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool { return snapshots[i].Revision < snapshots[j].Revision })
+	s.pruned += len(snapshots) - keep + 1
+	s.snapshots[clientID] = append([]Snapshot(nil), snapshots[len(snapshots)-keep:]...)
+}
+
+// DeleteTransactions is not supported: segments are append-only and shared,
+// and Compact already drops the ones covered by snapshots.
+func (s *FileTransactionStore) DeleteTransactions(ctx context.Context, clientID int, revision int) (int64, error) {
+	return 0, ErrDeleteUnsupported
+}
+
+// rewriteSnapshotLog replaces the snapshot log with snapshots and the
+// revisions the clients were compacted through. indexMutex must be held.
+func (s *FileTransactionStore) rewriteSnapshotLog(snapshots map[int][]Snapshot) error {
+	reset, err := json.Marshal(fileSnapshotRecord{Reset: true})
+	if err != nil {
+		return err
+	}
+
+	payloads := [][]byte{reset}
+	for _, list := range snapshots {
+		for _, snapshot := range list {
+			payload, err := json.Marshal(newFileSnapshotRecord(snapshot))
+			if err != nil {
+				return err
+			}
+			payloads = append(payloads, payload)
+		}
+	}
+	for clientID, revision := range s.compacted {
+		payload, err := json.Marshal(fileSnapshotRecord{Compacted: &fileCompaction{ClientID: clientID, Revision: revision}})
+		if err != nil {
+			return err
+		}
+		payloads = append(payloads, payload)
+	}
+
+	if _, _, err = s.snapshotLog.rewrite(payloads); err != nil {
+		return err
+	}
+	s.pruned = 0
+	return nil
+}
@@ -0,0 +1,249 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileTransactionStoreRejectsDuplicateRevisions(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s, err := OpenFileTransactionStore(FileStoreOptions{Dir: dir, Fsync: FsyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// revision 2 is a retried write, landing after revision 3.
+	for _, revision := range []int{1, 3, 2} {
+		if err := s.Add(ctx, Transaction{ClientID: 1, Revision: revision, Amount: 10, Type: CreditTransaction, Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assertConflicts := func(s *FileTransactionStore) {
+		t.Helper()
+		for _, revision := range []int{1, 2, 3} {
+			err := s.Add(ctx, Transaction{ClientID: 1, Revision: revision, Amount: 10, Type: CreditTransaction, Timestamp: time.Now()})
+			if !errors.Is(err, ErrRevisionConflict) {
+				t.Errorf("adding revision %d again: error = %v, want ErrRevisionConflict", revision, err)
+			}
+		}
+
+		var revisions []int
+		s.StreamTransactions(ctx, 1, TransactionFilter{}, func(t Transaction) error {
+			revisions = append(revisions, t.Revision)
+			return nil
+		})
+		if fmt.Sprint(revisions) != "[1 2 3]" {
+			t.Errorf("revisions = %v, want [1 2 3]", revisions)
+		}
+	}
+
+	assertConflicts(s)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenFileTransactionStore(FileStoreOptions{Dir: dir, Fsync: FsyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	assertConflicts(s)
+}
+
+func TestFileTransactionStoreCompactsCoveredSegments(t *testing.T) {
+	ctx := context.Background()
+
+	// every transaction rolls into a segment of its own.
+	s, err := OpenFileTransactionStore(FileStoreOptions{Dir: t.TempDir(), Fsync: FsyncNever, SegmentSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for revision := 1; revision <= 5; revision++ {
+		if err := s.Add(ctx, Transaction{ClientID: 1, Revision: revision, Amount: 10, Type: CreditTransaction, Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SaveSnapshot(ctx, Snapshot{Version: FullStateSnapshotVersion, ClientID: 1, Revision: 3, Balance: 30, CreditLimit: 1000, CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	if segments := len(s.logs[0].segments); segments != 2 {
+		t.Errorf("%d segments left, want the 2 of the revisions after the snapshot", segments)
+	}
+
+	snapshot, transactions, err := s.GetTransactionHistory(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := Client{ID: 1}
+	client.RebuildStateFromHistory(snapshot, transactions)
+	if client.Balance != 50 || len(transactions) != 2 {
+		t.Errorf("rebuilt balance %d from %d transactions, want 50 from 2", client.Balance, len(transactions))
+	}
+}
+
+func TestFileTransactionStoreCompactsWhileStreaming(t *testing.T) {
+	ctx := context.Background()
+
+	s, err := OpenFileTransactionStore(FileStoreOptions{Dir: t.TempDir(), Fsync: FsyncNever, SegmentSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for revision := 1; revision <= 20; revision++ {
+		if err := s.Add(ctx, Transaction{ClientID: 1, Revision: revision, Amount: 10, Type: CreditTransaction, Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SaveSnapshot(ctx, Snapshot{Version: FullStateSnapshotVersion, ClientID: 1, Revision: 19, Balance: 190, CreditLimit: 1000, CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the segments the stream started with are removed under it.
+	read := 0
+	err = s.StreamTransactions(ctx, 1, TransactionFilter{}, func(t Transaction) error {
+		read++
+		if t.Revision == 1 {
+			return s.Compact()
+		}
+		return nil
+	})
+	if err != nil || read != 20 {
+		t.Fatalf("streamed %d transactions across the compaction, %v; want all 20", read, err)
+	}
+
+	for revision := 21; revision <= 40; revision++ {
+		if err := s.Add(ctx, Transaction{ClientID: 1, Revision: revision, Amount: 10, Type: CreditTransaction, Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SaveSnapshot(ctx, Snapshot{Version: FullStateSnapshotVersion, ClientID: 1, Revision: 39, Balance: 390, CreditLimit: 1000, CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.StreamTransactions(ctx, 1, TransactionFilter{}, func(t Transaction) error { return nil })
+		}()
+	}
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("stream concurrent with a compaction: %v", err)
+		}
+	}
+}
+
+func TestFileTransactionStorePrunesUntilCompaction(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	options := FileStoreOptions{Dir: dir, Fsync: FsyncNever, SegmentSize: 1}
+
+	s, err := OpenFileTransactionStore(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for revision := 1; revision <= 4; revision++ {
+		if err := s.Add(ctx, Transaction{ClientID: 1, Revision: revision, Amount: 10, Type: CreditTransaction, Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SaveSnapshot(ctx, Snapshot{Version: FullStateSnapshotVersion, ClientID: 1, Revision: revision, Balance: revision * 10, CreditLimit: 1000, CreatedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	segment := s.snapshotLog.active()
+	if err := s.PruneSnapshots(ctx, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if s.snapshotLog.active() != segment {
+		t.Error("prune rewrote the snapshot log")
+	}
+
+	assertSnapshots := func(s *FileTransactionStore, want string) {
+		t.Helper()
+		snapshots, _ := s.ListSnapshots(ctx, 1)
+		var revisions []int
+		for _, snapshot := range snapshots {
+			revisions = append(revisions, snapshot.Revision)
+		}
+		if fmt.Sprint(revisions) != want {
+			t.Errorf("snapshots at %v, want %s", revisions, want)
+		}
+	}
+	assertSnapshots(s, "[3 4]")
+
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if s.snapshotLog.active() == segment {
+		t.Error("compaction left the pruned snapshots in the log")
+	}
+	if compacted, _ := s.CompactedThrough(1); compacted != 3 {
+		t.Errorf("compacted through revision %d, want 3", compacted)
+	}
+	s.Close()
+
+	s, err = OpenFileTransactionStore(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	assertSnapshots(s, "[3 4]")
+	if compacted, _ := s.CompactedThrough(1); compacted != 3 {
+		t.Errorf("compacted through revision %d after reopening, want 3", compacted)
+	}
+}
+
+func TestFileTransactionStoreReloadsFullStateSnapshots(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := OpenFileTransactionStore(FileStoreOptions{Dir: dir, Fsync: FsyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testFullStateSnapshots(t, s)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenFileTransactionStore(FileStoreOptions{Dir: dir, Fsync: FsyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	snapshot, replayed, err := s.GetTransactionHistory(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !snapshot.IsFullState() || snapshot.CreditLimit != 1000 || len(snapshot.History) != HistorySize || len(replayed) != 0 {
+		t.Errorf("reloaded snapshot %+v with %d transactions to replay, want the full state one of revision 12", snapshot, len(replayed))
+	}
+}
@@ -0,0 +1,39 @@
+package app
+
+import (
+	"context"
+
+	"github.com/feralc/rinha-backend-2024/proto"
+)
+
+// ActorAdminService moves clients between backends. The router first hands
+// the client off on its old owner, then has the new owner hydrate it with the
+// revision the old one reached.
+type ActorAdminService struct {
+	*proto.UnimplementedActorAdminServiceServer
+	actorManager *ActorManager
+}
+
+func NewActorAdminService(actorManager *ActorManager) *ActorAdminService {
+	return &ActorAdminService{
+		actorManager: actorManager,
+	}
+}
+
+func (s *ActorAdminService) HandOff(ctx context.Context, req *proto.HandOffRequest) (*proto.HandOffResult, error) {
+	revision, err := s.actorManager.HandOff(int(req.ClientID))
+	if err != nil {
+		return nil, actorStatusError(err)
+	}
+
+	return &proto.HandOffResult{Revision: int64(revision)}, nil
+}
+
+func (s *ActorAdminService) Hydrate(ctx context.Context, req *proto.HydrateRequest) (*proto.HydrateResult, error) {
+	revision, err := s.actorManager.Hydrate(ctx, int(req.ClientID), int(req.Revision))
+	if err != nil {
+		return nil, actorStatusError(err)
+	}
+
+	return &proto.HydrateResult{Revision: int64(revision)}, nil
+}
@@ -3,29 +3,38 @@ package app
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/feralc/rinha-backend-2024/proto"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+const exportChunkSize = 32 * 1024
+
 type TransactionService struct {
 	*proto.UnimplementedTransactionServiceServer
-	actorManager *ActorManager
+	actorManager   *ActorManager
+	ledgerExporter *LedgerExporter
+	// statements serves GetHistory from the read model when set.
+	statements *Projector
 }
 
-func NewTransactionService(actorManager *ActorManager) *TransactionService {
-	return &TransactionService{actorManager: actorManager}
+// NewTransactionService serves statements from the actors, or from the read
+// model of statements when it is not nil.
+func NewTransactionService(actorManager *ActorManager, ledgerExporter *LedgerExporter, statements *Projector) *TransactionService {
+	return &TransactionService{
+		actorManager:   actorManager,
+		ledgerExporter: ledgerExporter,
+		statements:     statements,
+	}
 }
 
 func (s *TransactionService) DoTransaction(ctx context.Context, req *proto.TransactionRequest) (*proto.TransactionResult, error) {
-	actor, err := s.actorManager.Spawn(int(req.ClientID))
+	actor, err := s.actorManager.Spawn(ctx, int(req.ClientID))
 
 	if err != nil {
-		if errors.Is(err, ErrNotFound) {
-			return nil, status.Error(codes.NotFound, err.Error())
-		}
-		return nil, err
+		return nil, actorStatusError(err)
 	}
 
 	var txType TransactionType
@@ -37,7 +46,7 @@ func (s *TransactionService) DoTransaction(ctx context.Context, req *proto.Trans
 		txType = DebitTransaction
 	}
 
-	result := actor.Send(ActorMessage{
+	result := actor.SendContext(ctx, ActorMessage{
 		Type: TransactionMessage,
 		Payload: TransactionRequest{
 			Amount:      int(req.Amount),
@@ -47,7 +56,7 @@ func (s *TransactionService) DoTransaction(ctx context.Context, req *proto.Trans
 	})
 
 	if result.Error != nil {
-		return nil, result.Error
+		return nil, actorStatusError(result.Error)
 	}
 
 	data := result.Data.(SuccessTransactionResult)
@@ -59,25 +68,36 @@ func (s *TransactionService) DoTransaction(ctx context.Context, req *proto.Trans
 }
 
 func (s *TransactionService) GetHistory(ctx context.Context, req *proto.HistoryRequest) (*proto.AccountStatement, error) {
-	actor, err := s.actorManager.Spawn(int(req.ClientID))
+	// a client without transactions projected yet is left to its actor,
+	// which also tells whether it exists.
+	if s.statements != nil {
+		data, err := s.statements.Statement(ctx, int(req.ClientID))
+		if err == nil {
+			return accountStatement(data), nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return nil, actorStatusError(err)
+		}
+	}
+
+	actor, err := s.actorManager.Spawn(ctx, int(req.ClientID))
 
 	if err != nil {
-		if errors.Is(err, ErrNotFound) {
-			return nil, status.Error(codes.NotFound, err.Error())
-		}
-		return nil, err
+		return nil, actorStatusError(err)
 	}
 
-	result := actor.Send(ActorMessage{
+	result := actor.SendContext(ctx, ActorMessage{
 		Type: QueryHistoryMessage,
 	})
 
 	if result.Error != nil {
-		return nil, result.Error
+		return nil, actorStatusError(result.Error)
 	}
 
-	data := result.Data.(*TransactionHistory)
+	return accountStatement(result.Data.(*TransactionHistory)), nil
+}
 
+func accountStatement(data *TransactionHistory) *proto.AccountStatement {
 	lastTransactions := make([]*proto.Transaction, len(data.LastTransactions))
 
 	for i, t := range data.LastTransactions {
@@ -96,5 +116,98 @@ func (s *TransactionService) GetHistory(ctx context.Context, req *proto.HistoryR
 			Date:        data.Balance.Date.Unix(),
 		},
 		LastTransactions: lastTransactions,
+	}
+}
+
+func (s *TransactionService) ExportLedger(req *proto.ExportRequest, stream proto.TransactionService_ExportLedgerServer) error {
+	format, err := ParseLedgerFormat(req.Format)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var from, to time.Time
+	if req.From > 0 {
+		from = time.Unix(req.From, 0)
+	}
+	if req.To > 0 {
+		to = time.Unix(req.To, 0)
+	}
+
+	w := &exportChunkWriter{stream: stream}
+
+	err = s.ledgerExporter.Export(stream.Context(), int(req.ClientID), format, from, to, w)
+	if err != nil {
+		return actorStatusError(err)
+	}
+
+	return w.Flush()
+}
+
+func (s *TransactionService) GetActorStatus(ctx context.Context, req *proto.ActorStatusRequest) (*proto.ActorStatus, error) {
+	return &proto.ActorStatus{
+		Active: s.actorManager.IsActive(int(req.ClientID)),
 	}, nil
 }
+
+// actorStatusError maps the errors of spawning and messaging actors to gRPC
+// codes. Writes lost to another owner are Aborted; a client owned by another
+// process or being handed off, an actor stopping or an unreachable store is
+// Unavailable here. Requests whose deadline passed are DeadlineExceeded, and
+// Canceled when the caller went away.
+func actorStatusError(err error) error {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrRevisionConflict), errors.Is(err, ErrStaleFencingToken):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, ErrLeaseHeld), errors.Is(err, ErrActorStopped), errors.Is(err, ErrStoreUnavailable),
+		errors.Is(err, ErrClientHandedOff), errors.Is(err, ErrStaleHydration):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	}
+	return err
+}
+
+// exportChunkWriter buffers export output and sends it over the stream in
+// chunks of at most exportChunkSize bytes. A chunk sent may still be read by
+// the stream, so the next one gets a buffer of its own.
+type exportChunkWriter struct {
+	stream proto.TransactionService_ExportLedgerServer
+	buf    []byte
+}
+
+func (w *exportChunkWriter) Write(p []byte) (int, error) {
+	n := len(p)
+
+	for len(p) > 0 {
+		if w.buf == nil {
+			w.buf = make([]byte, 0, exportChunkSize)
+		}
+
+		free := min(len(p), exportChunkSize-len(w.buf))
+		w.buf = append(w.buf, p[:free]...)
+		p = p[free:]
+
+		if len(w.buf) == exportChunkSize {
+			if err := w.stream.Send(&proto.ExportChunk{Data: w.buf}); err != nil {
+				return 0, err
+			}
+			w.buf = nil
+		}
+	}
+
+	return n, nil
+}
+
+func (w *exportChunkWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	err := w.stream.Send(&proto.ExportChunk{Data: w.buf})
+	w.buf = nil
+	return err
+}
@@ -0,0 +1,51 @@
+package app
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/feralc/rinha-backend-2024/proto"
+	"google.golang.org/grpc"
+)
+
+// fakeExportStream keeps the chunks sent.
+type fakeExportStream struct {
+	grpc.ServerStream
+	chunks [][]byte
+}
+
+func (s *fakeExportStream) Send(chunk *proto.ExportChunk) error {
+	s.chunks = append(s.chunks, chunk.Data)
+	return nil
+}
+
+func TestExportChunkWriter(t *testing.T) {
+	stream := &fakeExportStream{}
+	w := &exportChunkWriter{stream: stream}
+
+	data := bytes.Repeat([]byte("0123456789"), exportChunkSize/4)
+	for rest := data; len(rest) > 0; {
+		n := min(len(rest), 7001)
+		if written, err := w.Write(rest[:n]); err != nil || written != n {
+			t.Fatalf("wrote %d of %d bytes: %v", written, n, err)
+		}
+		rest = rest[n:]
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var sent []byte
+	for i, chunk := range stream.chunks {
+		if len(chunk) > exportChunkSize || cap(chunk) > exportChunkSize {
+			t.Errorf("chunk %d of %d bytes in a buffer of %d, want at most %d", i, len(chunk), cap(chunk), exportChunkSize)
+		}
+		if i < len(stream.chunks)-1 && len(chunk) != exportChunkSize {
+			t.Errorf("chunk %d of %d bytes before the last one, want %d", i, len(chunk), exportChunkSize)
+		}
+		sent = append(sent, chunk...)
+	}
+	if len(stream.chunks) != 3 || !bytes.Equal(sent, data) {
+		t.Errorf("sent %d bytes in %d chunks, want the %d written in 3", len(sent), len(stream.chunks), len(data))
+	}
+}
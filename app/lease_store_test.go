@@ -0,0 +1,180 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openTestSQLite opens a migrated SQLite database in a temporary directory.
+func openTestSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "rinha.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := MigrateSQLite(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// testLeaseStore runs the LeaseStore contract against a store with no
+// lease for client 1.
+func testLeaseStore(t *testing.T, leases LeaseStore) {
+	ctx := context.Background()
+
+	if token, err := leases.Token(ctx, 1); err != nil || token != 0 {
+		t.Fatalf("token of a lease never granted = %d (%v), want 0", token, err)
+	}
+
+	first, err := leases.Acquire(ctx, 1, "a", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Owner != "a" || first.Token == 0 {
+		t.Fatalf("unexpected lease %+v", first)
+	}
+	if token, err := leases.Token(ctx, 1); err != nil || token != first.Token {
+		t.Errorf("token = %d (%v), want %d", token, err, first.Token)
+	}
+
+	if _, err := leases.Acquire(ctx, 1, "b", time.Minute); !errors.Is(err, ErrLeaseHeld) {
+		t.Fatalf("acquire of a held lease: error = %v, want ErrLeaseHeld", err)
+	}
+
+	renewed, err := leases.Renew(ctx, first, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.Token != first.Token || !renewed.ExpiresAt.After(first.ExpiresAt) {
+		t.Errorf("renewed lease %+v does not extend %+v", renewed, first)
+	}
+
+	// the same owner acquiring again gets a new token.
+	second, err := leases.Acquire(ctx, 1, "a", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Token <= first.Token {
+		t.Errorf("token %d of the second grant is not greater than %d", second.Token, first.Token)
+	}
+	if _, err := leases.Renew(ctx, first, time.Minute); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("renew of a lease granted again: error = %v, want ErrLeaseLost", err)
+	}
+
+	if err := leases.Release(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+	third, err := leases.Acquire(ctx, 1, "b", time.Minute)
+	if err != nil {
+		t.Fatalf("acquire of a released lease: %v", err)
+	}
+	if third.Token <= second.Token {
+		t.Errorf("token %d after a release is not greater than %d", third.Token, second.Token)
+	}
+}
+
+// testFencing checks that transactions refuses writes with the token of a
+// lease taken over, whether the new owner wrote already or not.
+func testFencing(t *testing.T, leases LeaseStore, transactions TransactionStore) {
+	ctx := context.Background()
+
+	old, err := leases.Acquire(ctx, 1, "old", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(lease Lease, revision int) error {
+		return transactions.Add(ctx, Transaction{ClientID: 1, Revision: revision, Amount: 10, Type: CreditTransaction, Description: "x", Timestamp: time.Now(), FencingToken: lease.Token})
+	}
+	if err := write(old, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	leases.Release(ctx, old)
+	next, err := leases.Acquire(ctx, 1, "next", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := write(next, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := write(old, 3); !errors.Is(err, ErrStaleFencingToken) {
+		t.Errorf("write of the old owner: error = %v, want ErrStaleFencingToken", err)
+	}
+
+	// the lease is granted again, but its new owner has yet to write.
+	leases.Release(ctx, next)
+	if _, err := leases.Acquire(ctx, 1, "newest", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := write(next, 3); !errors.Is(err, ErrStaleFencingToken) {
+		t.Errorf("write of the previous owner before the new one wrote: error = %v, want ErrStaleFencingToken", err)
+	}
+}
+
+func TestMemoryLeaseStore(t *testing.T) {
+	testLeaseStore(t, NewMemoryLeaseStore())
+}
+
+func TestMemoryFencing(t *testing.T) {
+	leases := NewMemoryLeaseStore()
+	transactions := NewMemoryTransactionStore(StoreFaults{})
+	transactions.SetLeases(leases)
+	testFencing(t, leases, transactions)
+}
+
+func TestSQLiteLeaseStore(t *testing.T) {
+	testLeaseStore(t, NewSQLiteLeaseStore(openTestSQLite(t)))
+}
+
+func TestSQLiteFencing(t *testing.T) {
+	db := openTestSQLite(t)
+	testFencing(t, NewSQLiteLeaseStore(db), NewSQLiteTransactionStore(db))
+}
+
+func TestMongoDBLeaseStore(t *testing.T) {
+	testLeaseStore(t, NewMongoDBLeaseStore(openTestMongo(t), MongoOptions{}))
+}
+
+func TestMongoDBFencing(t *testing.T) {
+	client := openTestMongo(t)
+	testFencing(t, NewMongoDBLeaseStore(client, MongoOptions{}), NewMongoDBTransactionStore(client, MongoOptions{}))
+}
+
+func TestMongoDBFencingWithOutbox(t *testing.T) {
+	client := openTestMongo(t)
+	testFencing(t, NewMongoDBLeaseStore(client, MongoOptions{}), NewMongoDBTransactionStoreWithOutbox(client, MongoOptions{}))
+}
+
+func TestFileStoreFencingSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	leases := NewMemoryLeaseStore()
+
+	s, err := OpenFileTransactionStore(FileStoreOptions{Dir: dir, Fsync: FsyncNever, Leases: leases})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testFencing(t, leases, s)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenFileTransactionStore(FileStoreOptions{Dir: dir, Fsync: FsyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	err = s.Add(context.Background(), Transaction{ClientID: 1, Revision: 3, Amount: 10, Type: CreditTransaction, Timestamp: time.Now(), FencingToken: 1})
+	if !errors.Is(err, ErrStaleFencingToken) {
+		t.Errorf("stale write after a restart: error = %v, want ErrStaleFencingToken", err)
+	}
+}
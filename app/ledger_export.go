@@ -0,0 +1,306 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+type LedgerFormat string
+
+const (
+	OFXLedgerFormat   LedgerFormat = "ofx"
+	JSONLLedgerFormat LedgerFormat = "jsonl"
+	CSVLedgerFormat   LedgerFormat = "csv"
+
+	ledgerDateLayout = "2006-01-02"
+	ofxDateLayout    = "20060102150405"
+)
+
+var ErrInvalidLedgerFormat = fmt.Errorf("invalid ledger format")
+
+// ParseLedgerFormat parses the format of an export.
+func ParseLedgerFormat(s string) (LedgerFormat, error) {
+	switch LedgerFormat(strings.ToLower(s)) {
+	case OFXLedgerFormat:
+		return OFXLedgerFormat, nil
+	case JSONLLedgerFormat, "ndjson":
+		return JSONLLedgerFormat, nil
+	}
+
+	return "", fmt.Errorf("%w: %q", ErrInvalidLedgerFormat, s)
+}
+
+func (f LedgerFormat) ContentType() string {
+	if f == OFXLedgerFormat {
+		return "application/x-ofx"
+	}
+	return "application/x-ndjson"
+}
+
+// ParseLedgerPeriod parses the bounds of an export. Both accept RFC 3339 or a
+// plain date; a plain date as upper bound includes the whole day.
+func ParseLedgerPeriod(from, to string) (start time.Time, end time.Time, err error) {
+	if from != "" {
+		if start, err = parseLedgerDate(from, false); err != nil {
+			return start, end, err
+		}
+	}
+
+	if to != "" {
+		if end, err = parseLedgerDate(to, true); err != nil {
+			return start, end, err
+		}
+	}
+
+	if !start.IsZero() && !end.IsZero() && !end.After(start) {
+		return start, end, fmt.Errorf("invalid period: %s is not after %s", to, from)
+	}
+
+	return start, end, nil
+}
+
+func parseLedgerDate(s string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(ledgerDateLayout, s)
+	if err != nil {
+		return t, fmt.Errorf("invalid date %q: expected YYYY-MM-DD or RFC 3339", s)
+	}
+
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+
+	return t, nil
+}
+
+// LedgerEntry is a line of a JSONL export: the transaction plus the balance
+// right after it was applied.
+type LedgerEntry struct {
+	Transaction
+	Balance int `json:"saldo"`
+}
+
+type ledgerWriter interface {
+	begin(client Client, from, to time.Time) error
+	write(entry LedgerEntry) error
+	end(balance int, asOf time.Time) error
+}
+
+type LedgerExporter struct {
+	clientStore      ClientStore
+	transactionStore TransactionStore
+}
+
+func NewLedgerExporter(clientStore ClientStore, transactionStore TransactionStore) *LedgerExporter {
+	return &LedgerExporter{
+		clientStore:      clientStore,
+		transactionStore: transactionStore,
+	}
+}
+
+// Export streams the transactions of a client made within [from, to) to w.
+// The whole log up to the end of the period is read so running balances are
+// exact even when the period starts mid history. A log compacted by the
+// store starts from the balance of the snapshot covering what was dropped.
+func (e *LedgerExporter) Export(ctx context.Context, clientID int, format LedgerFormat, from, to time.Time, w io.Writer) error {
+	client, err := e.clientStore.GetOne(ctx, clientID)
+	if err != nil {
+		return err
+	}
+
+	var lw ledgerWriter
+
+	switch format {
+	case OFXLedgerFormat:
+		lw = &ofxLedgerWriter{w: w}
+	case JSONLLedgerFormat:
+		lw = &jsonlLedgerWriter{encoder: json.NewEncoder(w)}
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidLedgerFormat, format)
+	}
+
+	compacted, err := compactedThrough(e.transactionStore, client.ID)
+	if err != nil {
+		return fmt.Errorf("error reading compaction of client id %d: %w", client.ID, err)
+	}
+
+	balance := 0
+	filter := TransactionFilter{To: to}
+	if compacted > 0 {
+		if balance, err = e.balanceAt(ctx, client.ID, compacted); err != nil {
+			return err
+		}
+		filter.FromRevision = compacted + 1
+	}
+
+	if err := lw.begin(client, from, to); err != nil {
+		return err
+	}
+
+	err = e.transactionStore.StreamTransactions(ctx, client.ID, filter, func(t Transaction) error {
+		switch t.Type {
+		case CreditTransaction:
+			balance += t.Amount
+		case DebitTransaction:
+			balance -= t.Amount
+		}
+
+		if t.Timestamp.Before(from) {
+			return nil
+		}
+
+		return lw.write(LedgerEntry{Transaction: t, Balance: balance})
+	})
+	if err != nil {
+		return fmt.Errorf("error streaming transactions for client id %d: %w", client.ID, err)
+	}
+
+	asOf := to
+	if asOf.IsZero() {
+		asOf = time.Now()
+	}
+
+	return lw.end(balance, asOf)
+}
+
+var errBalanceFound = errors.New("balance found")
+
+// balanceAt returns the balance of a client right after revision, the last
+// one compacted: the balance of the snapshot covering it, less the
+// transactions between the two.
+func (e *LedgerExporter) balanceAt(ctx context.Context, clientID int, revision int) (int, error) {
+	snapshots, err := e.transactionStore.ListSnapshots(ctx, clientID)
+	if err != nil {
+		return 0, fmt.Errorf("error listing snapshots for client id %d: %w", clientID, err)
+	}
+
+	base, ok := compactionBase(snapshots, revision)
+	if !ok {
+		return 0, fmt.Errorf("client id %d is compacted through revision %d with no snapshot covering it", clientID, revision)
+	}
+
+	balance := base.Balance
+	err = e.transactionStore.StreamTransactions(ctx, clientID, TransactionFilter{FromRevision: revision + 1}, func(t Transaction) error {
+		if t.Revision > base.Revision {
+			return errBalanceFound
+		}
+
+		switch t.Type {
+		case CreditTransaction:
+			balance -= t.Amount
+		case DebitTransaction:
+			balance += t.Amount
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errBalanceFound) {
+		return 0, fmt.Errorf("error streaming transactions for client id %d: %w", clientID, err)
+	}
+
+	return balance, nil
+}
+
+type jsonlLedgerWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *jsonlLedgerWriter) begin(client Client, from, to time.Time) error {
+	return nil
+}
+
+func (w *jsonlLedgerWriter) write(entry LedgerEntry) error {
+	return w.encoder.Encode(entry)
+}
+
+func (w *jsonlLedgerWriter) end(balance int, asOf time.Time) error {
+	return nil
+}
+
+type ofxLedgerWriter struct {
+	w        io.Writer
+	clientID int
+}
+
+func (w *ofxLedgerWriter) begin(client Client, from, to time.Time) error {
+	w.clientID = client.ID
+	now := time.Now()
+
+	if to.IsZero() {
+		to = now
+	}
+
+	_, err := fmt.Fprintf(w.w, `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+<SIGNONMSGSRSV1><SONRS><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS><DTSERVER>%s</DTSERVER><LANGUAGE>POR</LANGUAGE></SONRS></SIGNONMSGSRSV1>
+<BANKMSGSRSV1>
+<STMTTRNRS>
+<TRNUID>%d-%d</TRNUID>
+<STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>
+<STMTRS>
+<CURDEF>BRL</CURDEF>
+<BANKACCTFROM><BANKID>RINHA</BANKID><ACCTID>%d</ACCTID><ACCTTYPE>CHECKING</ACCTTYPE></BANKACCTFROM>
+<BANKTRANLIST>
+<DTSTART>%s</DTSTART>
+<DTEND>%s</DTEND>
+`, formatOFXDate(now), client.ID, now.Unix(), client.ID, formatOFXDate(from), formatOFXDate(to))
+
+	return err
+}
+
+func (w *ofxLedgerWriter) write(entry LedgerEntry) error {
+	trnType := "CREDIT"
+	amount := entry.Amount
+	if entry.Type == DebitTransaction {
+		trnType = "DEBIT"
+		amount = -amount
+	}
+
+	var memo strings.Builder
+	if err := xml.EscapeText(&memo, []byte(entry.Description)); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w.w, "<STMTTRN><TRNTYPE>%s</TRNTYPE><DTPOSTED>%s</DTPOSTED><TRNAMT>%s</TRNAMT><FITID>%d-%d</FITID><MEMO>%s</MEMO></STMTTRN>\n",
+		trnType, formatOFXDate(entry.Timestamp), formatOFXAmount(amount), w.clientID, entry.Revision, memo.String())
+
+	return err
+}
+
+func (w *ofxLedgerWriter) end(balance int, asOf time.Time) error {
+	_, err := fmt.Fprintf(w.w, `</BANKTRANLIST>
+<LEDGERBAL><BALAMT>%s</BALAMT><DTASOF>%s</DTASOF></LEDGERBAL>
+</STMTRS>
+</STMTTRNRS>
+</BANKMSGSRSV1>
+</OFX>
+`, formatOFXAmount(balance), formatOFXDate(asOf))
+
+	return err
+}
+
+func formatOFXDate(t time.Time) string {
+	if t.IsZero() {
+		t = time.Unix(0, 0)
+	}
+	return t.UTC().Format(ofxDateLayout) + "[0:GMT]"
+}
+
+// formatOFXAmount renders an amount in cents as a decimal value.
+func formatOFXAmount(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
@@ -0,0 +1,184 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+var ledgerStart = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// newTestExporter exports client 1, with a credit of 10.00 on January 1st, a
+// debit of 2.50 on the 2nd and a credit of 1.00 on the 3rd.
+func newTestExporter(t *testing.T) *LedgerExporter {
+	t.Helper()
+	ctx := context.Background()
+
+	clients := NewMemoryClientStore(StoreFaults{})
+	clients.Add(ctx, Client{ID: 1, CreditLimit: 1000})
+
+	transactions := NewMemoryTransactionStore(StoreFaults{})
+	for i, transaction := range []Transaction{
+		{Amount: 1000, Type: CreditTransaction, Description: "deposito"},
+		{Amount: 250, Type: DebitTransaction, Description: "a < b & c"},
+		{Amount: 100, Type: CreditTransaction, Description: "pix"},
+	} {
+		transaction.ClientID = 1
+		transaction.Revision = i + 1
+		transaction.Timestamp = ledgerStart.AddDate(0, 0, i)
+		if err := transactions.Add(ctx, transaction); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return NewLedgerExporter(clients, transactions)
+}
+
+func TestExportJSONLWithinThePeriod(t *testing.T) {
+	var out bytes.Buffer
+	err := newTestExporter(t).Export(context.Background(), 1, JSONLLedgerFormat, ledgerStart.AddDate(0, 0, 1), ledgerStart.AddDate(0, 0, 2), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []LedgerEntry
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var entry LedgerEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+
+	// the balance counts the credit before the period.
+	if len(entries) != 1 || entries[0].Revision != 2 || entries[0].Balance != 750 {
+		t.Errorf("entries = %+v, want revision 2 with a balance of 750", entries)
+	}
+}
+
+func TestExportOFX(t *testing.T) {
+	var out bytes.Buffer
+	if err := newTestExporter(t).Export(context.Background(), 1, OFXLedgerFormat, time.Time{}, ledgerStart.AddDate(0, 0, 3), &out); err != nil {
+		t.Fatal(err)
+	}
+	ofx := out.String()
+
+	if n := strings.Count(ofx, "<STMTTRN>"); n != 3 {
+		t.Errorf("%d transactions in the statement, want 3", n)
+	}
+	for _, want := range []string{
+		"<TRNTYPE>DEBIT</TRNTYPE><DTPOSTED>20240102120000[0:GMT]</DTPOSTED><TRNAMT>-2.50</TRNAMT><FITID>1-2</FITID><MEMO>a &lt; b &amp; c</MEMO>",
+		"<LEDGERBAL><BALAMT>8.50</BALAMT><DTASOF>20240104120000[0:GMT]</DTASOF></LEDGERBAL>",
+		"<ACCTID>1</ACCTID>",
+		"</OFX>",
+	} {
+		if !strings.Contains(ofx, want) {
+			t.Errorf("statement without %s:\n%s", want, ofx)
+		}
+	}
+}
+
+func TestExportRefusals(t *testing.T) {
+	exporter := newTestExporter(t)
+
+	if err := exporter.Export(context.Background(), 1, CSVLedgerFormat, time.Time{}, time.Time{}, &bytes.Buffer{}); !errors.Is(err, ErrInvalidLedgerFormat) {
+		t.Errorf("csv export: error = %v, want ErrInvalidLedgerFormat", err)
+	}
+	if err := exporter.Export(context.Background(), 2, JSONLLedgerFormat, time.Time{}, time.Time{}, &bytes.Buffer{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("export of an unknown client: error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestParseLedgerPeriod(t *testing.T) {
+	from, to, err := ParseLedgerPeriod("2024-01-01", "2024-01-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !from.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) || !to.Equal(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("period = [%s, %s), want the whole of January", from, to)
+	}
+
+	if _, to, err := ParseLedgerPeriod("", "2024-01-31T10:00:00Z"); err != nil || !to.Equal(time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("RFC 3339 upper bound = %s, %v; want it as is", to, err)
+	}
+
+	for _, period := range [][2]string{{"2024-02-01", "2024-01-01"}, {"2024-01-01", "2024-01-01T00:00:00Z"}, {"yesterday", ""}} {
+		if _, _, err := ParseLedgerPeriod(period[0], period[1]); err == nil {
+			t.Errorf("period %q to %q accepted", period[0], period[1])
+		}
+	}
+}
+
+func TestExportAfterCompaction(t *testing.T) {
+	ctx := context.Background()
+
+	clients := NewMemoryClientStore(StoreFaults{})
+	clients.Add(ctx, Client{ID: 1, CreditLimit: 1000})
+
+	// every transaction rolls into a segment of its own.
+	transactions, err := OpenFileTransactionStore(FileStoreOptions{Dir: t.TempDir(), Fsync: FsyncNever, SegmentSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transactions.Close()
+
+	client := Client{ID: 1, CreditLimit: 1000}
+	var later Snapshot
+	for i, request := range []TransactionRequest{
+		{Amount: 10, Type: CreditTransaction, Description: "a"},
+		{Amount: 10, Type: CreditTransaction, Description: "b"},
+		{Amount: 10, Type: DebitTransaction, Description: "c"},
+		{Amount: 10, Type: CreditTransaction, Description: "d"},
+		{Amount: 10, Type: CreditTransaction, Description: "e"},
+		{Amount: 10, Type: CreditTransaction, Description: "f"},
+	} {
+		transaction, err := client.ProcessTransactionAt(request, ledgerStart.AddDate(0, 0, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := transactions.Add(ctx, transaction); err != nil {
+			t.Fatal(err)
+		}
+
+		switch transaction.Revision {
+		case 3:
+			transactions.SaveSnapshot(ctx, client.TakeSnapshot(transaction.Timestamp))
+		case 5:
+			later = client.TakeSnapshot(transaction.Timestamp)
+		}
+	}
+
+	// revisions 1 to 3 are compacted, and only the snapshot at 5 is kept, so
+	// the balance before revision 4 comes from it.
+	if err := transactions.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	transactions.SaveSnapshot(ctx, later)
+	transactions.PruneSnapshots(ctx, 1, 1)
+
+	var out bytes.Buffer
+	if err := NewLedgerExporter(clients, transactions).Export(ctx, 1, JSONLLedgerFormat, time.Time{}, time.Time{}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	var balances []int
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var entry LedgerEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		balances = append(balances, entry.Balance)
+	}
+
+	if fmt.Sprint(balances) != "[20 30 40]" {
+		t.Errorf("balances = %v, want [20 30 40] for revisions 4 to 6", balances)
+	}
+}
@@ -0,0 +1,334 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseImportFormat parses the format of a transaction file to import.
+func ParseImportFormat(s string) (LedgerFormat, error) {
+	switch LedgerFormat(strings.ToLower(s)) {
+	case JSONLLedgerFormat, "ndjson":
+		return JSONLLedgerFormat, nil
+	case CSVLedgerFormat:
+		return CSVLedgerFormat, nil
+	}
+
+	return "", fmt.Errorf("%w: cannot import %q", ErrInvalidLedgerFormat, s)
+}
+
+// ReadLedgerRecords parses a transaction file in JSONL or CSV format. JSONL
+// lines use the same fields as the export; CSV files need a header naming the
+// valor, tipo, descricao and realizada_em columns, plus an optional revision.
+func ReadLedgerRecords(r io.Reader, format LedgerFormat) ([]Transaction, error) {
+	switch format {
+	case JSONLLedgerFormat:
+		return readJSONLLedgerRecords(r)
+	case CSVLedgerFormat:
+		return readCSVLedgerRecords(r)
+	}
+
+	return nil, fmt.Errorf("%w: cannot import %q", ErrInvalidLedgerFormat, format)
+}
+
+func readJSONLLedgerRecords(r io.Reader) (records []Transaction, err error) {
+	scanner := bufio.NewScanner(r)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var t Transaction
+		if err := json.Unmarshal([]byte(text), &t); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, t)
+	}
+
+	return records, scanner.Err()
+}
+
+func readCSVLedgerRecords(r io.Reader) (records []Transaction, err error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading csv header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, name := range []string{"valor", "tipo", "descricao", "realizada_em"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("csv header is missing column %q", name)
+		}
+	}
+
+	line := 1
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		line++
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		var t Transaction
+
+		if t.Amount, err = strconv.Atoi(row[columns["valor"]]); err != nil {
+			return nil, fmt.Errorf("line %d: invalid valor: %w", line, err)
+		}
+		if t.Timestamp, err = time.Parse(time.RFC3339, row[columns["realizada_em"]]); err != nil {
+			return nil, fmt.Errorf("line %d: invalid realizada_em: %w", line, err)
+		}
+		if i, ok := columns["revision"]; ok && row[i] != "" {
+			if t.Revision, err = strconv.Atoi(row[i]); err != nil {
+				return nil, fmt.Errorf("line %d: invalid revision: %w", line, err)
+			}
+		}
+
+		t.Type = TransactionType(row[columns["tipo"]])
+		t.Description = row[columns["descricao"]]
+		records = append(records, t)
+	}
+
+	return records, nil
+}
+
+type LedgerImportResult struct {
+	Imported      int
+	FirstRevision int
+	LastRevision  int
+	Balance       int
+}
+
+type plannedTransaction struct {
+	transaction Transaction
+	// snapshot is the state to save after the transaction, if any.
+	snapshot *Snapshot
+}
+
+// importLeaseTTL bounds how long a crashed import keeps the client locked.
+// A running import renews its lease three times per TTL.
+const importLeaseTTL = 5 * time.Minute
+
+type LedgerImporter struct {
+	clientStore      ClientStore
+	transactionStore TransactionStore
+	leaseStore       LeaseStore
+	policy           SnapshotPolicy
+	leaseTTL         time.Duration
+}
+
+// NewLedgerImporter imports into transactionStore, taking snapshots where
+// policy would have taken them.
+func NewLedgerImporter(clientStore ClientStore, transactionStore TransactionStore, leaseStore LeaseStore, policy SnapshotPolicy) *LedgerImporter {
+	return &LedgerImporter{
+		clientStore:      clientStore,
+		transactionStore: transactionStore,
+		leaseStore:       leaseStore,
+		policy:           policy,
+		leaseTTL:         importLeaseTTL,
+	}
+}
+
+// Import appends records to the log of a client after the revisions already
+// stored. Every record is validated and replayed against the current state
+// before anything is written, so a bad file leaves the store untouched. Unless
+// it is a dry run, the lease of the client is held meanwhile, failing with
+// ErrLeaseHeld while an actor owns it, and with ErrLeaseLost if it is lost
+// before the import is done.
+func (i *LedgerImporter) Import(ctx context.Context, clientID int, records []Transaction, dryRun bool) (result LedgerImportResult, err error) {
+	client, err := i.clientStore.GetOne(ctx, clientID)
+	if err != nil {
+		return result, err
+	}
+
+	var lease Lease
+	if !dryRun {
+		lease, err = i.leaseStore.Acquire(ctx, clientID, "import-"+DefaultLeaseOwner(), i.leaseTTL)
+		if err != nil {
+			return result, fmt.Errorf("error acquiring lease of client id %d: %w", clientID, err)
+		}
+
+		var release func() Lease
+		ctx, release = i.holdLease(ctx, lease)
+		defer func() {
+			if err := i.leaseStore.Release(context.Background(), release()); err != nil {
+				log.Printf("error releasing lease of client %d: %v\n", clientID, err)
+			}
+		}()
+		defer func() {
+			if cause := context.Cause(ctx); err != nil && errors.Is(cause, ErrLeaseLost) {
+				err = fmt.Errorf("import of client id %d stopped: %w", clientID, cause)
+			}
+		}()
+	}
+
+	snapshot, transactions, err := i.transactionStore.GetTransactionHistory(ctx, clientID)
+	if err != nil {
+		return result, fmt.Errorf("error fetching transactions for client id %d: %w", clientID, err)
+	}
+
+	client.RebuildStateFromHistory(snapshot, transactions)
+
+	var lastTimestamp time.Time
+	for _, t := range transactions {
+		if t.Timestamp.After(lastTimestamp) {
+			lastTimestamp = t.Timestamp
+		}
+	}
+
+	plan := make([]plannedTransaction, 0, len(records))
+	replay := snapshotReplay{policy: i.policy, lastRevision: snapshot.Revision, lastAt: snapshot.CreatedAt}
+
+	for n, record := range records {
+		if record.ClientID != 0 && record.ClientID != clientID {
+			return result, fmt.Errorf("record %d belongs to client id %d", n+1, record.ClientID)
+		}
+
+		req := TransactionRequest{
+			Amount:      record.Amount,
+			Type:        record.Type,
+			Description: record.Description,
+		}
+		if err := req.Validate(); err != nil {
+			return result, fmt.Errorf("record %d: %w", n+1, err)
+		}
+
+		if record.Timestamp.IsZero() {
+			return result, fmt.Errorf("record %d: realizada_em is required", n+1)
+		}
+		if record.Timestamp.Before(lastTimestamp) {
+			return result, fmt.Errorf("record %d: realizada_em %s is before the previous transaction", n+1, record.Timestamp.Format(time.RFC3339))
+		}
+
+		expected := client.lastTransactionRevision + 1
+		if record.Revision != 0 && record.Revision != expected {
+			return result, fmt.Errorf("%w: record %d has revision %d but the next revision of client id %d is %d", ErrRevisionConflict, n+1, record.Revision, clientID, expected)
+		}
+
+		t, err := client.ProcessTransactionAt(req, record.Timestamp)
+		if err != nil {
+			return result, fmt.Errorf("record %d: %w", n+1, err)
+		}
+
+		lastTimestamp = t.Timestamp
+		planned := plannedTransaction{transaction: t}
+		if replay.after(t) {
+			snapshot := client.TakeSnapshot(t.Timestamp)
+			planned.snapshot = &snapshot
+		}
+		plan = append(plan, planned)
+	}
+
+	if last := len(plan) - 1; last >= 0 && replay.atEnd(plan[last].transaction) {
+		snapshot := client.TakeSnapshot(plan[last].transaction.Timestamp)
+		plan[last].snapshot = &snapshot
+	}
+
+	result.Balance = client.Balance
+	if len(plan) > 0 {
+		result.FirstRevision = plan[0].transaction.Revision
+		result.LastRevision = plan[len(plan)-1].transaction.Revision
+	}
+
+	if dryRun {
+		return result, nil
+	}
+
+	// imported logs get snapshots at the same revisions verify -repair
+	// regenerates them at.
+	for _, p := range plan {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		p.transaction.FencingToken = lease.Token
+		if err := i.transactionStore.Add(ctx, p.transaction); err != nil {
+			return result, fmt.Errorf("error adding transaction revision %d for client id %d: %w", p.transaction.Revision, clientID, err)
+		}
+		result.Imported++
+
+		if p.snapshot != nil {
+			if err := i.transactionStore.SaveSnapshot(ctx, *p.snapshot); err != nil {
+				log.Printf("error taking snapshot for client %d: %v\n", clientID, err)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// holdLease renews the lease in the background until release is called,
+// which returns it as last renewed. The returned context is cancelled with
+// ErrLeaseLost once the lease is lost, or expires before it could be renewed,
+// since an actor may own the client by then.
+func (i *LedgerImporter) holdLease(ctx context.Context, lease Lease) (context.Context, func() Lease) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		interval := i.leaseTTL / 3
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			renewCtx, cancelRenew := context.WithTimeout(ctx, interval)
+			renewed, err := i.leaseStore.Renew(renewCtx, lease, i.leaseTTL)
+			cancelRenew()
+
+			switch {
+			case err == nil:
+				lease = renewed
+			case errors.Is(err, ErrLeaseLost):
+				log.Printf("lease of client %d lost, stopping import\n", lease.ClientID)
+				cancel(err)
+				return
+			case !time.Now().Before(lease.ExpiresAt):
+				log.Printf("lease of client %d expired, stopping import: %v\n", lease.ClientID, err)
+				cancel(fmt.Errorf("%w: expired before it could be renewed: %w", ErrLeaseLost, err))
+				return
+			default:
+				log.Printf("error renewing lease of client %d: %v\n", lease.ClientID, err)
+			}
+		}
+	}()
+
+	return ctx, func() Lease {
+		close(stop)
+		<-done
+		cancel(nil)
+		return lease
+	}
+}
@@ -0,0 +1,137 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestImportSnapshotsWithThePolicy(t *testing.T) {
+	ctx := context.Background()
+
+	clients := NewMemoryClientStore(StoreFaults{})
+	clients.Add(ctx, Client{ID: 1, CreditLimit: 1000})
+	transactions := NewMemoryTransactionStore(StoreFaults{})
+	leases := NewMemoryLeaseStore()
+
+	// two revisions already stored, the second with a snapshot.
+	seedLedger(t, transactions, 2, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), func(client *Client, transaction Transaction) {
+		if transaction.Revision == 2 {
+			transactions.SaveSnapshot(ctx, client.TakeSnapshot(transaction.Timestamp))
+		}
+	})
+
+	var records []Transaction
+	for i := 0; i < 5; i++ {
+		records = append(records, Transaction{Amount: 10, Type: CreditTransaction, Description: "import", Timestamp: time.Date(2024, 2, 1+i, 0, 0, 0, 0, time.UTC)})
+	}
+
+	policy, err := ParseSnapshotPolicy("events:3,passivation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := NewLedgerImporter(clients, transactions, leases, policy).Import(ctx, 1, records, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported != 5 || result.FirstRevision != 3 || result.LastRevision != 7 || result.Balance != 70 {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	if revisions := snapshotRevisions(t, transactions); revisions != "[2 3 6 7]" {
+		t.Errorf("snapshot revisions = %s, want [2 3 6 7]", revisions)
+	}
+
+	// an actor holding the lease keeps the import out.
+	if _, err := leases.Acquire(ctx, 1, "actor", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewLedgerImporter(clients, transactions, leases, policy).Import(ctx, 1, records, false); !errors.Is(err, ErrLeaseHeld) {
+		t.Errorf("import under a live actor: error = %v, want ErrLeaseHeld", err)
+	}
+}
+
+func TestParseImportFormat(t *testing.T) {
+	for s, want := range map[string]LedgerFormat{"jsonl": JSONLLedgerFormat, "NDJSON": JSONLLedgerFormat, "csv": CSVLedgerFormat} {
+		if format, err := ParseImportFormat(s); err != nil || format != want {
+			t.Errorf("import format %q = %q, %v; want %q", s, format, err, want)
+		}
+	}
+	if _, err := ParseImportFormat("ofx"); !errors.Is(err, ErrInvalidLedgerFormat) {
+		t.Errorf("ofx import: error = %v, want ErrInvalidLedgerFormat", err)
+	}
+
+	// csv is not exported, so the export routes refuse it up front.
+	if _, err := ParseLedgerFormat("csv"); !errors.Is(err, ErrInvalidLedgerFormat) {
+		t.Errorf("csv export: error = %v, want ErrInvalidLedgerFormat", err)
+	}
+}
+
+func TestImportRenewsItsLease(t *testing.T) {
+	ctx := context.Background()
+
+	clients := NewMemoryClientStore(StoreFaults{})
+	clients.Add(ctx, Client{ID: 1, CreditLimit: 1000})
+	transactions := NewMemoryTransactionStore(StoreFaults{Latency: 20 * time.Millisecond})
+	leases := NewMemoryLeaseStore()
+
+	var records []Transaction
+	for i := 0; i < 10; i++ {
+		records = append(records, Transaction{Amount: 10, Type: CreditTransaction, Description: "import", Timestamp: time.Date(2024, 2, 1+i, 0, 0, 0, 0, time.UTC)})
+	}
+
+	// the import outlives the TTL of its lease several times over.
+	importer := NewLedgerImporter(clients, transactions, leases, EveryEventsPolicy{N: SnapshotSize})
+	importer.leaseTTL = 60 * time.Millisecond
+
+	acquired := make(chan error, 1)
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		_, err := leases.Acquire(ctx, 1, "actor", time.Minute)
+		acquired <- err
+	}()
+
+	result, err := importer.Import(ctx, 1, records, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported != 10 {
+		t.Errorf("imported %d transactions, want 10", result.Imported)
+	}
+	if err := <-acquired; !errors.Is(err, ErrLeaseHeld) {
+		t.Errorf("acquire while the import runs: error = %v, want ErrLeaseHeld", err)
+	}
+}
+
+func TestImportStopsOnceItsLeaseIsLost(t *testing.T) {
+	ctx := context.Background()
+
+	clients := NewMemoryClientStore(StoreFaults{})
+	clients.Add(ctx, Client{ID: 1, CreditLimit: 1000})
+	transactions := NewMemoryTransactionStore(StoreFaults{Latency: 20 * time.Millisecond})
+	leases := NewMemoryLeaseStore()
+
+	var records []Transaction
+	for i := 0; i < 10; i++ {
+		records = append(records, Transaction{Amount: 10, Type: CreditTransaction, Description: "import", Timestamp: time.Date(2024, 2, 1+i, 0, 0, 0, 0, time.UTC)})
+	}
+
+	importer := NewLedgerImporter(clients, transactions, leases, EveryEventsPolicy{N: SnapshotSize})
+	importer.leaseTTL = 60 * time.Millisecond
+
+	// the lease is taken over while the import writes.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		leases.Release(ctx, Lease{ClientID: 1, Owner: "import-" + DefaultLeaseOwner(), Token: 1})
+		leases.Acquire(ctx, 1, "actor", time.Minute)
+	}()
+
+	result, err := importer.Import(ctx, 1, records, false)
+	if !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("error = %v, want ErrLeaseLost", err)
+	}
+	if result.Imported == 0 || result.Imported == len(records) {
+		t.Errorf("imported %d transactions, want the import stopped midway", result.Imported)
+	}
+}
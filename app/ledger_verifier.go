@@ -0,0 +1,317 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+type VerificationIssueKind string
+
+const (
+	RevisionGapIssue             VerificationIssueKind = "revision_gap"
+	DuplicateRevisionIssue       VerificationIssueKind = "duplicate_revision"
+	SnapshotBalanceMismatchIssue VerificationIssueKind = "snapshot_balance_mismatch"
+	OrphanSnapshotIssue          VerificationIssueKind = "orphan_snapshot"
+	CreditLimitViolationIssue    VerificationIssueKind = "credit_limit_violation"
+)
+
+type VerificationIssue struct {
+	ClientID int
+	Kind     VerificationIssueKind
+	Revision int
+	Detail   string
+}
+
+func (i VerificationIssue) String() string {
+	return fmt.Sprintf("client %d revision %d: %s: %s", i.ClientID, i.Revision, i.Kind, i.Detail)
+}
+
+type VerificationReport struct {
+	ClientID          int
+	Transactions      int
+	Snapshots         int
+	LastRevision      int
+	Balance           int
+	Issues            []VerificationIssue
+	SnapshotsRepaired bool
+}
+
+func (r *VerificationReport) addIssue(kind VerificationIssueKind, revision int, format string, args ...any) {
+	r.Issues = append(r.Issues, VerificationIssue{
+		ClientID: r.ClientID,
+		Kind:     kind,
+		Revision: revision,
+		Detail:   fmt.Sprintf(format, args...),
+	})
+}
+
+func (r *VerificationReport) hasSnapshotIssues() bool {
+	return len(r.wrongSnapshots()) > 0
+}
+
+// wrongSnapshots returns the revisions of the snapshots found wrong.
+func (r *VerificationReport) wrongSnapshots() map[int]bool {
+	wrong := make(map[int]bool)
+	for _, issue := range r.Issues {
+		if issue.Kind == SnapshotBalanceMismatchIssue || issue.Kind == OrphanSnapshotIssue {
+			wrong[issue.Revision] = true
+		}
+	}
+	return wrong
+}
+
+// keepArchiveCover adds to regenerated the stored snapshots at or beyond the
+// archived, or compacted, revision that are not wrong, in revision order.
+func keepArchiveCover(regenerated, stored []Snapshot, wrong map[int]bool, archived int) []Snapshot {
+	taken := make(map[int]bool, len(regenerated))
+	for _, snapshot := range regenerated {
+		taken[snapshot.Revision] = true
+	}
+
+	for _, snapshot := range stored {
+		if snapshot.Revision >= archived && !wrong[snapshot.Revision] && !taken[snapshot.Revision] {
+			regenerated = append(regenerated, snapshot)
+		}
+	}
+
+	sort.Slice(regenerated, func(i, j int) bool { return regenerated[i].Revision < regenerated[j].Revision })
+	return regenerated
+}
+
+// coversRevision tells whether a rebuild from the last of snapshots reads
+// no transaction up to revision.
+func coversRevision(snapshots []Snapshot, revision int) bool {
+	return len(snapshots) > 0 && snapshots[len(snapshots)-1].ReplayFrom() > revision
+}
+
+// compactedThrough returns the revision store compacted the client through,
+// 0 when it does not compact.
+func compactedThrough(store TransactionStore, clientID int) (int, error) {
+	if reporter, ok := store.(CompactionReporter); ok {
+		return reporter.CompactedThrough(clientID)
+	}
+	return 0, nil
+}
+
+// compactionBase returns the snapshot with the lowest revision a rebuild
+// from which reads no transaction up to compacted.
+func compactionBase(snapshots []Snapshot, compacted int) (base Snapshot, ok bool) {
+	for _, snapshot := range snapshots {
+		if snapshot.ReplayFrom() > compacted && (!ok || snapshot.Revision < base.Revision) {
+			base, ok = snapshot, true
+		}
+	}
+	return base, ok
+}
+
+type LedgerVerifier struct {
+	clientStore      ClientStore
+	transactionStore TransactionStore
+	// archive is nil unless transactions are archived.
+	archive   *TransactionArchive
+	snapshots SnapshotOptions
+}
+
+// NewLedgerVerifier verifies the logs of transactionStore, which reads the
+// archived transactions too when archive is set. Repaired snapshots are
+// taken where the policy of snapshots would have taken them, and only as
+// many as it retains are kept.
+func NewLedgerVerifier(clientStore ClientStore, transactionStore TransactionStore, archive *TransactionArchive, snapshots SnapshotOptions) *LedgerVerifier {
+	return &LedgerVerifier{
+		clientStore:      clientStore,
+		transactionStore: transactionStore,
+		archive:          archive,
+		snapshots:        snapshots,
+	}
+}
+
+// VerifyAll verifies every known client, handing each report to fn as soon as
+// the client is done.
+func (v *LedgerVerifier) VerifyAll(ctx context.Context, repair bool, fn func(VerificationReport)) error {
+	clients, err := v.clientStore.GetAll(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing clients: %w", err)
+	}
+
+	for _, client := range clients {
+		report, err := v.Verify(ctx, client.ID, repair)
+		if err != nil {
+			return err
+		}
+		fn(report)
+	}
+
+	return nil
+}
+
+// Verify replays the whole log of a client checking that revisions are
+// contiguous, that no debit went past the credit limit and that every
+// snapshot matches the balance replayed up to its revision. With repair set,
+// snapshots are regenerated from the log whenever any of them is wrong. The
+// snapshots at or beyond the archived revision are kept when they are right,
+// and the repaired ones always include one covering the archived
+// transactions, since rebuilds no longer find them in the store. A client
+// compacted by the store is replayed from the first snapshot covering the
+// transactions dropped, which is trusted and kept.
+func (v *LedgerVerifier) Verify(ctx context.Context, clientID int, repair bool) (report VerificationReport, err error) {
+	report.ClientID = clientID
+
+	client, err := v.clientStore.GetOne(ctx, clientID)
+	if err != nil {
+		return report, err
+	}
+
+	snapshots, err := v.transactionStore.ListSnapshots(ctx, clientID)
+	if err != nil {
+		return report, fmt.Errorf("error listing snapshots for client id %d: %w", clientID, err)
+	}
+	report.Snapshots = len(snapshots)
+
+	snapshotRevisions := make(map[int]bool, len(snapshots))
+	for _, snapshot := range snapshots {
+		snapshotRevisions[snapshot.Revision] = true
+	}
+	balances := make(map[int]int, len(snapshots))
+
+	compacted, err := compactedThrough(v.transactionStore, clientID)
+	if err != nil {
+		return report, fmt.Errorf("error reading compaction of client id %d: %w", clientID, err)
+	}
+
+	var regenerated []Snapshot
+	var history TransactionHistory
+	var last Transaction
+
+	replay := snapshotReplay{policy: v.snapshots.Policy}
+	filter := TransactionFilter{}
+
+	// without a snapshot covering them, the compacted revisions are a gap.
+	var base Snapshot
+	var fromBase bool
+	if compacted > 0 {
+		base, fromBase = compactionBase(snapshots, compacted)
+	}
+	if fromBase {
+		report.LastRevision = base.Revision
+		report.Balance = base.Balance
+		history.LastTransactions = append([]TransactionSummary(nil), base.History...)
+		balances[base.Revision] = base.Balance
+		replay.lastRevision, replay.lastAt = base.Revision, base.CreatedAt
+		filter.FromRevision = base.ReplayFrom()
+	}
+	snapshotAt := func(t Transaction) Snapshot {
+		return Snapshot{
+			Version:     FullStateSnapshotVersion,
+			ClientID:    clientID,
+			Revision:    t.Revision,
+			Balance:     report.Balance,
+			CreditLimit: client.CreditLimit,
+			History:     append([]TransactionSummary(nil), history.LastTransactions...),
+			CreatedAt:   t.Timestamp,
+		}
+	}
+
+	err = v.transactionStore.StreamTransactions(ctx, clientID, filter, func(t Transaction) error {
+		report.Transactions++
+
+		// the state up to a balance snapshot comes from it; the transactions
+		// it needs only fill the history.
+		if fromBase && t.Revision <= base.Revision {
+			history.RegisterTransaction(t)
+			return nil
+		}
+
+		if t.Revision <= report.LastRevision {
+			report.addIssue(DuplicateRevisionIssue, t.Revision, "revision already seen, event ignored on replay")
+			return nil
+		}
+
+		if t.Revision > report.LastRevision+1 {
+			report.addIssue(RevisionGapIssue, report.LastRevision+1, "revisions %d to %d are missing", report.LastRevision+1, t.Revision-1)
+		}
+
+		switch t.Type {
+		case CreditTransaction:
+			report.Balance += t.Amount
+		case DebitTransaction:
+			report.Balance -= t.Amount
+			if report.Balance < -client.CreditLimit {
+				report.addIssue(CreditLimitViolationIssue, t.Revision, "balance %d exceeds credit limit %d", report.Balance, client.CreditLimit)
+			}
+		}
+
+		report.LastRevision = t.Revision
+		history.RegisterTransaction(t)
+		last = t
+
+		if snapshotRevisions[t.Revision] {
+			balances[t.Revision] = report.Balance
+		}
+
+		if replay.after(t) {
+			regenerated = append(regenerated, snapshotAt(t))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("error streaming transactions for client id %d: %w", clientID, err)
+	}
+
+	if last.Revision > 0 && replay.atEnd(last) {
+		regenerated = append(regenerated, snapshotAt(last))
+	}
+
+	for _, snapshot := range snapshots {
+		// snapshots before the base point at compacted revisions.
+		if fromBase && snapshot.Revision < base.Revision {
+			continue
+		}
+
+		expected, ok := balances[snapshot.Revision]
+		if !ok {
+			report.addIssue(OrphanSnapshotIssue, snapshot.Revision, "snapshot points at a revision missing from the log (last revision %d)", report.LastRevision)
+			continue
+		}
+
+		if snapshot.Balance != expected {
+			report.addIssue(SnapshotBalanceMismatchIssue, snapshot.Revision, "snapshot balance is %d but events add up to %d", snapshot.Balance, expected)
+		}
+	}
+
+	if repair && report.hasSnapshotIssues() {
+		var archived int
+		if v.archive != nil {
+			if archived, err = v.archive.ArchivedThrough(clientID); err != nil {
+				return report, fmt.Errorf("error reading archive of client id %d: %w", clientID, err)
+			}
+		}
+
+		// the snapshots covering the archived or the compacted transactions
+		// are kept.
+		covered := max(archived, compacted)
+		if covered > 0 {
+			regenerated = keepArchiveCover(regenerated, snapshots, report.wrongSnapshots(), covered)
+			if !coversRevision(regenerated, covered) && last.Revision >= covered {
+				regenerated = slices.DeleteFunc(regenerated, func(snapshot Snapshot) bool { return snapshot.Revision == last.Revision })
+				regenerated = append(regenerated, snapshotAt(last))
+			}
+		}
+
+		// only the newest are kept, as the actors prune them after every
+		// snapshot, which leaves the cover of the newest one untouched.
+		if retain := v.snapshots.Retain; retain > 0 && len(regenerated) > retain {
+			slices.SortStableFunc(regenerated, func(a, b Snapshot) int { return a.Revision - b.Revision })
+			regenerated = regenerated[len(regenerated)-retain:]
+		}
+
+		if err := v.transactionStore.ReplaceSnapshots(ctx, clientID, regenerated); err != nil {
+			return report, fmt.Errorf("error regenerating snapshots for client id %d: %w", clientID, err)
+		}
+		report.SnapshotsRepaired = true
+	}
+
+	return report, nil
+}
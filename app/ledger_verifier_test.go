@@ -0,0 +1,253 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// seedLedger stores n credits of 10 for client 1, a day apart, calling
+// snapshot with the state after each of them.
+func seedLedger(t *testing.T, transactions TransactionStore, n int, start time.Time, snapshot func(*Client, Transaction)) {
+	t.Helper()
+
+	client := Client{ID: 1, CreditLimit: 1000}
+	for i := 0; i < n; i++ {
+		transaction, err := client.ProcessTransactionAt(TransactionRequest{Amount: 10, Type: CreditTransaction, Description: "x"}, start.Add(time.Duration(i)*24*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := transactions.Add(context.Background(), transaction); err != nil {
+			t.Fatal(err)
+		}
+		if snapshot != nil {
+			snapshot(&client, transaction)
+		}
+	}
+}
+
+func snapshotRevisions(t *testing.T, transactions TransactionStore) string {
+	t.Helper()
+
+	snapshots, err := transactions.ListSnapshots(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revisions := make([]int, len(snapshots))
+	for i, snapshot := range snapshots {
+		revisions[i] = snapshot.Revision
+	}
+	return fmt.Sprint(revisions)
+}
+
+func TestVerifierFindsIssues(t *testing.T) {
+	ctx := context.Background()
+
+	clients := NewMemoryClientStore(StoreFaults{})
+	clients.Add(ctx, Client{ID: 1, CreditLimit: 10})
+	transactions := NewMemoryTransactionStore(StoreFaults{})
+
+	now := time.Now()
+	for _, transaction := range []Transaction{
+		{ClientID: 1, Revision: 1, Amount: 5, Type: CreditTransaction, Timestamp: now},
+		{ClientID: 1, Revision: 3, Amount: 30, Type: DebitTransaction, Timestamp: now},
+	} {
+		transactions.Add(ctx, transaction)
+	}
+	transactions.SaveSnapshot(ctx, Snapshot{Version: FullStateSnapshotVersion, ClientID: 1, Revision: 1, Balance: 7})
+	transactions.SaveSnapshot(ctx, Snapshot{Version: FullStateSnapshotVersion, ClientID: 1, Revision: 2, Balance: 5})
+
+	policy, _ := ParseSnapshotPolicy("")
+	report, err := NewLedgerVerifier(clients, transactions, nil, SnapshotOptions{Policy: policy}).Verify(ctx, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []VerificationIssueKind{RevisionGapIssue, CreditLimitViolationIssue, SnapshotBalanceMismatchIssue, OrphanSnapshotIssue}
+	if len(report.Issues) != len(want) {
+		t.Fatalf("issues = %v, want %v", report.Issues, want)
+	}
+	for i, issue := range report.Issues {
+		if issue.Kind != want[i] {
+			t.Errorf("issue %d = %v, want %s", i, issue, want[i])
+		}
+	}
+	if report.SnapshotsRepaired || snapshotRevisions(t, transactions) != "[1 2]" {
+		t.Error("snapshots changed without repair")
+	}
+}
+
+func TestVerifierRepairsWithThePolicy(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   string
+	}{
+		{"events:3", "[3 6]"},
+		{"passivation", "[7]"},
+		{"events:3,shutdown", "[3 6 7]"},
+		{"interval:48h", "[1 3 5 7]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			ctx := context.Background()
+
+			clients := NewMemoryClientStore(StoreFaults{})
+			clients.Add(ctx, Client{ID: 1, CreditLimit: 1000})
+			transactions := NewMemoryTransactionStore(StoreFaults{})
+			seedLedger(t, transactions, 7, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), nil)
+			transactions.SaveSnapshot(ctx, Snapshot{Version: FullStateSnapshotVersion, ClientID: 1, Revision: 2, Balance: 99})
+
+			policy, err := ParseSnapshotPolicy(tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			report, err := NewLedgerVerifier(clients, transactions, nil, SnapshotOptions{Policy: policy}).Verify(ctx, 1, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !report.SnapshotsRepaired {
+				t.Fatal("snapshots not repaired")
+			}
+
+			if revisions := snapshotRevisions(t, transactions); revisions != tt.want {
+				t.Errorf("snapshot revisions = %s, want %s", revisions, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifierRepairKeepsTheRetainedSnapshots(t *testing.T) {
+	ctx := context.Background()
+
+	clients := NewMemoryClientStore(StoreFaults{})
+	clients.Add(ctx, Client{ID: 1, CreditLimit: 1000})
+	transactions := NewMemoryTransactionStore(StoreFaults{})
+	seedLedger(t, transactions, 7, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), nil)
+	transactions.SaveSnapshot(ctx, Snapshot{Version: FullStateSnapshotVersion, ClientID: 1, Revision: 2, Balance: 99})
+
+	// every event would leave 7 snapshots, of which the actors keep 3.
+	report, err := NewLedgerVerifier(clients, transactions, nil, SnapshotOptions{Policy: EveryEventsPolicy{N: 1}, Retain: 3}).Verify(ctx, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.SnapshotsRepaired {
+		t.Fatal("snapshots not repaired")
+	}
+
+	if revisions := snapshotRevisions(t, transactions); revisions != "[5 6 7]" {
+		t.Errorf("snapshot revisions = %s, want [5 6 7]", revisions)
+	}
+}
+
+func TestVerifierKeepsTheSnapshotsOfArchivedTransactions(t *testing.T) {
+	ctx := context.Background()
+
+	clients := NewMemoryClientStore(StoreFaults{})
+	clients.Add(ctx, Client{ID: 1, CreditLimit: 1000})
+	transactions := NewMemoryTransactionStore(StoreFaults{})
+
+	// a wrong snapshot at revision 2 and a right one at 4, which the
+	// archiver relies on to delete revisions 1 to 4.
+	seedLedger(t, transactions, 6, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), func(client *Client, transaction Transaction) {
+		switch transaction.Revision {
+		case 2:
+			snapshot := client.TakeSnapshot(transaction.Timestamp)
+			snapshot.Balance = 99
+			transactions.SaveSnapshot(ctx, snapshot)
+		case 4:
+			transactions.SaveSnapshot(ctx, client.TakeSnapshot(transaction.Timestamp))
+		}
+	})
+
+	archive, err := OpenTransactionArchive(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewArchiver(clients, transactions, archive, time.Hour).Archive(ctx, 1, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	store := NewArchivedTransactionStore(transactions, archive)
+
+	// the policy would not snapshot any of the six revisions.
+	policy, _ := ParseSnapshotPolicy("events:100")
+	report, err := NewLedgerVerifier(clients, store, archive, SnapshotOptions{Policy: policy}).Verify(ctx, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.SnapshotsRepaired || len(report.Issues) != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	if revisions := snapshotRevisions(t, transactions); revisions != "[4]" {
+		t.Errorf("snapshot revisions = %s, want the one covering the archive", revisions)
+	}
+
+	snapshot, replay, err := transactions.GetTransactionHistory(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := Client{ID: 1, CreditLimit: 1000}
+	client.RebuildStateFromHistory(snapshot, replay)
+	if client.Balance != 60 {
+		t.Errorf("balance rebuilt from the store = %d, want 60", client.Balance)
+	}
+}
+
+func TestVerifierReplaysCompactedClientsFromTheirSnapshot(t *testing.T) {
+	ctx := context.Background()
+
+	clients := NewMemoryClientStore(StoreFaults{})
+	clients.Add(ctx, Client{ID: 1, CreditLimit: 1000})
+
+	// every transaction rolls into a segment of its own.
+	transactions, err := OpenFileTransactionStore(FileStoreOptions{Dir: t.TempDir(), Fsync: FsyncNever, SegmentSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transactions.Close()
+
+	var wrong Snapshot
+	seedLedger(t, transactions, 6, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), func(client *Client, transaction Transaction) {
+		switch transaction.Revision {
+		case 4:
+			transactions.SaveSnapshot(ctx, client.TakeSnapshot(transaction.Timestamp))
+		case 5:
+			wrong = client.TakeSnapshot(transaction.Timestamp)
+			wrong.Balance = 99
+		}
+	})
+
+	if err := transactions.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if compacted, _ := transactions.CompactedThrough(1); compacted != 4 {
+		t.Fatalf("compacted through revision %d, want 4", compacted)
+	}
+	transactions.SaveSnapshot(ctx, wrong)
+
+	policy, _ := ParseSnapshotPolicy("events:100")
+	report, err := NewLedgerVerifier(clients, transactions, nil, SnapshotOptions{Policy: policy}).Verify(ctx, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.SnapshotsRepaired || len(report.Issues) != 1 || report.Issues[0].Kind != SnapshotBalanceMismatchIssue || report.Balance != 60 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	if revisions := snapshotRevisions(t, transactions); revisions != "[4]" {
+		t.Errorf("snapshot revisions = %s, want the one covering the compacted revisions", revisions)
+	}
+
+	snapshot, replay, err := transactions.GetTransactionHistory(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := Client{ID: 1, CreditLimit: 1000}
+	client.RebuildStateFromHistory(snapshot, replay)
+	if client.Balance != 60 {
+		t.Errorf("balance rebuilt after the repair = %d, want 60", client.Balance)
+	}
+}
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+type MemoryClientStore struct {
+	mutex   sync.RWMutex
+	clients map[int]Client
+	faults  *faultInjector
+}
+
+func NewMemoryClientStore(faults StoreFaults) *MemoryClientStore {
+	return &MemoryClientStore{
+		clients: make(map[int]Client),
+		faults:  newFaultInjector(faults),
+	}
+}
+
+func (s *MemoryClientStore) SetFaults(faults StoreFaults) {
+	s.faults.set(faults)
+}
+
+func (s *MemoryClientStore) Add(ctx context.Context, client Client) error {
+	drop, err := s.faults.before(ctx, AddClientOperation)
+	if err != nil || drop {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.clients[client.ID]; !ok {
+		s.clients[client.ID] = Client{ID: client.ID, CreditLimit: client.CreditLimit, Balance: client.Balance}
+	}
+	return nil
+}
+
+func (s *MemoryClientStore) GetOne(ctx context.Context, clientID int) (client Client, err error) {
+	if _, err := s.faults.before(ctx, GetClientOperation); err != nil {
+		return client, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	client, ok := s.clients[clientID]
+	if !ok {
+		return client, ErrNotFound
+	}
+	return client, nil
+}
+
+func (s *MemoryClientStore) GetAll(ctx context.Context) (clients []Client, err error) {
+	if _, err := s.faults.before(ctx, GetAllClientsOperation); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, client := range s.clients {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	return clients, nil
+}
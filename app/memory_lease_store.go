@@ -0,0 +1,65 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type MemoryLeaseStore struct {
+	mutex  sync.Mutex
+	leases map[int]Lease
+}
+
+func NewMemoryLeaseStore() *MemoryLeaseStore {
+	return &MemoryLeaseStore{leases: make(map[int]Lease)}
+}
+
+func (s *MemoryLeaseStore) Acquire(ctx context.Context, clientID int, owner string, ttl time.Duration) (Lease, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	lease := s.leases[clientID]
+
+	if lease.Owner != "" && lease.Owner != owner && lease.ExpiresAt.After(now) {
+		return lease, ErrLeaseHeld
+	}
+
+	lease = Lease{ClientID: clientID, Owner: owner, Token: lease.Token + 1, ExpiresAt: now.Add(ttl)}
+	s.leases[clientID] = lease
+	return lease, nil
+}
+
+func (s *MemoryLeaseStore) Renew(ctx context.Context, lease Lease, ttl time.Duration) (Lease, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	current := s.leases[lease.ClientID]
+	if current.Owner != lease.Owner || current.Token != lease.Token {
+		return lease, ErrLeaseLost
+	}
+
+	current.ExpiresAt = time.Now().Add(ttl)
+	s.leases[lease.ClientID] = current
+	return current, nil
+}
+
+func (s *MemoryLeaseStore) Release(ctx context.Context, lease Lease) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	current := s.leases[lease.ClientID]
+	if current.Owner == lease.Owner && current.Token == lease.Token {
+		current.ExpiresAt = time.Now()
+		s.leases[lease.ClientID] = current
+	}
+	return nil
+}
+
+func (s *MemoryLeaseStore) Token(ctx context.Context, clientID int) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.leases[clientID].Token, nil
+}
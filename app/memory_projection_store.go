@@ -0,0 +1,103 @@
+package app
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+type dailyTotalsKey struct {
+	clientID int
+	day      string
+}
+
+type MemoryProjectionStore struct {
+	mutex       sync.RWMutex
+	statements  map[int]StatementView
+	dailyTotals map[dailyTotalsKey]DailyTotals
+}
+
+func NewMemoryProjectionStore() *MemoryProjectionStore {
+	return &MemoryProjectionStore{
+		statements:  make(map[int]StatementView),
+		dailyTotals: make(map[dailyTotalsKey]DailyTotals),
+	}
+}
+
+func (s *MemoryProjectionStore) GetStatement(ctx context.Context, clientID int) (StatementView, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	view, ok := s.statements[clientID]
+	if !ok {
+		return view, ErrNotFound
+	}
+	view.LastTransactions = append([]TransactionSummary(nil), view.LastTransactions...)
+	return view, nil
+}
+
+func (s *MemoryProjectionStore) SaveStatement(ctx context.Context, view StatementView) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if stored, ok := s.statements[view.ClientID]; ok && stored.Revision >= view.Revision {
+		return nil
+	}
+
+	view.LastTransactions = append([]TransactionSummary(nil), view.LastTransactions...)
+	s.statements[view.ClientID] = view
+	return nil
+}
+
+func (s *MemoryProjectionStore) AddToDailyTotals(ctx context.Context, transaction Transaction) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := dailyTotalsKey{transaction.ClientID, DayOf(transaction.Timestamp)}
+
+	totals, ok := s.dailyTotals[key]
+	if ok && totals.Revision >= transaction.Revision {
+		return nil
+	}
+
+	totals.ClientID = key.clientID
+	totals.Day = key.day
+	totals.Revision = transaction.Revision
+	totals.Count++
+	if transaction.Type == CreditTransaction {
+		totals.Credits += transaction.Amount
+	} else if transaction.Type == DebitTransaction {
+		totals.Debits += transaction.Amount
+	}
+
+	s.dailyTotals[key] = totals
+	return nil
+}
+
+func (s *MemoryProjectionStore) ListDailyTotals(ctx context.Context, clientID int, from, to string) ([]DailyTotals, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var days []DailyTotals
+	for key, totals := range s.dailyTotals {
+		if key.clientID == clientID && key.day >= from && key.day <= to {
+			days = append(days, totals)
+		}
+	}
+
+	sort.Slice(days, func(i, j int) bool { return days[i].Day < days[j].Day })
+	return days, nil
+}
+
+func (s *MemoryProjectionStore) Reset(ctx context.Context, clientID int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.statements, clientID)
+	for key := range s.dailyTotals {
+		if key.clientID == clientID {
+			delete(s.dailyTotals, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package app
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+)
+
+// MemoryTransactionStore keeps the log in memory with the same snapshot
+// behaviour as the database backed stores. It is meant for tests and demos,
+// where StoreFaults can make it slow, flaky or lossy on purpose.
+type MemoryTransactionStore struct {
+	mutex        sync.RWMutex
+	transactions map[int][]Transaction
+	snapshots    map[int][]Snapshot
+	// fences holds the greatest fencing token written for each client.
+	fences map[int]int64
+	// leases, when set, fences off writes below the token of the current
+	// lease, before the new owner writes anything.
+	leases LeaseStore
+	faults *faultInjector
+}
+
+func NewMemoryTransactionStore(faults StoreFaults) *MemoryTransactionStore {
+	return &MemoryTransactionStore{
+		transactions: make(map[int][]Transaction),
+		snapshots:    make(map[int][]Snapshot),
+		fences:       make(map[int]int64),
+		faults:       newFaultInjector(faults),
+	}
+}
+
+func (s *MemoryTransactionStore) SetFaults(faults StoreFaults) {
+	s.faults.set(faults)
+}
+
+// SetLeases fences writes with the current lease of their client, as the
+// database backed stores do, rather than only with the tokens written.
+func (s *MemoryTransactionStore) SetLeases(leases LeaseStore) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.leases = leases
+}
+
+func (s *MemoryTransactionStore) Add(ctx context.Context, transaction Transaction) error {
+	drop, err := s.faults.before(ctx, AddTransactionOperation)
+	if err != nil || drop {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := checkFence(ctx, s.leases, transaction, s.fences[transaction.ClientID]); err != nil {
+		return err
+	}
+
+	for _, t := range s.transactions[transaction.ClientID] {
+		if t.Revision == transaction.Revision {
+			return &RevisionConflictError{ClientID: transaction.ClientID, Revision: transaction.Revision}
+		}
+	}
+
+	s.transactions[transaction.ClientID] = append(s.transactions[transaction.ClientID], transaction)
+	s.fences[transaction.ClientID] = transaction.FencingToken
+	return nil
+}
+
+// checkFence refuses the transaction when its fencing token is below the
+// greatest one written for its client, or below the token of the current
+// lease of the client when leases is not nil.
+func checkFence(ctx context.Context, leases LeaseStore, transaction Transaction, written int64) error {
+	if transaction.FencingToken < written {
+		return ErrStaleFencingToken
+	}
+	if leases == nil {
+		return nil
+	}
+
+	token, err := leases.Token(ctx, transaction.ClientID)
+	if err != nil {
+		return err
+	}
+	if transaction.FencingToken < token {
+		return ErrStaleFencingToken
+	}
+	return nil
+}
+
+func (s *MemoryTransactionStore) GetTransactionHistory(ctx context.Context, clientID int) (lastSnapshot Snapshot, transactions []Transaction, err error) {
+	if _, err := s.faults.before(ctx, GetTransactionHistoryOperation); err != nil {
+		return lastSnapshot, nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, snapshot := range s.snapshots[clientID] {
+		if snapshot.Revision > lastSnapshot.Revision {
+			lastSnapshot = snapshot
+		}
+	}
+
+	if lastSnapshot.Revision > 0 {
+		log.Printf("loading state of client %d from snapshot revision %d:\n", clientID, lastSnapshot.Revision)
+	}
+
+	for _, t := range s.transactions[clientID] {
+		if t.Revision >= lastSnapshot.ReplayFrom() {
+			transactions = append(transactions, t)
+		}
+	}
+
+	return lastSnapshot, transactions, nil
+}
+
+func (s *MemoryTransactionStore) StreamTransactions(ctx context.Context, clientID int, filter TransactionFilter, fn func(Transaction) error) error {
+	if _, err := s.faults.before(ctx, StreamTransactionsOperation); err != nil {
+		return err
+	}
+
+	s.mutex.RLock()
+	transactions := append([]Transaction(nil), s.transactions[clientID]...)
+	s.mutex.RUnlock()
+
+	sort.SliceStable(transactions, func(i, j int) bool { return transactions[i].Revision < transactions[j].Revision })
+
+	for _, t := range transactions {
+		if filter.FromRevision > 0 && t.Revision < filter.FromRevision {
+			continue
+		}
+		if !filter.From.IsZero() && t.Timestamp.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !t.Timestamp.Before(filter.To) {
+			continue
+		}
+
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryTransactionStore) ListSnapshots(ctx context.Context, clientID int) ([]Snapshot, error) {
+	if _, err := s.faults.before(ctx, ListSnapshotsOperation); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	snapshots := append([]Snapshot(nil), s.snapshots[clientID]...)
+	s.mutex.RUnlock()
+
+	sort.SliceStable(snapshots, func(i, j int) bool { return snapshots[i].Revision < snapshots[j].Revision })
+	return snapshots, nil
+}
+
+func (s *MemoryTransactionStore) ReplaceSnapshots(ctx context.Context, clientID int, snapshots []Snapshot) error {
+	drop, err := s.faults.before(ctx, ReplaceSnapshotsOperation)
+	if err != nil || drop {
+		return err
+	}
+
+	replaced := make([]Snapshot, len(snapshots))
+	for i, snapshot := range snapshots {
+		snapshot.ClientID = clientID
+		replaced[i] = snapshot
+	}
+
+	s.mutex.Lock()
+	s.snapshots[clientID] = replaced
+	s.mutex.Unlock()
+
+	return nil
+}
+
+func (s *MemoryTransactionStore) SaveSnapshot(ctx context.Context, snapshot Snapshot) error {
+	drop, err := s.faults.before(ctx, SaveSnapshotOperation)
+	if err != nil || drop {
+		return err
+	}
+
+	s.mutex.Lock()
+	s.snapshots[snapshot.ClientID] = append(s.snapshots[snapshot.ClientID], snapshot)
+	s.mutex.Unlock()
+
+	return nil
+}
+
+func (s *MemoryTransactionStore) PruneSnapshots(ctx context.Context, clientID int, keep int) error {
+	drop, err := s.faults.before(ctx, PruneSnapshotsOperation)
+	if err != nil || drop {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	snapshots := s.snapshots[clientID]
+	if len(snapshots) <= keep {
+		return nil
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool { return snapshots[i].Revision < snapshots[j].Revision })
+	s.snapshots[clientID] = append([]Snapshot(nil), snapshots[len(snapshots)-keep:]...)
+	return nil
+}
+
+func (s *MemoryTransactionStore) DeleteTransactions(ctx context.Context, clientID int, revision int) (int64, error) {
+	drop, err := s.faults.before(ctx, DeleteTransactionsOperation)
+	if err != nil || drop {
+		return 0, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	transactions := s.transactions[clientID]
+	kept := make([]Transaction, 0, len(transactions))
+	for _, t := range transactions {
+		if t.Revision > revision {
+			kept = append(kept, t)
+		}
+	}
+
+	s.transactions[clientID] = kept
+	return int64(len(transactions) - len(kept)), nil
+}
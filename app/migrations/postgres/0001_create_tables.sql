@@ -0,0 +1,27 @@
+CREATE TABLE clients (
+    client_id INTEGER PRIMARY KEY,
+    credit_limit INTEGER NOT NULL,
+    balance INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE transactions (
+    client_id INTEGER NOT NULL,
+    revision INTEGER NOT NULL,
+    amount INTEGER NOT NULL,
+    type CHAR(1) NOT NULL,
+    description TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (client_id, revision)
+);
+
+CREATE INDEX transactions_client_id_created_at_idx ON transactions (client_id, created_at);
+
+CREATE TABLE snapshots (
+    id BIGSERIAL PRIMARY KEY,
+    client_id INTEGER NOT NULL,
+    revision INTEGER NOT NULL,
+    balance INTEGER NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX snapshots_client_id_created_at_idx ON snapshots (client_id, created_at);
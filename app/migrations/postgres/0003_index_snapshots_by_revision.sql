@@ -0,0 +1,3 @@
+DROP INDEX snapshots_client_id_created_at_idx;
+
+CREATE INDEX snapshots_client_id_revision_idx ON snapshots (client_id, revision);
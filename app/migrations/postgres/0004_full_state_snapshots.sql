@@ -0,0 +1,4 @@
+ALTER TABLE snapshots
+    ADD COLUMN version INTEGER NOT NULL DEFAULT 1,
+    ADD COLUMN credit_limit INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN history JSONB;
@@ -0,0 +1,6 @@
+ALTER TABLE transactions
+    ADD COLUMN event_type TEXT,
+    ADD COLUMN event_version INTEGER;
+
+ALTER TABLE snapshots
+    ADD COLUMN event_type TEXT;
@@ -0,0 +1,15 @@
+CREATE TABLE outbox (
+    id BIGSERIAL PRIMARY KEY,
+    client_id INTEGER NOT NULL,
+    revision INTEGER NOT NULL,
+    event_type TEXT NOT NULL,
+    event_version INTEGER NOT NULL,
+    amount INTEGER NOT NULL,
+    type CHAR(1) NOT NULL,
+    description TEXT NOT NULL,
+    transaction_created_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX outbox_client_id_revision_idx ON outbox (client_id, revision);
+CREATE INDEX outbox_created_at_idx ON outbox (created_at);
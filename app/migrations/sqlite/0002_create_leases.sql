@@ -0,0 +1,6 @@
+CREATE TABLE leases (
+    client_id INTEGER PRIMARY KEY,
+    owner TEXT NOT NULL,
+    token INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL
+);
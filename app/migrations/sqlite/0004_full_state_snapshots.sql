@@ -0,0 +1,3 @@
+ALTER TABLE snapshots ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE snapshots ADD COLUMN credit_limit INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE snapshots ADD COLUMN history TEXT;
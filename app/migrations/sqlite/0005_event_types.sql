@@ -0,0 +1,3 @@
+ALTER TABLE transactions ADD COLUMN event_type TEXT;
+ALTER TABLE transactions ADD COLUMN event_version INTEGER;
+ALTER TABLE snapshots ADD COLUMN event_type TEXT;
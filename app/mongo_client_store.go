@@ -5,6 +5,7 @@ import (
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
@@ -15,18 +16,26 @@ type mongoDBClientStore struct {
 	client    *mongo.Client
 	clients   *mongo.Collection
 	snapshots *mongo.Collection
+	guard     mongoGuard
 }
 
-func NewMongoDBClientStore(client *mongo.Client) ClientStore {
+func NewMongoDBClientStore(client *mongo.Client, options MongoOptions) ClientStore {
 	db := client.Database(DatabaseName)
 	return &mongoDBClientStore{
 		client:    client,
+		guard:     mongoGuard{options},
 		clients:   db.Collection(ClientsCollectionName),
 		snapshots: db.Collection(SnapshotsCollectionName),
 	}
 }
 
-func (s *mongoDBClientStore) Add(ctx context.Context, client Client) error {
+func (s *mongoDBClientStore) Add(ctx context.Context, client Client) (err error) {
+	ctx, finish, err := s.guard.start(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { err = finish(err) }()
+
 	filter := bson.M{"client_id": client.ID}
 	count, err := s.clients.CountDocuments(ctx, filter)
 	if err != nil {
@@ -43,6 +52,12 @@ func (s *mongoDBClientStore) Add(ctx context.Context, client Client) error {
 }
 
 func (s *mongoDBClientStore) GetOne(ctx context.Context, clientID int) (client Client, err error) {
+	ctx, finish, err := s.guard.start(ctx)
+	if err != nil {
+		return client, err
+	}
+	defer func() { err = finish(err) }()
+
 	filter := bson.M{"client_id": clientID}
 	err = s.clients.FindOne(ctx, filter).Decode(&client)
 	if err != nil {
@@ -54,3 +69,20 @@ func (s *mongoDBClientStore) GetOne(ctx context.Context, clientID int) (client C
 	}
 	return client, nil
 }
+
+func (s *mongoDBClientStore) GetAll(ctx context.Context) (clients []Client, err error) {
+	ctx, finish, err := s.guard.start(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = finish(err) }()
+
+	opts := options.Find().SetSort(bson.D{{Key: "client_id", Value: 1}})
+	cursor, err := s.clients.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cursor.All(ctx, &clients)
+	return clients, err
+}
@@ -0,0 +1,187 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// MongoOptions are shared by the MongoDB stores of the same client.
+type MongoOptions struct {
+	// OperationTimeout bounds every operation, unless its context has an
+	// earlier deadline. Streams are only bounded by their context.
+	OperationTimeout time.Duration
+	// Breaker fails operations fast while MongoDB is unreachable.
+	Breaker *CircuitBreaker
+}
+
+// mongoGuard runs the operations of a store with the timeout and through the
+// breaker of its options. Failures to reach MongoDB are returned wrapped in
+// ErrStoreUnavailable.
+type mongoGuard struct {
+	MongoOptions
+}
+
+func (g mongoGuard) Available() error {
+	if g.Breaker != nil && g.Breaker.Open() {
+		return ErrStoreUnavailable
+	}
+	return nil
+}
+
+// start is called at the top of each operation, which then runs with the
+// returned context and passes its error through finish before returning it.
+func (g mongoGuard) start(ctx context.Context) (context.Context, func(error) error, error) {
+	return g.begin(ctx, g.OperationTimeout)
+}
+
+// startStream is start without the timeout, for operations that last as
+// long as their caller reads.
+func (g mongoGuard) startStream(ctx context.Context) (context.Context, func(error) error, error) {
+	return g.begin(ctx, 0)
+}
+
+func (g mongoGuard) begin(ctx context.Context, timeout time.Duration) (context.Context, func(error) error, error) {
+	if g.Breaker != nil {
+		if err := g.Breaker.Allow(); err != nil {
+			return ctx, nil, err
+		}
+	}
+
+	parent := ctx
+	cancel := func() {}
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+
+	finish := func(err error) error {
+		cancel()
+
+		// a caller giving up says nothing about MongoDB, either way.
+		abandoned := parent.Err() != nil
+		unavailable := err != nil && !abandoned && isMongoUnavailable(err)
+		if g.Breaker != nil {
+			if abandoned {
+				g.Breaker.Abandon()
+			} else {
+				g.Breaker.Record(unavailable)
+			}
+		}
+
+		if unavailable && !errors.Is(err, ErrStoreUnavailable) {
+			return fmt.Errorf("%w: %w", ErrStoreUnavailable, err)
+		}
+		return err
+	}
+	return ctx, finish, nil
+}
+
+func isMongoUnavailable(err error) bool {
+	return mongo.IsNetworkError(err) ||
+		mongo.IsTimeout(err) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, mongo.ErrClientDisconnected)
+}
+
+// PingMongoDB waits for MongoDB to answer, trying up to attempts times and
+// doubling the wait between tries from backoff.
+func PingMongoDB(ctx context.Context, client *mongo.Client, attempts int, backoff time.Duration) error {
+	var err error
+
+	for attempt := 1; attempt <= attempts; attempt++ {
+		pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err = client.Ping(pingCtx, readpref.Primary())
+		cancel()
+
+		if err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			break
+		}
+
+		log.Printf("MongoDB not ready (attempt %d of %d): %v\n", attempt, attempts, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+
+	return fmt.Errorf("MongoDB not ready after %d attempts: %w", attempts, err)
+}
+
+// MongoSupportsTransactions tells whether the server MongoDB client is
+// connected to runs multi-document transactions, which only replica sets and
+// sharded clusters do.
+func MongoSupportsTransactions(ctx context.Context, client *mongo.Client) (bool, error) {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return false, err
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid", nil
+}
+
+// CreateMongoIndexes creates the indexes of the collections of the stores,
+// among which the unique ones that reject a second write of a revision.
+func CreateMongoIndexes(ctx context.Context, db *mongo.Database) error {
+	indexes := map[string][]mongo.IndexModel{
+		TransactionsCollectionName: {
+			{
+				Keys:    bson.D{{Key: "client_id", Value: 1}, {Key: "revision", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys:    bson.M{"revision": 1},
+				Options: options.Index(),
+			},
+		},
+		OutboxCollectionName: {
+			{
+				Keys:    bson.D{{Key: "delivered", Value: 1}, {Key: "client_id", Value: 1}, {Key: "revision", Value: 1}},
+				Options: options.Index(),
+			},
+			{
+				Keys:    bson.D{{Key: "delivered", Value: 1}, {Key: "created_at", Value: 1}},
+				Options: options.Index(),
+			},
+			{
+				// delivered entries are kept for a day.
+				Keys:    bson.M{"delivered_at": 1},
+				Options: options.Index().SetExpireAfterSeconds(24 * 60 * 60),
+			},
+		},
+		DailyTotalsCollectionName: {
+			{
+				Keys:    bson.D{{Key: "client_id", Value: 1}, {Key: "day", Value: 1}},
+				Options: options.Index(),
+			},
+		},
+		SnapshotsCollectionName: {
+			{
+				Keys:    bson.D{{Key: "client_id", Value: 1}, {Key: "revision", Value: -1}},
+				Options: options.Index(),
+			},
+		},
+	}
+
+	for collection, models := range indexes {
+		if _, err := db.Collection(collection).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("error creating the indexes of %s: %w", collection, err)
+		}
+	}
+	return nil
+}
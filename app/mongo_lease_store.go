@@ -0,0 +1,109 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	LeasesCollectionName = "leases"
+)
+
+type mongoDBLeaseStore struct {
+	leases *mongo.Collection
+	guard  mongoGuard
+}
+
+func NewMongoDBLeaseStore(client *mongo.Client, options MongoOptions) LeaseStore {
+	return &mongoDBLeaseStore{
+		leases: client.Database(DatabaseName).Collection(LeasesCollectionName),
+		guard:  mongoGuard{options},
+	}
+}
+
+func (s *mongoDBLeaseStore) Acquire(ctx context.Context, clientID int, owner string, ttl time.Duration) (lease Lease, err error) {
+	ctx, finish, err := s.guard.start(ctx)
+	if err != nil {
+		return lease, err
+	}
+	defer func() { err = finish(err) }()
+
+	now := time.Now()
+
+	// a lease held by someone else does not match the filter, so the upsert
+	// tries to insert a second document with the same _id and fails.
+	filter := bson.M{
+		"_id": clientID,
+		"$or": bson.A{
+			bson.M{"owner": owner},
+			bson.M{"expires_at": bson.M{"$lte": now}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{"owner": owner, "expires_at": now.Add(ttl)},
+		"$inc": bson.M{"token": 1},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	err = s.leases.FindOneAndUpdate(ctx, filter, update, opts).Decode(&lease)
+	if mongo.IsDuplicateKeyError(err) {
+		return lease, ErrLeaseHeld
+	}
+	return lease, err
+}
+
+func (s *mongoDBLeaseStore) Renew(ctx context.Context, lease Lease, ttl time.Duration) (renewed Lease, err error) {
+	ctx, finish, err := s.guard.start(ctx)
+	if err != nil {
+		return lease, err
+	}
+	defer func() { err = finish(err) }()
+
+	expiresAt := time.Now().Add(ttl)
+
+	result, err := s.leases.UpdateOne(ctx,
+		bson.M{"_id": lease.ClientID, "owner": lease.Owner, "token": lease.Token},
+		bson.M{"$set": bson.M{"expires_at": expiresAt}})
+	if err != nil {
+		return lease, err
+	}
+	if result.MatchedCount == 0 {
+		return lease, ErrLeaseLost
+	}
+
+	lease.ExpiresAt = expiresAt
+	return lease, nil
+}
+
+func (s *mongoDBLeaseStore) Release(ctx context.Context, lease Lease) (err error) {
+	ctx, finish, err := s.guard.start(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { err = finish(err) }()
+
+	_, err = s.leases.UpdateOne(ctx,
+		bson.M{"_id": lease.ClientID, "owner": lease.Owner, "token": lease.Token},
+		bson.M{"$set": bson.M{"expires_at": time.Now()}})
+	return err
+}
+
+func (s *mongoDBLeaseStore) Token(ctx context.Context, clientID int) (token int64, err error) {
+	ctx, finish, err := s.guard.start(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { err = finish(err) }()
+
+	var lease Lease
+	err = s.leases.FindOne(ctx, bson.M{"_id": clientID}).Decode(&lease)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+	return lease.Token, err
+}
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	OutboxCollectionName = "outbox"
+)
+
+func newOutboxEntry(transaction Transaction, now time.Time) OutboxEntry {
+	return OutboxEntry{
+		ClientID:     transaction.ClientID,
+		Revision:     transaction.Revision,
+		EventType:    TransactionEventType,
+		EventVersion: TransactionEventVersion,
+		Transaction:  transaction,
+		CreatedAt:    now,
+	}
+}
+
+type mongoDBOutboxStore struct {
+	outbox *mongo.Collection
+	guard  mongoGuard
+}
+
+func NewMongoDBOutboxStore(client *mongo.Client, options MongoOptions) OutboxStore {
+	return &mongoDBOutboxStore{
+		outbox: client.Database(DatabaseName).Collection(OutboxCollectionName),
+		guard:  mongoGuard{options},
+	}
+}
+
+func (s *mongoDBOutboxStore) Pending(ctx context.Context, limit int) (entries []OutboxEntry, err error) {
+	ctx, finish, err := s.guard.start(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = finish(err) }()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "client_id", Value: 1}, {Key: "revision", Value: 1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := s.outbox.Find(ctx, bson.M{"delivered": false}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cursor.All(ctx, &entries)
+	return entries, err
+}
+
+// MarkDelivered takes the hex of the ObjectIDs the entries were inserted
+// with, which is what their _id decodes to.
+func (s *mongoDBOutboxStore) MarkDelivered(ctx context.Context, ids []string) (err error) {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	objectIDs := make([]primitive.ObjectID, len(ids))
+	for i, id := range ids {
+		if objectIDs[i], err = primitive.ObjectIDFromHex(id); err != nil {
+			return fmt.Errorf("invalid outbox entry id %q: %w", id, err)
+		}
+	}
+
+	ctx, finish, err := s.guard.start(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { err = finish(err) }()
+
+	_, err = s.outbox.UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": objectIDs}},
+		bson.M{"$set": bson.M{"delivered": true, "delivered_at": time.Now()}},
+	)
+	return err
+}
+
+func (s *mongoDBOutboxStore) Backlog(ctx context.Context) (backlog OutboxBacklog, err error) {
+	ctx, finish, err := s.guard.start(ctx)
+	if err != nil {
+		return backlog, err
+	}
+	defer func() { err = finish(err) }()
+
+	filter := bson.M{"delivered": false}
+
+	backlog.Pending, err = s.outbox.CountDocuments(ctx, filter)
+	if err != nil || backlog.Pending == 0 {
+		return backlog, err
+	}
+
+	var oldest OutboxEntry
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	err = s.outbox.FindOne(ctx, filter, opts).Decode(&oldest)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return backlog, nil
+	}
+
+	backlog.Oldest = oldest.CreatedAt
+	return backlog, err
+}
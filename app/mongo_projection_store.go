@@ -0,0 +1,132 @@
+package app
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	StatementsCollectionName  = "statements"
+	DailyTotalsCollectionName = "daily_totals"
+)
+
+type mongoDBProjectionStore struct {
+	statements  *mongo.Collection
+	dailyTotals *mongo.Collection
+	guard       mongoGuard
+}
+
+func NewMongoDBProjectionStore(client *mongo.Client, options MongoOptions) ProjectionStore {
+	db := client.Database(DatabaseName)
+	return &mongoDBProjectionStore{
+		guard:       mongoGuard{options},
+		statements:  db.Collection(StatementsCollectionName),
+		dailyTotals: db.Collection(DailyTotalsCollectionName),
+	}
+}
+
+func (s *mongoDBProjectionStore) GetStatement(ctx context.Context, clientID int) (view StatementView, err error) {
+	ctx, finish, err := s.guard.start(ctx)
+	if err != nil {
+		return view, err
+	}
+	defer func() { err = finish(err) }()
+
+	err = s.statements.FindOne(ctx, bson.M{"_id": clientID}).Decode(&view)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return view, ErrNotFound
+	}
+	return view, err
+}
+
+// SaveStatement only replaces a view of an earlier revision. When the stored
+// one is not earlier the filter matches nothing and the upsert fails on the
+// _id, which means there is nothing to do.
+func (s *mongoDBProjectionStore) SaveStatement(ctx context.Context, view StatementView) (err error) {
+	ctx, finish, err := s.guard.start(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { err = finish(err) }()
+
+	filter := bson.M{"_id": view.ClientID, "revision": bson.M{"$lt": view.Revision}}
+	_, err = s.statements.ReplaceOne(ctx, filter, view, options.Replace().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+// AddToDailyTotals is guarded by the revision the same way as SaveStatement.
+func (s *mongoDBProjectionStore) AddToDailyTotals(ctx context.Context, transaction Transaction) (err error) {
+	ctx, finish, err := s.guard.start(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { err = finish(err) }()
+
+	day := DayOf(transaction.Timestamp)
+
+	credits, debits := 0, 0
+	if transaction.Type == CreditTransaction {
+		credits = transaction.Amount
+	} else if transaction.Type == DebitTransaction {
+		debits = transaction.Amount
+	}
+
+	filter := bson.M{
+		"_id":      bson.D{{Key: "client_id", Value: transaction.ClientID}, {Key: "day", Value: day}},
+		"revision": bson.M{"$lt": transaction.Revision},
+	}
+	update := bson.M{
+		"$set": bson.M{"client_id": transaction.ClientID, "day": day, "revision": transaction.Revision},
+		"$inc": bson.M{"credits": credits, "debits": debits, "count": 1},
+	}
+
+	_, err = s.dailyTotals.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *mongoDBProjectionStore) ListDailyTotals(ctx context.Context, clientID int, from, to string) (days []DailyTotals, err error) {
+	ctx, finish, err := s.guard.start(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = finish(err) }()
+
+	filter := bson.M{
+		"client_id": clientID,
+		"day":       bson.M{"$gte": from, "$lte": to},
+	}
+	opts := options.Find().SetSort(bson.M{"day": 1})
+
+	cursor, err := s.dailyTotals.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cursor.All(ctx, &days)
+	return days, err
+}
+
+func (s *mongoDBProjectionStore) Reset(ctx context.Context, clientID int) (err error) {
+	ctx, finish, err := s.guard.start(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { err = finish(err) }()
+
+	if _, err := s.statements.DeleteOne(ctx, bson.M{"_id": clientID}); err != nil {
+		return err
+	}
+
+	_, err = s.dailyTotals.DeleteMany(ctx, bson.M{"client_id": clientID})
+	return err
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -15,40 +16,146 @@ const (
 	DatabaseName               = "rinha_backend"
 	TransactionsCollectionName = "transactions"
 	SnapshotsCollectionName    = "snapshots"
-	SnapshotSize               = 100
 )
 
+// mongoTransactionDocument and mongoSnapshotDocument add the event type and
+// version to what is stored; snapshots already keep their version.
+type mongoTransactionDocument struct {
+	EventType    string `bson:"event_type"`
+	EventVersion int    `bson:"event_version"`
+	Transaction  `bson:",inline"`
+}
+
+type mongoSnapshotDocument struct {
+	EventType string `bson:"event_type"`
+	Snapshot  `bson:",inline"`
+}
+
+func newMongoSnapshotDocument(snapshot Snapshot) mongoSnapshotDocument {
+	snapshot.ID = primitive.NilObjectID
+	if snapshot.Version == 0 {
+		snapshot.Version = BalanceSnapshotVersion
+	}
+	return mongoSnapshotDocument{EventType: SnapshotEventType, Snapshot: snapshot}
+}
+
 type mongoDBTransactionStore struct {
 	client       *mongo.Client
 	transactions *mongo.Collection
 	snapshots    *mongo.Collection
+	leases       *mongo.Collection
+	// outbox is nil unless transactions are also written to the outbox.
+	outbox *mongo.Collection
+	guard  mongoGuard
 }
 
-func NewMongoDBTransactionStore(client *mongo.Client) TransactionStore {
+func NewMongoDBTransactionStore(client *mongo.Client, options MongoOptions) TransactionStore {
 	db := client.Database(DatabaseName)
 	return &mongoDBTransactionStore{
 		client:       client,
+		guard:        mongoGuard{options},
 		transactions: db.Collection(TransactionsCollectionName),
 		snapshots:    db.Collection(SnapshotsCollectionName),
+		leases:       db.Collection(LeasesCollectionName),
 	}
 }
 
-func (s *mongoDBTransactionStore) Add(ctx context.Context, currentBalance int, transaction Transaction) error {
-	_, err := s.transactions.InsertOne(ctx, transaction)
+// NewMongoDBTransactionStoreWithOutbox writes an OutboxEntry in the same
+// multi-document transaction as each Transaction, which needs MongoDB to run
+// as a replica set.
+func NewMongoDBTransactionStoreWithOutbox(client *mongo.Client, options MongoOptions) TransactionStore {
+	store := NewMongoDBTransactionStore(client, options).(*mongoDBTransactionStore)
+	store.outbox = client.Database(DatabaseName).Collection(OutboxCollectionName)
+	return store
+}
+
+func (s *mongoDBTransactionStore) Available() error {
+	return s.guard.Available()
+}
+
+func (s *mongoDBTransactionStore) Add(ctx context.Context, transaction Transaction) (err error) {
+	ctx, finish, err := s.guard.start(ctx)
 	if err != nil {
 		return err
 	}
+	defer func() { err = finish(err) }()
 
-	if transaction.Revision%SnapshotSize == 0 {
-		err = s.takeSnapshot(ctx, transaction.ClientID, transaction.Revision, currentBalance)
-		if err != nil {
-			log.Printf("error taking snapshot for client %d\n", transaction.ClientID)
-		}
+	if s.outbox == nil {
+		err = s.insert(ctx, transaction)
+	} else {
+		err = s.insertWithOutbox(ctx, transaction)
+	}
+
+	if mongo.IsDuplicateKeyError(err) {
+		return &RevisionConflictError{ClientID: transaction.ClientID, Revision: transaction.Revision}
+	}
+	return err
+}
+
+func (s *mongoDBTransactionStore) insert(ctx context.Context, transaction Transaction) error {
+	if err := s.fence(ctx, transaction); err != nil {
+		return err
+	}
+
+	_, err := s.transactions.InsertOne(ctx, mongoTransactionDocument{
+		EventType:    TransactionEventType,
+		EventVersion: TransactionEventVersion,
+		Transaction:  transaction,
+	})
+	return err
+}
+
+// fence checks the token of the write with a conditional write on the lease
+// of the client. Inside the session transaction of insertWithOutbox, that
+// write conflicts with a grant made meanwhile, so the check and the insert
+// are atomic. Without one, a lease granted between the two is settled by the
+// unique index, since its owner writes the same revisions.
+func (s *mongoDBTransactionStore) fence(ctx context.Context, transaction Transaction) error {
+	result, err := s.leases.UpdateOne(ctx,
+		bson.M{"_id": transaction.ClientID, "token": bson.M{"$lte": transaction.FencingToken}},
+		bson.M{"$max": bson.M{"fenced_revision": transaction.Revision}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	// either a newer lease was granted, or none ever was.
+	leases, err := s.leases.CountDocuments(ctx, bson.M{"_id": transaction.ClientID})
+	if err != nil {
+		return err
+	}
+	if leases > 0 {
+		return ErrStaleFencingToken
 	}
 	return nil
 }
 
+func (s *mongoDBTransactionStore) insertWithOutbox(ctx context.Context, transaction Transaction) error {
+	session, err := s.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (any, error) {
+		if err := s.insert(sc, transaction); err != nil {
+			return nil, err
+		}
+		_, err := s.outbox.InsertOne(sc, newOutboxEntry(transaction, time.Now()))
+		return nil, err
+	})
+	return err
+}
+
 func (s *mongoDBTransactionStore) GetTransactionHistory(ctx context.Context, clientID int) (lastSnapshot Snapshot, transactions []Transaction, err error) {
+	ctx, finish, err := s.guard.start(ctx)
+	if err != nil {
+		return lastSnapshot, nil, err
+	}
+	defer func() { err = finish(err) }()
+
 	lastSnapshot, err = s.getLastSnapshot(ctx, clientID)
 
 	if !lastSnapshot.ID.IsZero() {
@@ -61,9 +168,10 @@ func (s *mongoDBTransactionStore) GetTransactionHistory(ctx context.Context, cli
 
 	filter := bson.M{
 		"client_id": clientID,
-		"revision":  bson.M{"$gte": lastSnapshot.Revision - HistorySize},
+		"revision":  bson.M{"$gte": lastSnapshot.ReplayFrom()},
 	}
-	cursor, err := s.transactions.Find(ctx, filter)
+	opts := options.Find().SetSort(bson.D{{Key: "revision", Value: 1}})
+	cursor, err := s.transactions.Find(ctx, filter, opts)
 	if err != nil {
 		return lastSnapshot, nil, err
 	}
@@ -71,7 +179,7 @@ func (s *mongoDBTransactionStore) GetTransactionHistory(ctx context.Context, cli
 
 	for cursor.Next(ctx) {
 		var t Transaction
-		if err := cursor.Decode(&t); err != nil {
+		if err := eventUpcasters.Decode(TransactionEventType, cursor.Current, &t); err != nil {
 			return lastSnapshot, nil, err
 		}
 		transactions = append(transactions, t)
@@ -83,10 +191,128 @@ func (s *mongoDBTransactionStore) GetTransactionHistory(ctx context.Context, cli
 	return lastSnapshot, transactions, nil
 }
 
+func (s *mongoDBTransactionStore) StreamTransactions(ctx context.Context, clientID int, filter TransactionFilter, fn func(Transaction) error) (err error) {
+	ctx, finish, err := s.guard.startStream(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { err = finish(err) }()
+
+	query := bson.M{"client_id": clientID}
+
+	if filter.FromRevision > 0 {
+		query["revision"] = bson.M{"$gte": filter.FromRevision}
+	}
+
+	createdAt := bson.M{}
+	if !filter.From.IsZero() {
+		createdAt["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		createdAt["$lt"] = filter.To
+	}
+	if len(createdAt) > 0 {
+		query["created_at"] = createdAt
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "revision", Value: 1}})
+	cursor, err := s.transactions.Find(ctx, query, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var t Transaction
+		if err := eventUpcasters.Decode(TransactionEventType, cursor.Current, &t); err != nil {
+			return err
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+func (s *mongoDBTransactionStore) ListSnapshots(ctx context.Context, clientID int) (snapshots []Snapshot, err error) {
+	ctx, finish, err := s.guard.start(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = finish(err) }()
+
+	opts := options.Find().SetSort(bson.D{{Key: "revision", Value: 1}})
+	cursor, err := s.snapshots.Find(ctx, bson.M{"client_id": clientID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var snapshot Snapshot
+		if err := eventUpcasters.Decode(SnapshotEventType, cursor.Current, &snapshot); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	return snapshots, cursor.Err()
+}
+
+func (s *mongoDBTransactionStore) ReplaceSnapshots(ctx context.Context, clientID int, snapshots []Snapshot) (err error) {
+	ctx, finish, err := s.guard.start(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { err = finish(err) }()
+
+	// the new snapshots are inserted before the old ones are deleted by id,
+	// so a failure in between leaves the client with both rather than none.
+	opts := options.Find().SetProjection(bson.M{"_id": 1})
+	cursor, err := s.snapshots.Find(ctx, bson.M{"client_id": clientID}, opts)
+	if err != nil {
+		return err
+	}
+	var old []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &old); err != nil {
+		return err
+	}
+
+	if len(snapshots) > 0 {
+		docs := make([]any, len(snapshots))
+		for i, snapshot := range snapshots {
+			snapshot.ClientID = clientID
+			docs[i] = newMongoSnapshotDocument(snapshot)
+		}
+
+		if _, err := s.snapshots.InsertMany(ctx, docs); err != nil {
+			return err
+		}
+	}
+
+	if len(old) == 0 {
+		return nil
+	}
+
+	ids := make([]primitive.ObjectID, len(old))
+	for i, doc := range old {
+		ids[i] = doc.ID
+	}
+
+	_, err = s.snapshots.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	return err
+}
+
 func (s *mongoDBTransactionStore) getLastSnapshot(ctx context.Context, clientID int) (lastSnapshot Snapshot, err error) {
 	var snapshot Snapshot
-	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})
-	err = s.snapshots.FindOne(ctx, bson.D{{Key: "client_id", Value: clientID}}, opts).Decode(&snapshot)
+	opts := options.FindOne().SetSort(bson.D{{Key: "revision", Value: -1}})
+	raw, err := s.snapshots.FindOne(ctx, bson.D{{Key: "client_id", Value: clientID}}, opts).Raw()
+	if err == nil {
+		err = eventUpcasters.Decode(SnapshotEventType, raw, &snapshot)
+	}
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return lastSnapshot, nil
@@ -97,13 +323,60 @@ func (s *mongoDBTransactionStore) getLastSnapshot(ctx context.Context, clientID
 	return snapshot, nil
 }
 
-func (s *mongoDBTransactionStore) takeSnapshot(ctx context.Context, clientID int, revision int, currentBalance int) error {
-	snapshot := Snapshot{
-		ClientID:  clientID,
-		Revision:  revision,
-		Balance:   currentBalance,
-		CreatedAt: time.Now(),
+func (s *mongoDBTransactionStore) SaveSnapshot(ctx context.Context, snapshot Snapshot) (err error) {
+	ctx, finish, err := s.guard.start(ctx)
+	if err != nil {
+		return err
 	}
-	_, err := s.snapshots.InsertOne(ctx, snapshot)
+	defer func() { err = finish(err) }()
+
+	_, err = s.snapshots.InsertOne(ctx, newMongoSnapshotDocument(snapshot))
 	return err
 }
+
+func (s *mongoDBTransactionStore) PruneSnapshots(ctx context.Context, clientID int, keep int) (err error) {
+	ctx, finish, err := s.guard.start(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { err = finish(err) }()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "revision", Value: -1}}).
+		SetSkip(int64(keep)).
+		SetProjection(bson.M{"_id": 1})
+	cursor, err := s.snapshots.Find(ctx, bson.M{"client_id": clientID}, opts)
+	if err != nil {
+		return err
+	}
+
+	var pruned []Snapshot
+	if err := cursor.All(ctx, &pruned); err != nil {
+		return err
+	}
+	if len(pruned) == 0 {
+		return nil
+	}
+
+	ids := make(bson.A, len(pruned))
+	for i, snapshot := range pruned {
+		ids[i] = snapshot.ID
+	}
+
+	_, err = s.snapshots.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	return err
+}
+
+func (s *mongoDBTransactionStore) DeleteTransactions(ctx context.Context, clientID int, revision int) (deleted int64, err error) {
+	ctx, finish, err := s.guard.start(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { err = finish(err) }()
+
+	result, err := s.transactions.DeleteMany(ctx, bson.M{"client_id": clientID, "revision": bson.M{"$lte": revision}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
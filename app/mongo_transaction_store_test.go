@@ -0,0 +1,69 @@
+package app
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// openTestMongo connects to the MongoDB of MONGO_TEST_URL, skipping the test
+// when it is not set. The database of the stores is dropped and indexed
+// again, so it must be a server used for nothing else. The outbox also needs
+// it to run as a replica set.
+func openTestMongo(t *testing.T) *mongo.Client {
+	t.Helper()
+
+	url := os.Getenv("MONGO_TEST_URL")
+	if url == "" {
+		t.Skip("MONGO_TEST_URL is not set")
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(url))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+
+	db := client.Database(DatabaseName)
+	if err := db.Drop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateMongoIndexes(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestMongoDBTransactionStore(t *testing.T) {
+	testTransactionStore(t, NewMongoDBTransactionStore(openTestMongo(t), MongoOptions{}))
+}
+
+func TestMongoDBTransactionStoreWithOutbox(t *testing.T) {
+	testTransactionStore(t, NewMongoDBTransactionStoreWithOutbox(openTestMongo(t), MongoOptions{}))
+}
+
+func TestMongoSupportsTransactions(t *testing.T) {
+	supported, err := MongoSupportsTransactions(context.Background(), openTestMongo(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !supported {
+		t.Error("MONGO_TEST_URL is not a replica set, so the outbox tests cannot pass")
+	}
+}
+
+func TestMongoDBFullStateSnapshots(t *testing.T) {
+	testFullStateSnapshots(t, NewMongoDBTransactionStore(openTestMongo(t), MongoOptions{}))
+}
+
+func TestMongoDBSnapshotRetention(t *testing.T) {
+	testSnapshotRetention(t, NewMongoDBTransactionStore(openTestMongo(t), MongoOptions{}))
+}
+
+func TestMongoDBClientStore(t *testing.T) {
+	testClientStore(t, NewMongoDBClientStore(openTestMongo(t), MongoOptions{}))
+}
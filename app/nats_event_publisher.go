@@ -0,0 +1,151 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultNATSSubject = "transactions"
+
+	natsDefaultPort = "4222"
+	natsTimeout     = 5 * time.Second
+)
+
+// natsEventPublisher speaks the NATS client protocol, publishing each event
+// on "<subject>.<client id>". Every publish is followed by a PING, and only
+// the PONG that answers it, which the server sends after processing the
+// PUB, acknowledges the event.
+type natsEventPublisher struct {
+	address string
+	subject string
+
+	mutex  sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewNATSEventPublisher publishes to the server at url, such as
+// "nats://127.0.0.1:4222". It connects on the first publish and again after
+// any error.
+func NewNATSEventPublisher(url string, subject string) EventPublisher {
+	address := strings.TrimPrefix(url, "nats://")
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, natsDefaultPort)
+	}
+	if subject == "" {
+		subject = DefaultNATSSubject
+	}
+
+	return &natsEventPublisher{
+		address: address,
+		subject: subject,
+	}
+}
+
+func (p *natsEventPublisher) Publish(ctx context.Context, event TransactionEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err := p.publish(ctx, fmt.Sprintf("%s.%d", p.subject, event.ClientID), payload); err != nil {
+		p.disconnect()
+		return fmt.Errorf("error publishing to nats at %s: %w", p.address, err)
+	}
+	return nil
+}
+
+func (p *natsEventPublisher) publish(ctx context.Context, subject string, payload []byte) error {
+	if p.conn == nil {
+		if err := p.connect(ctx); err != nil {
+			return err
+		}
+	}
+
+	deadline := time.Now().Add(natsTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	p.conn.SetDeadline(deadline)
+
+	stop := context.AfterFunc(ctx, func() {
+		p.conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	msg := fmt.Sprintf("PUB %s %d\r\n%s\r\nPING\r\n", subject, len(payload), payload)
+	if _, err := p.conn.Write([]byte(msg)); err != nil {
+		return err
+	}
+
+	return p.waitPong()
+}
+
+func (p *natsEventPublisher) connect(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: natsTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", p.address)
+	if err != nil {
+		return err
+	}
+
+	p.conn = conn
+	p.reader = bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(natsTimeout))
+
+	line, err := p.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO") {
+		return fmt.Errorf("unexpected greeting %q", line)
+	}
+
+	_, err = conn.Write([]byte("CONNECT {\"verbose\":false,\"pedantic\":false,\"name\":\"rinha-backend\"}\r\n"))
+	return err
+}
+
+func (p *natsEventPublisher) waitPong() error {
+	for {
+		line, err := p.readLine()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := p.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+func (p *natsEventPublisher) readLine() (string, error) {
+	line, err := p.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (p *natsEventPublisher) disconnect() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+		p.reader = nil
+	}
+}
@@ -0,0 +1,181 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"time"
+)
+
+const (
+	// OutboxRelayLeaseID is the lease held by the process relaying the
+	// outbox, so only one does at a time. Client ids start at 1.
+	OutboxRelayLeaseID = 0
+
+	DefaultOutboxBatch    = 100
+	DefaultOutboxInterval = time.Second
+)
+
+var (
+	outboxRelayed    = expvar.NewInt("outbox_relayed")
+	outboxErrors     = expvar.NewInt("outbox_errors")
+	outboxPending    = expvar.NewInt("outbox_pending")
+	outboxLagSeconds = expvar.NewFloat("outbox_lag_seconds")
+)
+
+// OutboxRelay publishes the outbox entries to a sink and marks them
+// delivered. An entry published right before the process dies is published
+// again by the next relay, so the sink sees every event at least once.
+type OutboxRelay struct {
+	outbox       OutboxStore
+	sink         EventPublisher
+	leases       LeaseStore
+	leaseOptions LeaseOptions
+	interval     time.Duration
+	batch        int
+}
+
+func NewOutboxRelay(outbox OutboxStore, sink EventPublisher, leases LeaseStore, leaseOptions LeaseOptions, interval time.Duration) *OutboxRelay {
+	if leaseOptions.Owner == "" {
+		leaseOptions.Owner = DefaultLeaseOwner()
+	}
+	if leaseOptions.TTL <= 0 {
+		leaseOptions.TTL = DefaultLeaseTTL
+	}
+	if interval <= 0 {
+		interval = DefaultOutboxInterval
+	}
+
+	return &OutboxRelay{
+		outbox:       outbox,
+		sink:         sink,
+		leases:       leases,
+		leaseOptions: leaseOptions,
+		interval:     interval,
+		batch:        DefaultOutboxBatch,
+	}
+}
+
+// Run relays until ctx is done, waiting for the relay lease while another
+// process holds it.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	var lease *Lease
+
+	defer func() {
+		if lease != nil {
+			if err := r.leases.Release(context.Background(), *lease); err != nil {
+				log.Printf("error releasing outbox relay lease: %v\n", err)
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		if lease, err = r.holdLease(ctx, lease); err != nil && !errors.Is(err, ErrLeaseHeld) {
+			log.Printf("error acquiring outbox relay lease: %v\n", err)
+		}
+
+		if lease != nil {
+			r.measureLag(ctx)
+		}
+
+		// a full batch means there is more to relay right away.
+		for lease != nil && ctx.Err() == nil {
+			relayed, err := r.relay(ctx)
+			if err != nil {
+				outboxErrors.Add(1)
+				log.Printf("error relaying outbox: %v\n", err)
+				break
+			}
+			if relayed < r.batch {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// holdLease renews the lease, or acquires it when there is none or it was
+// lost. It returns nil when the lease is held by another process.
+func (r *OutboxRelay) holdLease(ctx context.Context, lease *Lease) (*Lease, error) {
+	if lease != nil {
+		renewed, err := r.leases.Renew(ctx, *lease, r.leaseOptions.TTL)
+		if err == nil {
+			return &renewed, nil
+		}
+		if !errors.Is(err, ErrLeaseLost) && time.Now().Before(lease.ExpiresAt) {
+			return lease, err
+		}
+		log.Printf("outbox relay lease lost: %v\n", err)
+	}
+
+	acquired, err := r.leases.Acquire(ctx, OutboxRelayLeaseID, r.leaseOptions.Owner, r.leaseOptions.TTL)
+	if err != nil {
+		return nil, err
+	}
+	if lease == nil {
+		log.Printf("relaying outbox as %s\n", r.leaseOptions.Owner)
+	}
+	return &acquired, nil
+}
+
+// measureLag exports how many entries wait and for how long the oldest did.
+func (r *OutboxRelay) measureLag(ctx context.Context) {
+	backlog, err := r.outbox.Backlog(ctx)
+	if err != nil {
+		log.Printf("error measuring outbox backlog: %v\n", err)
+		return
+	}
+
+	outboxPending.Set(backlog.Pending)
+	if backlog.Oldest.IsZero() {
+		outboxLagSeconds.Set(0)
+	} else {
+		outboxLagSeconds.Set(time.Since(backlog.Oldest).Seconds())
+	}
+}
+
+// relay publishes a batch of pending entries in order. It stops at the first
+// entry that cannot be published, so the entries after it of the same client
+// are not published before it.
+func (r *OutboxRelay) relay(ctx context.Context) (int, error) {
+	entries, err := r.outbox.Pending(ctx, r.batch)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	delivered := make([]string, 0, len(entries))
+	var publishErr error
+
+	for _, entry := range entries {
+		event := TransactionEvent{
+			EventType:    entry.EventType,
+			EventVersion: entry.EventVersion,
+			Transaction:  entry.Transaction,
+		}
+		if publishErr = r.sink.Publish(ctx, event); publishErr != nil {
+			break
+		}
+		delivered = append(delivered, entry.ID)
+	}
+
+	if err := r.outbox.MarkDelivered(ctx, delivered); err != nil {
+		return 0, err
+	}
+	outboxRelayed.Add(int64(len(delivered)))
+
+	return len(delivered), publishErr
+}
@@ -0,0 +1,230 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeOutboxStore struct {
+	mutex   sync.Mutex
+	entries []OutboxEntry
+}
+
+func (s *fakeOutboxStore) add(transaction Transaction) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry := newOutboxEntry(transaction, time.Now())
+	entry.ID = fmt.Sprint(len(s.entries) + 1)
+	s.entries = append(s.entries, entry)
+}
+
+func (s *fakeOutboxStore) Pending(ctx context.Context, limit int) ([]OutboxEntry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var pending []OutboxEntry
+	for _, entry := range s.entries {
+		if !entry.Delivered {
+			pending = append(pending, entry)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].ClientID != pending[j].ClientID {
+			return pending[i].ClientID < pending[j].ClientID
+		}
+		return pending[i].Revision < pending[j].Revision
+	})
+
+	if len(pending) > limit {
+		pending = pending[:limit]
+	}
+	return pending, nil
+}
+
+func (s *fakeOutboxStore) MarkDelivered(ctx context.Context, ids []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, id := range ids {
+		for i := range s.entries {
+			if s.entries[i].ID == id {
+				s.entries[i].Delivered = true
+			}
+		}
+	}
+	return nil
+}
+
+func (s *fakeOutboxStore) Backlog(ctx context.Context) (backlog OutboxBacklog, err error) {
+	pending, _ := s.Pending(ctx, len(s.entries))
+	backlog.Pending = int64(len(pending))
+	return backlog, nil
+}
+
+// flakySink fails every other publish.
+type flakySink struct {
+	mutex  sync.Mutex
+	calls  int
+	events []TransactionEvent
+}
+
+func (s *flakySink) Publish(ctx context.Context, event TransactionEvent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.calls++
+	if s.calls%2 == 0 {
+		return fmt.Errorf("broker unavailable")
+	}
+	s.events = append(s.events, event)
+	return nil
+}
+
+func TestOutboxRelayPublishesInOrder(t *testing.T) {
+	outbox := &fakeOutboxStore{}
+	for revision := 1; revision <= 4; revision++ {
+		outbox.add(Transaction{ClientID: 2, Revision: revision})
+		outbox.add(Transaction{ClientID: 1, Revision: revision})
+	}
+
+	sink := &flakySink{}
+	leases := NewMemoryLeaseStore()
+	relay := NewOutboxRelay(outbox, sink, leases, LeaseOptions{Owner: "relay"}, 10*time.Millisecond)
+	relay.batch = 3
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		relay.Run(ctx)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		backlog, _ := outbox.Backlog(ctx)
+		if backlog.Pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d entries still pending", backlog.Pending)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := leases.Acquire(context.Background(), OutboxRelayLeaseID, "other", time.Second); err != ErrLeaseHeld {
+		t.Errorf("error = %v, want the relay lease held", err)
+	}
+
+	cancel()
+	<-done
+
+	next := map[int]int{1: 1, 2: 1}
+	for _, event := range sink.events {
+		if event.Revision < next[event.ClientID] {
+			continue
+		}
+		if event.Revision != next[event.ClientID] {
+			t.Fatalf("client %d got revision %d before %d", event.ClientID, event.Revision, next[event.ClientID])
+		}
+		next[event.ClientID]++
+	}
+	if next[1] != 5 || next[2] != 5 {
+		t.Errorf("not every revision was published: %v", next)
+	}
+
+	if _, err := leases.Acquire(context.Background(), OutboxRelayLeaseID, "other", time.Second); err != nil {
+		t.Errorf("relay lease not released: %v", err)
+	}
+}
+
+// testOutboxStore runs the OutboxStore contract against an outbox written by
+// transactions, which fences its writes with leases.
+func testOutboxStore(t *testing.T, leases LeaseStore, transactions TransactionStore, outbox OutboxStore) {
+	t.Helper()
+	ctx := context.Background()
+	start := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	lease, err := leases.Acquire(ctx, 1, "owner", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(clientID, revision int, token int64) error {
+		return transactions.Add(ctx, Transaction{ClientID: clientID, Revision: revision, Amount: revision * 10, Type: CreditTransaction,
+			Description: "x", Timestamp: start.Add(time.Duration(revision) * time.Minute), FencingToken: token})
+	}
+
+	for revision := 1; revision <= 3; revision++ {
+		if err := write(2, revision, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := write(1, revision, lease.Token); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var conflict *RevisionConflictError
+	if err := write(1, 3, lease.Token); !errors.As(err, &conflict) {
+		t.Errorf("duplicate revision: error = %v, want a RevisionConflictError", err)
+	}
+	if err := write(1, 4, lease.Token-1); !errors.Is(err, ErrStaleFencingToken) {
+		t.Errorf("stale write: error = %v, want ErrStaleFencingToken", err)
+	}
+
+	backlog, err := outbox.Backlog(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backlog.Pending != 6 || backlog.Oldest.IsZero() {
+		t.Errorf("backlog = %+v, want 6 entries with the oldest set", backlog)
+	}
+
+	pending, err := outbox.Pending(ctx, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, entry := range pending {
+		got = append(got, fmt.Sprintf("%d/%d", entry.ClientID, entry.Revision))
+		if entry.Transaction.Amount != entry.Revision*10 || !entry.Transaction.Timestamp.Equal(start.Add(time.Duration(entry.Revision)*time.Minute)) {
+			t.Errorf("entry %d/%d carries %+v", entry.ClientID, entry.Revision, entry.Transaction)
+		}
+		if entry.EventType != TransactionEventType || entry.EventVersion != TransactionEventVersion {
+			t.Errorf("entry %d/%d is a %s v%d", entry.ClientID, entry.Revision, entry.EventType, entry.EventVersion)
+		}
+	}
+	if fmt.Sprint(got) != "[1/1 1/2 1/3 2/1]" {
+		t.Errorf("pending = %v, want [1/1 1/2 1/3 2/1]", got)
+	}
+
+	if err := outbox.MarkDelivered(ctx, []string{pending[0].ID, pending[1].ID}); err != nil {
+		t.Fatal(err)
+	}
+	pending, err = outbox.Pending(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 4 || pending[0].ClientID != 1 || pending[0].Revision != 3 {
+		t.Errorf("after delivering two, %d pending starting at %d/%d", len(pending), pending[0].ClientID, pending[0].Revision)
+	}
+}
+
+func TestSQLiteOutboxStore(t *testing.T) {
+	db := openTestSQLite(t)
+	testOutboxStore(t, NewSQLiteLeaseStore(db), NewSQLiteTransactionStoreWithOutbox(db), NewSQLiteOutboxStore(db))
+}
+
+func TestPostgresOutboxStore(t *testing.T) {
+	pool := openTestPostgres(t)
+	testOutboxStore(t, NewPostgresLeaseStore(pool), NewPostgresTransactionStoreWithOutbox(pool), NewPostgresOutboxStore(pool))
+}
+
+func TestMongoDBOutboxStore(t *testing.T) {
+	client := openTestMongo(t)
+	testOutboxStore(t, NewMongoDBLeaseStore(client, MongoOptions{}), NewMongoDBTransactionStoreWithOutbox(client, MongoOptions{}), NewMongoDBOutboxStore(client, MongoOptions{}))
+}
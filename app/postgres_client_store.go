@@ -0,0 +1,55 @@
+package app
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type postgresClientStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresClientStore(pool *pgxpool.Pool) ClientStore {
+	return &postgresClientStore{pool: pool}
+}
+
+func (s *postgresClientStore) Add(ctx context.Context, client Client) error {
+	_, err := s.pool.Exec(ctx,
+		"INSERT INTO clients (client_id, credit_limit, balance) VALUES ($1, $2, $3) ON CONFLICT (client_id) DO NOTHING",
+		client.ID, client.CreditLimit, client.Balance)
+	return err
+}
+
+func (s *postgresClientStore) GetOne(ctx context.Context, clientID int) (client Client, err error) {
+	err = s.pool.QueryRow(ctx, "SELECT client_id, credit_limit, balance FROM clients WHERE client_id = $1", clientID).
+		Scan(&client.ID, &client.CreditLimit, &client.Balance)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return client, ErrNotFound
+		}
+
+		return client, err
+	}
+	return client, nil
+}
+
+func (s *postgresClientStore) GetAll(ctx context.Context) (clients []Client, err error) {
+	rows, err := s.pool.Query(ctx, "SELECT client_id, credit_limit, balance FROM clients ORDER BY client_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c Client
+		if err := rows.Scan(&c.ID, &c.CreditLimit, &c.Balance); err != nil {
+			return nil, err
+		}
+		clients = append(clients, c)
+	}
+
+	return clients, rows.Err()
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type postgresLeaseStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresLeaseStore(pool *pgxpool.Pool) LeaseStore {
+	return &postgresLeaseStore{pool: pool}
+}
+
+func (s *postgresLeaseStore) Acquire(ctx context.Context, clientID int, owner string, ttl time.Duration) (Lease, error) {
+	lease := Lease{ClientID: clientID, Owner: owner}
+
+	err := s.pool.QueryRow(ctx, `INSERT INTO leases (client_id, owner, token, expires_at)
+		VALUES ($1, $2, 1, now() + $3::interval)
+		ON CONFLICT (client_id) DO UPDATE
+		SET owner = EXCLUDED.owner, token = leases.token + 1, expires_at = EXCLUDED.expires_at
+		WHERE leases.owner = EXCLUDED.owner OR leases.expires_at <= now()
+		RETURNING token, expires_at`,
+		clientID, owner, ttl).Scan(&lease.Token, &lease.ExpiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return lease, ErrLeaseHeld
+	}
+	return lease, err
+}
+
+func (s *postgresLeaseStore) Renew(ctx context.Context, lease Lease, ttl time.Duration) (Lease, error) {
+	err := s.pool.QueryRow(ctx,
+		"UPDATE leases SET expires_at = now() + $4::interval WHERE client_id = $1 AND owner = $2 AND token = $3 RETURNING expires_at",
+		lease.ClientID, lease.Owner, lease.Token, ttl).Scan(&lease.ExpiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return lease, ErrLeaseLost
+	}
+	return lease, err
+}
+
+func (s *postgresLeaseStore) Release(ctx context.Context, lease Lease) error {
+	_, err := s.pool.Exec(ctx,
+		"UPDATE leases SET expires_at = now() WHERE client_id = $1 AND owner = $2 AND token = $3",
+		lease.ClientID, lease.Owner, lease.Token)
+	return err
+}
+
+func (s *postgresLeaseStore) Token(ctx context.Context, clientID int) (token int64, err error) {
+	err = s.pool.QueryRow(ctx, "SELECT token FROM leases WHERE client_id = $1", clientID).Scan(&token)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	return token, err
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// postgresMigrationLock is the advisory lock key that keeps backends starting
+// at the same time from applying migrations concurrently.
+const postgresMigrationLock = 20240201
+
+// MigratePostgres applies the embedded migrations that were not applied yet.
+func MigratePostgres(ctx context.Context, pool *pgxpool.Pool) error {
+	migrations, err := loadMigrations("postgres")
+	if err != nil {
+		return err
+	}
+
+	return pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", postgresMigrationLock); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`)
+		if err != nil {
+			return fmt.Errorf("error creating schema_migrations table: %w", err)
+		}
+
+		for _, m := range migrations {
+			var applied bool
+			err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", m.version).Scan(&applied)
+			if err != nil {
+				return err
+			}
+			if applied {
+				continue
+			}
+
+			log.Printf("applying postgres migration %s\n", m.name)
+
+			if _, err := tx.Exec(ctx, m.sql); err != nil {
+				return fmt.Errorf("error applying migration %s: %w", m.name, err)
+			}
+
+			if _, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", m.version); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// DropPostgresSchema removes every table managed by the migrations.
+func DropPostgresSchema(ctx context.Context, pool *pgxpool.Pool) error {
+	_, err := pool.Exec(ctx, "DROP TABLE IF EXISTS outbox, leases, snapshots, transactions, clients, schema_migrations")
+	return err
+}
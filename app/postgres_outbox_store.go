@@ -0,0 +1,100 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// postgresOutboxColumns leave out the id, a serial the database assigns.
+const postgresOutboxColumns = "client_id, revision, event_type, event_version, amount, type, description, transaction_created_at, created_at"
+
+func insertPostgresOutboxEntry(ctx context.Context, db postgresExecer, entry OutboxEntry) error {
+	t := entry.Transaction
+	_, err := db.Exec(ctx, "INSERT INTO outbox ("+postgresOutboxColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		entry.ClientID, entry.Revision, entry.EventType, entry.EventVersion,
+		t.Amount, string(t.Type), t.Description, t.Timestamp, entry.CreatedAt)
+	return err
+}
+
+// parseOutboxSerials reads back the ids the SQL outboxes hand out, the
+// serials of their rows.
+func parseOutboxSerials(ids []string) ([]int64, error) {
+	serials := make([]int64, len(ids))
+	for i, id := range ids {
+		serial, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid outbox entry id %q: %w", id, err)
+		}
+		serials[i] = serial
+	}
+	return serials, nil
+}
+
+// postgresOutboxStore deletes the entries once delivered, as nothing reads
+// them afterwards.
+type postgresOutboxStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresOutboxStore(pool *pgxpool.Pool) OutboxStore {
+	return &postgresOutboxStore{pool: pool}
+}
+
+func (s *postgresOutboxStore) Pending(ctx context.Context, limit int) (entries []OutboxEntry, err error) {
+	rows, err := s.pool.Query(ctx,
+		"SELECT id, "+postgresOutboxColumns+" FROM outbox ORDER BY client_id, revision LIMIT $1",
+		limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry OutboxEntry
+		var id int64
+		var txType string
+		t := &entry.Transaction
+		if err := rows.Scan(&id, &entry.ClientID, &entry.Revision, &entry.EventType, &entry.EventVersion,
+			&t.Amount, &txType, &t.Description, &t.Timestamp, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entry.ID = strconv.FormatInt(id, 10)
+		t.ClientID = entry.ClientID
+		t.Revision = entry.Revision
+		t.Type = TransactionType(txType)
+
+		if err := upcastRow(TransactionEventType, &entry.EventType, &entry.EventVersion, t); err != nil {
+			return nil, fmt.Errorf("error decoding outbox entry of revision %d of client %d: %w", entry.Revision, entry.ClientID, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+func (s *postgresOutboxStore) MarkDelivered(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	serials, err := parseOutboxSerials(ids)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, "DELETE FROM outbox WHERE id = ANY($1)", serials)
+	return err
+}
+
+func (s *postgresOutboxStore) Backlog(ctx context.Context) (backlog OutboxBacklog, err error) {
+	var oldest *time.Time
+	err = s.pool.QueryRow(ctx, "SELECT count(*), min(created_at) FROM outbox").Scan(&backlog.Pending, &oldest)
+	if oldest != nil {
+		backlog.Oldest = *oldest
+	}
+	return backlog, err
+}
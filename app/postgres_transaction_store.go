@@ -0,0 +1,231 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	postgresUniqueViolation = "23505"
+
+	postgresTransactionColumns = "client_id, revision, amount, type, description, created_at, event_type, event_version"
+
+	postgresSnapshotColumns = "version, client_id, revision, balance, credit_limit, history, created_at, event_type"
+	postgresInsertSnapshot  = "INSERT INTO snapshots (" + postgresSnapshotColumns + ") VALUES ($1, $2, $3, $4, $5, $6, $7, $8)"
+)
+
+type postgresTransactionStore struct {
+	pool   *pgxpool.Pool
+	outbox bool
+}
+
+func NewPostgresTransactionStore(pool *pgxpool.Pool) TransactionStore {
+	return &postgresTransactionStore{pool: pool}
+}
+
+// NewPostgresTransactionStoreWithOutbox writes an OutboxEntry in the same
+// database transaction as each Transaction.
+func NewPostgresTransactionStoreWithOutbox(pool *pgxpool.Pool) TransactionStore {
+	return &postgresTransactionStore{pool: pool, outbox: true}
+}
+
+// postgresExecer is what Add needs of either the pool or a transaction.
+type postgresExecer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+func (s *postgresTransactionStore) Add(ctx context.Context, transaction Transaction) error {
+	var err error
+	if !s.outbox {
+		err = s.insert(ctx, s.pool, transaction)
+	} else {
+		err = pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+			if err := s.insert(ctx, tx, transaction); err != nil {
+				return err
+			}
+			return insertPostgresOutboxEntry(ctx, tx, newOutboxEntry(transaction, time.Now()))
+		})
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == postgresUniqueViolation {
+		return &RevisionConflictError{ClientID: transaction.ClientID, Revision: transaction.Revision}
+	}
+	return err
+}
+
+func (s *postgresTransactionStore) insert(ctx context.Context, db postgresExecer, transaction Transaction) error {
+	tag, err := db.Exec(ctx, `INSERT INTO transactions (`+postgresTransactionColumns+`)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8
+		WHERE NOT EXISTS (SELECT 1 FROM leases WHERE client_id = $1 AND token > $9)`,
+		transaction.ClientID, transaction.Revision, transaction.Amount, string(transaction.Type), transaction.Description, transaction.Timestamp,
+		TransactionEventType, TransactionEventVersion, transaction.FencingToken)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrStaleFencingToken
+	}
+	return nil
+}
+
+func (s *postgresTransactionStore) GetTransactionHistory(ctx context.Context, clientID int) (lastSnapshot Snapshot, transactions []Transaction, err error) {
+	lastSnapshot, err = s.getLastSnapshot(ctx, clientID)
+	if err != nil {
+		return lastSnapshot, nil, err
+	}
+
+	if lastSnapshot.Revision > 0 {
+		log.Printf("loading state of client %d from snapshot revision %d:\n", clientID, lastSnapshot.Revision)
+	}
+
+	err = s.StreamTransactions(ctx, clientID, TransactionFilter{FromRevision: lastSnapshot.ReplayFrom()}, func(t Transaction) error {
+		transactions = append(transactions, t)
+		return nil
+	})
+	if err != nil {
+		return lastSnapshot, nil, err
+	}
+
+	return lastSnapshot, transactions, nil
+}
+
+func (s *postgresTransactionStore) StreamTransactions(ctx context.Context, clientID int, filter TransactionFilter, fn func(Transaction) error) error {
+	conditions := []string{"client_id = $1"}
+	args := []any{clientID}
+
+	if filter.FromRevision > 0 {
+		args = append(args, filter.FromRevision)
+		conditions = append(conditions, fmt.Sprintf("revision >= $%d", len(args)))
+	}
+	if !filter.From.IsZero() {
+		args = append(args, filter.From)
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if !filter.To.IsZero() {
+		args = append(args, filter.To)
+		conditions = append(conditions, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+
+	rows, err := s.pool.Query(ctx,
+		"SELECT "+postgresTransactionColumns+" FROM transactions WHERE "+strings.Join(conditions, " AND ")+" ORDER BY revision",
+		args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t Transaction
+		var txType string
+		var eventType *string
+		var eventVersion *int
+		if err := rows.Scan(&t.ClientID, &t.Revision, &t.Amount, &txType, &t.Description, &t.Timestamp, &eventType, &eventVersion); err != nil {
+			return err
+		}
+		t.Type = TransactionType(txType)
+
+		if err := upcastRow(TransactionEventType, eventType, eventVersion, &t); err != nil {
+			return fmt.Errorf("error decoding revision %d of client %d: %w", t.Revision, clientID, err)
+		}
+
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (s *postgresTransactionStore) ListSnapshots(ctx context.Context, clientID int) (snapshots []Snapshot, err error) {
+	rows, err := s.pool.Query(ctx,
+		"SELECT "+postgresSnapshotColumns+" FROM snapshots WHERE client_id = $1 ORDER BY revision",
+		clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		snapshot, err := scanPostgresSnapshot(rows)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	return snapshots, rows.Err()
+}
+
+func (s *postgresTransactionStore) ReplaceSnapshots(ctx context.Context, clientID int, snapshots []Snapshot) error {
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "DELETE FROM snapshots WHERE client_id = $1", clientID); err != nil {
+			return err
+		}
+
+		for _, snapshot := range snapshots {
+			snapshot.ClientID = clientID
+			if _, err := tx.Exec(ctx, postgresInsertSnapshot, postgresSnapshotArgs(snapshot)...); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *postgresTransactionStore) getLastSnapshot(ctx context.Context, clientID int) (lastSnapshot Snapshot, err error) {
+	lastSnapshot, err = scanPostgresSnapshot(s.pool.QueryRow(ctx,
+		"SELECT "+postgresSnapshotColumns+" FROM snapshots WHERE client_id = $1 ORDER BY revision DESC LIMIT 1",
+		clientID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Snapshot{}, nil
+		}
+
+		return Snapshot{}, err
+	}
+	return lastSnapshot, nil
+}
+
+func (s *postgresTransactionStore) SaveSnapshot(ctx context.Context, snapshot Snapshot) error {
+	_, err := s.pool.Exec(ctx, postgresInsertSnapshot, postgresSnapshotArgs(snapshot)...)
+	return err
+}
+
+func (s *postgresTransactionStore) PruneSnapshots(ctx context.Context, clientID int, keep int) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM snapshots WHERE client_id = $1 AND id NOT IN (
+		SELECT id FROM snapshots WHERE client_id = $1 ORDER BY revision DESC LIMIT $2
+	)`, clientID, keep)
+	return err
+}
+
+func (s *postgresTransactionStore) DeleteTransactions(ctx context.Context, clientID int, revision int) (int64, error) {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM transactions WHERE client_id = $1 AND revision <= $2", clientID, revision)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func postgresSnapshotArgs(snapshot Snapshot) []any {
+	return []any{snapshot.Version, snapshot.ClientID, snapshot.Revision, snapshot.Balance, snapshot.CreditLimit, snapshot.History, snapshot.CreatedAt, SnapshotEventType}
+}
+
+func scanPostgresSnapshot(row pgx.Row) (snapshot Snapshot, err error) {
+	var eventType *string
+	if err := row.Scan(&snapshot.Version, &snapshot.ClientID, &snapshot.Revision, &snapshot.Balance, &snapshot.CreditLimit, &snapshot.History, &snapshot.CreatedAt, &eventType); err != nil {
+		return snapshot, err
+	}
+
+	version := snapshot.Version
+	return snapshot, upcastRow(SnapshotEventType, eventType, &version, &snapshot)
+}
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// openTestPostgres connects to the database of POSTGRES_TEST_URL, skipping
+// the test when it is not set. Its tables are dropped and migrated again, so
+// it must be a database used for nothing else.
+func openTestPostgres(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	url := os.Getenv("POSTGRES_TEST_URL")
+	if url == "" {
+		t.Skip("POSTGRES_TEST_URL is not set")
+	}
+
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	if err := DropPostgresSchema(ctx, pool); err != nil {
+		t.Fatal(err)
+	}
+	if err := MigratePostgres(ctx, pool); err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+func TestPostgresTransactionStore(t *testing.T) {
+	testTransactionStore(t, NewPostgresTransactionStore(openTestPostgres(t)))
+}
+
+func TestPostgresFullStateSnapshots(t *testing.T) {
+	testFullStateSnapshots(t, NewPostgresTransactionStore(openTestPostgres(t)))
+}
+
+func TestPostgresSnapshotRetention(t *testing.T) {
+	testSnapshotRetention(t, NewPostgresTransactionStore(openTestPostgres(t)))
+}
+
+func TestPostgresClientStore(t *testing.T) {
+	testClientStore(t, NewPostgresClientStore(openTestPostgres(t)))
+}
+
+func TestPostgresLeaseStore(t *testing.T) {
+	testLeaseStore(t, NewPostgresLeaseStore(openTestPostgres(t)))
+}
+
+func TestPostgresFencing(t *testing.T) {
+	pool := openTestPostgres(t)
+	testFencing(t, NewPostgresLeaseStore(pool), NewPostgresTransactionStore(pool))
+}
+
+func TestPostgresReadsRowsWithoutEventTypes(t *testing.T) {
+	pool := openTestPostgres(t)
+	ctx := context.Background()
+	start := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, row := range legacyTransactionRows {
+		_, err := pool.Exec(ctx, "INSERT INTO transactions (client_id, revision, amount, type, description, created_at) VALUES (1, $1, $2, $3, $4, $5)",
+			row.Revision, row.Amount, string(row.Type), row.Description, start.Add(time.Duration(row.Revision)*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := pool.Exec(ctx, "INSERT INTO snapshots (client_id, revision, balance, created_at) VALUES (1, 2, 300, $1)", start); err != nil {
+		t.Fatal(err)
+	}
+
+	testLegacyRows(t, NewPostgresTransactionStore(pool))
+}
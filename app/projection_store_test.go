@@ -0,0 +1,95 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testProjectionStore runs the ProjectionStore contract against a store with
+// no read models for clients 1 and 2.
+func testProjectionStore(t *testing.T, store ProjectionStore) {
+	ctx := context.Background()
+	day := time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC)
+
+	if _, err := store.GetStatement(ctx, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("statement of a client never projected: error = %v, want ErrNotFound", err)
+	}
+
+	for _, view := range []StatementView{
+		{ClientID: 1, Revision: 2, Balance: 70, CreditLimit: 1000, LastTransactions: []TransactionSummary{{Amount: 30, Type: DebitTransaction, Description: "b"}}},
+		{ClientID: 1, Revision: 1, Balance: 100, CreditLimit: 1000},
+		{ClientID: 1, Revision: 2, Balance: -1, CreditLimit: 1000},
+		{ClientID: 2, Revision: 1, Balance: 5, CreditLimit: 500},
+	} {
+		if err := store.SaveStatement(ctx, view); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	view, err := store.GetStatement(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.Revision != 2 || view.Balance != 70 || len(view.LastTransactions) != 1 || view.LastTransactions[0].Description != "b" {
+		t.Errorf("statement = %+v, want the one of revision 2", view)
+	}
+
+	for revision, transaction := range []Transaction{
+		{Amount: 100, Type: CreditTransaction, Timestamp: day},
+		{Amount: 30, Type: DebitTransaction, Timestamp: day.Add(time.Hour)},
+		{Amount: 20, Type: DebitTransaction, Timestamp: day.Add(24 * time.Hour)},
+	} {
+		transaction.ClientID = 1
+		transaction.Revision = revision + 1
+		if err := store.AddToDailyTotals(ctx, transaction); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// counted already, as is every revision up to 2 of the first day.
+	if err := store.AddToDailyTotals(ctx, Transaction{ClientID: 1, Revision: 1, Amount: 100, Type: CreditTransaction, Timestamp: day}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddToDailyTotals(ctx, Transaction{ClientID: 2, Revision: 1, Amount: 5, Type: CreditTransaction, Timestamp: day}); err != nil {
+		t.Fatal(err)
+	}
+
+	days, err := store.ListDailyTotals(ctx, 1, "2024-02-01", "2024-02-02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[{1 2024-02-01 100 30 2 2} {1 2024-02-02 0 20 1 3}]"
+	if got := fmt.Sprint(days); got != want {
+		t.Errorf("daily totals = %s, want %s", got, want)
+	}
+	if days, err := store.ListDailyTotals(ctx, 1, "2024-02-02", "2024-02-02"); err != nil || len(days) != 1 {
+		t.Errorf("daily totals of the second day = %+v, %v; want one", days, err)
+	}
+
+	if err := store.Reset(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetStatement(ctx, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("statement after a reset: error = %v, want ErrNotFound", err)
+	}
+	if days, err := store.ListDailyTotals(ctx, 1, "2024-02-01", "2024-02-02"); err != nil || len(days) != 0 {
+		t.Errorf("daily totals after a reset = %+v, %v; want none", days, err)
+	}
+
+	if view, err := store.GetStatement(ctx, 2); err != nil || view.Balance != 5 {
+		t.Errorf("statement of client 2 after resetting client 1 = %+v, %v", view, err)
+	}
+	if days, err := store.ListDailyTotals(ctx, 2, "2024-02-01", "2024-02-01"); err != nil || len(days) != 1 {
+		t.Errorf("daily totals of client 2 after resetting client 1 = %+v, %v", days, err)
+	}
+}
+
+func TestMemoryProjectionStore(t *testing.T) {
+	testProjectionStore(t, NewMemoryProjectionStore())
+}
+
+func TestMongoDBProjectionStore(t *testing.T) {
+	testProjectionStore(t, NewMongoDBProjectionStore(openTestMongo(t), MongoOptions{}))
+}
@@ -0,0 +1,282 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const DailyTotalsLayout = "2006-01-02"
+
+var (
+	projectionsApplied = expvar.NewInt("projections_applied")
+	projectionsErrors  = expvar.NewInt("projections_errors")
+)
+
+// ErrRevisionGap is returned when the transactions missed by the projector
+// are not all in the store yet. The event is applied once they are.
+var ErrRevisionGap = fmt.Errorf("revision gap")
+
+// DayOf is the day a transaction is counted in by the daily totals.
+func DayOf(t time.Time) string {
+	return t.UTC().Format(DailyTotalsLayout)
+}
+
+// Projector keeps the read models up to date from the stored transactions.
+// Events may come more than once, or not at all: applied ones are skipped and
+// missing ones are read from the transaction store.
+type Projector struct {
+	store        ProjectionStore
+	transactions TransactionStore
+	clients      ClientStore
+	mutex        sync.Mutex
+}
+
+func NewProjector(store ProjectionStore, transactions TransactionStore, clients ClientStore) *Projector {
+	return &Projector{
+		store:        store,
+		transactions: transactions,
+		clients:      clients,
+	}
+}
+
+// Run applies the events until the channel is closed or ctx is done. Every
+// catchUpInterval, unless 0, it also catches every client up with the store,
+// for the events that were dropped with none after them to fill the gap.
+func (p *Projector) Run(ctx context.Context, events <-chan TransactionEvent, catchUpInterval time.Duration) {
+	var catchUp <-chan time.Time
+	if catchUpInterval > 0 {
+		ticker := time.NewTicker(catchUpInterval)
+		defer ticker.Stop()
+		catchUp = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-catchUp:
+			if err := p.CatchUpAll(ctx); err != nil {
+				projectionsErrors.Add(1)
+				log.Printf("error catching projections up: %v\n", err)
+			}
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := p.Apply(ctx, event.Transaction); err != nil {
+				projectionsErrors.Add(1)
+				log.Printf("error projecting revision %d of client %d: %v\n", event.Revision, event.ClientID, err)
+			}
+		}
+	}
+}
+
+// Apply brings the read models of the client up to the transaction.
+func (p *Projector) Apply(ctx context.Context, transaction Transaction) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	view, err := p.statement(ctx, transaction.ClientID)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case transaction.Revision <= view.Revision:
+		return nil
+	case transaction.Revision == view.Revision+1:
+		return p.apply(ctx, &view, []Transaction{transaction})
+	}
+
+	// some events were missed, so they are read from the store along with
+	// this one.
+	var missing []Transaction
+	filter := TransactionFilter{FromRevision: view.Revision + 1}
+	err = p.transactions.StreamTransactions(ctx, transaction.ClientID, filter, func(t Transaction) error {
+		if t.Revision <= transaction.Revision {
+			missing = append(missing, t)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error reading the transactions missed: %w", err)
+	}
+
+	// a revision not stored yet would leave the view wrong for good, so
+	// nothing is applied until the range is complete.
+	for i, t := range missing {
+		if t.Revision != view.Revision+1+i {
+			return fmt.Errorf("%w: revision %d of client %d is not stored, found %d", ErrRevisionGap, view.Revision+1+i, transaction.ClientID, t.Revision)
+		}
+	}
+	if len(missing) == 0 || missing[len(missing)-1].Revision != transaction.Revision {
+		return fmt.Errorf("%w: revisions %d to %d of client %d are not all stored", ErrRevisionGap, view.Revision+1, transaction.Revision, transaction.ClientID)
+	}
+
+	return p.apply(ctx, &view, missing)
+}
+
+// CatchUp applies the stored transactions of the client the read models
+// have not seen yet, up to the first revision missing from the store.
+func (p *Projector) CatchUp(ctx context.Context, clientID int) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	view, err := p.statement(ctx, clientID)
+	if err != nil {
+		return err
+	}
+
+	var missed []Transaction
+	filter := TransactionFilter{FromRevision: view.Revision + 1}
+	err = p.transactions.StreamTransactions(ctx, clientID, filter, func(t Transaction) error {
+		if t.Revision == view.Revision+1+len(missed) {
+			missed = append(missed, t)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error reading the transactions missed: %w", err)
+	}
+
+	return p.apply(ctx, &view, missed)
+}
+
+// CatchUpAll catches up every client, going on past the ones that fail.
+func (p *Projector) CatchUpAll(ctx context.Context) error {
+	clients, err := p.clients.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, client := range clients {
+		if err := p.CatchUp(ctx, client.ID); err != nil {
+			errs = append(errs, fmt.Errorf("client %d: %w", client.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Rebuild deletes the read models of the client and projects them again from
+// every stored transaction.
+func (p *Projector) Rebuild(ctx context.Context, clientID int) (StatementView, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err := p.store.Reset(ctx, clientID); err != nil {
+		return StatementView{}, err
+	}
+
+	view, err := p.statement(ctx, clientID)
+	if err != nil {
+		return view, err
+	}
+
+	var transactions []Transaction
+	err = p.transactions.StreamTransactions(ctx, clientID, TransactionFilter{}, func(t Transaction) error {
+		transactions = append(transactions, t)
+		return nil
+	})
+	if err != nil {
+		return view, err
+	}
+
+	err = p.apply(ctx, &view, transactions)
+	return view, err
+}
+
+// RebuildAll rebuilds the read models of every client, calling fn with each
+// resulting statement.
+func (p *Projector) RebuildAll(ctx context.Context, fn func(StatementView)) error {
+	clients, err := p.clients.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, client := range clients {
+		view, err := p.Rebuild(ctx, client.ID)
+		if err != nil {
+			return fmt.Errorf("error rebuilding client %d: %w", client.ID, err)
+		}
+		fn(view)
+	}
+	return nil
+}
+
+// Statement returns the projected statement of the client, or ErrNotFound
+// when nothing was projected for it yet.
+func (p *Projector) Statement(ctx context.Context, clientID int) (*TransactionHistory, error) {
+	view, err := p.store.GetStatement(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TransactionHistory{
+		Balance: TransactionHistoryBalance{
+			CreditLimit: view.CreditLimit,
+			Total:       view.Balance,
+			Date:        time.Now(),
+		},
+		LastTransactions: view.LastTransactions,
+	}, nil
+}
+
+// statement returns the stored view of the client, or an empty one.
+func (p *Projector) statement(ctx context.Context, clientID int) (StatementView, error) {
+	view, err := p.store.GetStatement(ctx, clientID)
+	if !errors.Is(err, ErrNotFound) {
+		return view, err
+	}
+
+	client, err := p.clients.GetOne(ctx, clientID)
+	if err != nil {
+		return view, err
+	}
+
+	return StatementView{
+		ClientID:         clientID,
+		CreditLimit:      client.CreditLimit,
+		LastTransactions: []TransactionSummary{},
+	}, nil
+}
+
+// apply counts the transactions in the daily totals and saves the view with
+// them. The view is saved last, so a failure in between makes the next event
+// apply them again, which the daily totals skip.
+func (p *Projector) apply(ctx context.Context, view *StatementView, transactions []Transaction) error {
+	if len(transactions) == 0 {
+		return nil
+	}
+
+	history := TransactionHistory{LastTransactions: view.LastTransactions}
+
+	for _, t := range transactions {
+		if err := p.store.AddToDailyTotals(ctx, t); err != nil {
+			return err
+		}
+
+		if t.Type == CreditTransaction {
+			view.Balance += t.Amount
+		} else if t.Type == DebitTransaction {
+			view.Balance -= t.Amount
+		}
+		history.RegisterTransaction(t)
+		view.Revision = t.Revision
+	}
+
+	view.LastTransactions = history.LastTransactions
+	view.UpdatedAt = time.Now()
+
+	if err := p.store.SaveStatement(ctx, *view); err != nil {
+		return err
+	}
+
+	projectionsApplied.Add(int64(len(transactions)))
+	return nil
+}
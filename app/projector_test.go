@@ -0,0 +1,188 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestProjectorSkipsDuplicatesAndFillsGaps(t *testing.T) {
+	ctx := context.Background()
+
+	clients := NewMemoryClientStore(StoreFaults{})
+	clients.Add(ctx, Client{ID: 1, CreditLimit: 1000})
+
+	transactions := NewMemoryTransactionStore(StoreFaults{})
+	store := NewMemoryProjectionStore()
+	projector := NewProjector(store, transactions, clients)
+
+	day := time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC)
+	client := Client{ID: 1, CreditLimit: 1000}
+
+	var stored []Transaction
+	for i, req := range []TransactionRequest{
+		{Amount: 100, Type: CreditTransaction, Description: "a"},
+		{Amount: 30, Type: DebitTransaction, Description: "b"},
+		{Amount: 20, Type: DebitTransaction, Description: "c"},
+		{Amount: 5, Type: CreditTransaction, Description: "d"},
+	} {
+		transaction, err := client.ProcessTransactionAt(req, day.Add(time.Duration(i)*12*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := transactions.Add(ctx, transaction); err != nil {
+			t.Fatal(err)
+		}
+		stored = append(stored, transaction)
+	}
+
+	// revision 1 twice, then 4 without 2 and 3.
+	for _, transaction := range []Transaction{stored[0], stored[0], stored[3], stored[1]} {
+		if err := projector.Apply(ctx, transaction); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func() {
+		t.Helper()
+
+		history, err := projector.Statement(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if history.Balance.Total != 55 || history.Balance.CreditLimit != 1000 {
+			t.Errorf("balance = %+v, want 55 of 1000", history.Balance)
+		}
+		if len(history.LastTransactions) != 4 || history.LastTransactions[0].Description != "d" {
+			t.Errorf("unexpected last transactions %+v", history.LastTransactions)
+		}
+
+		days, err := store.ListDailyTotals(ctx, 1, "2024-02-01", "2024-02-02")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []DailyTotals{
+			{ClientID: 1, Day: "2024-02-01", Credits: 100, Debits: 30, Count: 2, Revision: 2},
+			{ClientID: 1, Day: "2024-02-02", Credits: 5, Debits: 20, Count: 2, Revision: 4},
+		}
+		if len(days) != len(want) {
+			t.Fatalf("daily totals = %+v, want %+v", days, want)
+		}
+		for i := range want {
+			if days[i] != want[i] {
+				t.Errorf("daily totals = %+v, want %+v", days[i], want[i])
+			}
+		}
+	}
+
+	check()
+
+	view, err := projector.Rebuild(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.Revision != 4 {
+		t.Errorf("rebuilt up to revision %d, want 4", view.Revision)
+	}
+	check()
+}
+
+func TestProjectorRefusesGaps(t *testing.T) {
+	ctx := context.Background()
+
+	clients := NewMemoryClientStore(StoreFaults{})
+	clients.Add(ctx, Client{ID: 1, CreditLimit: 1000})
+
+	transactions := NewMemoryTransactionStore(StoreFaults{})
+	projector := NewProjector(NewMemoryProjectionStore(), transactions, clients)
+
+	client := Client{ID: 1, CreditLimit: 1000}
+	var stored []Transaction
+	for _, description := range []string{"a", "b", "c"} {
+		transaction, err := client.ProcessTransaction(TransactionRequest{Amount: 10, Type: CreditTransaction, Description: description})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored = append(stored, transaction)
+	}
+
+	// revision 2 is not stored yet when 3 arrives.
+	transactions.Add(ctx, stored[0])
+	transactions.Add(ctx, stored[2])
+
+	if err := projector.Apply(ctx, stored[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := projector.Apply(ctx, stored[2]); !errors.Is(err, ErrRevisionGap) {
+		t.Fatalf("error = %v, want ErrRevisionGap", err)
+	}
+
+	history, err := projector.Statement(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.Balance.Total != 10 {
+		t.Errorf("balance = %d after the gap, want the 10 of revision 1", history.Balance.Total)
+	}
+
+	// once revision 2 is stored the event goes through.
+	transactions.Add(ctx, stored[1])
+	if err := projector.Apply(ctx, stored[2]); err != nil {
+		t.Fatal(err)
+	}
+	if history, _ := projector.Statement(ctx, 1); history.Balance.Total != 30 {
+		t.Errorf("balance = %d, want 30", history.Balance.Total)
+	}
+}
+
+func TestProjectorCatchesUpWithTheStore(t *testing.T) {
+	ctx := context.Background()
+
+	clients := NewMemoryClientStore(StoreFaults{})
+	clients.Add(ctx, Client{ID: 1, CreditLimit: 1000})
+
+	transactions := NewMemoryTransactionStore(StoreFaults{})
+	projector := NewProjector(NewMemoryProjectionStore(), transactions, clients)
+
+	client := Client{ID: 1, CreditLimit: 1000}
+	var stored []Transaction
+	for _, description := range []string{"a", "b", "c", "d"} {
+		transaction, err := client.ProcessTransaction(TransactionRequest{Amount: 10, Type: CreditTransaction, Description: description})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored = append(stored, transaction)
+	}
+
+	// the event of revision 2 was dropped and revision 3 is not stored yet.
+	transactions.Add(ctx, stored[0])
+	transactions.Add(ctx, stored[1])
+	transactions.Add(ctx, stored[3])
+	if err := projector.Apply(ctx, stored[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := projector.CatchUpAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if history, _ := projector.Statement(ctx, 1); history.Balance.Total != 20 {
+		t.Errorf("balance = %d after catching up, want the 20 up to revision 2, before the gap", history.Balance.Total)
+	}
+
+	transactions.Add(ctx, stored[2])
+	if err := projector.CatchUpAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if history, _ := projector.Statement(ctx, 1); history.Balance.Total != 40 || len(history.LastTransactions) != 4 {
+		t.Errorf("statement %+v once the gap is filled, want the 4 transactions", history)
+	}
+}
+
+func TestProjectorStatementNotFound(t *testing.T) {
+	projector := NewProjector(NewMemoryProjectionStore(), NewMemoryTransactionStore(StoreFaults{}), NewMemoryClientStore(StoreFaults{}))
+
+	if _, err := projector.Statement(context.Background(), 1); err != ErrNotFound {
+		t.Errorf("error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,121 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"time"
+)
+
+type ReconcileAction string
+
+const (
+	// ReconcileAlert only reports divergences.
+	ReconcileAlert ReconcileAction = "alert"
+	// ReconcileRebuild replaces the in-memory state with the one in the store.
+	ReconcileRebuild ReconcileAction = "rebuild"
+	// ReconcileRepersist retries the writes of the actor that failed.
+	ReconcileRepersist ReconcileAction = "repersist"
+)
+
+func ParseReconcileAction(s string) (ReconcileAction, error) {
+	switch action := ReconcileAction(s); action {
+	case ReconcileAlert, ReconcileRebuild, ReconcileRepersist:
+		return action, nil
+	case "":
+		return ReconcileAlert, nil
+	}
+
+	return "", fmt.Errorf("invalid reconcile action %q", s)
+}
+
+var (
+	reconcileChecks      = expvar.NewInt("reconciler_checks")
+	reconcileDivergences = expvar.NewInt("reconciler_divergences")
+	reconcileRebuilds    = expvar.NewInt("reconciler_rebuilds")
+	reconcileRepersisted = expvar.NewInt("reconciler_repersisted")
+	reconcileErrors      = expvar.NewInt("reconciler_errors")
+)
+
+type ReconciliationReport struct {
+	ClientID       int
+	Balance        int
+	Revision       int
+	StoredBalance  int
+	StoredRevision int
+	FailedWrites   int
+	Rebuilt        bool
+	Repersisted    int
+}
+
+func (r ReconciliationReport) Diverged() bool {
+	return r.Balance != r.StoredBalance || r.Revision != r.StoredRevision
+}
+
+// Reconciler periodically compares the state held by every live actor with
+// the state that can be rebuilt from the store.
+type Reconciler struct {
+	actorManager *ActorManager
+	interval     time.Duration
+	action       ReconcileAction
+}
+
+func NewReconciler(actorManager *ActorManager, interval time.Duration, action ReconcileAction) *Reconciler {
+	return &Reconciler{
+		actorManager: actorManager,
+		interval:     interval,
+		action:       action,
+	}
+}
+
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.ReconcileOnce()
+		}
+	}
+}
+
+func (r *Reconciler) ReconcileOnce() {
+	for _, actor := range r.actorManager.ActiveActors() {
+		reconcileChecks.Add(1)
+
+		result := actor.Send(ActorMessage{Type: ReconcileMessage, Payload: r.action})
+		if errors.Is(result.Error, ErrActorStopped) {
+			continue
+		}
+		if result.Error != nil {
+			reconcileErrors.Add(1)
+			log.Printf("error reconciling client %d: %v\n", actor.client.ID, result.Error)
+			continue
+		}
+
+		report := result.Data.(ReconciliationReport)
+
+		if !report.Diverged() {
+			continue
+		}
+
+		reconcileDivergences.Add(1)
+		reconcileRepersisted.Add(int64(report.Repersisted))
+
+		log.Printf("ALERT: client %d diverged from store: memory balance %d revision %d, store balance %d revision %d, %d failed writes\n",
+			report.ClientID, report.Balance, report.Revision, report.StoredBalance, report.StoredRevision, report.FailedWrites)
+
+		if report.Rebuilt {
+			reconcileRebuilds.Add(1)
+			log.Printf("client %d rebuilt from store\n", report.ClientID)
+		}
+		if report.Repersisted > 0 {
+			log.Printf("client %d: re-persisted %d of %d failed writes\n", report.ClientID, report.Repersisted, report.FailedWrites)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseReconcileAction(t *testing.T) {
+	for s, want := range map[string]ReconcileAction{"": ReconcileAlert, "alert": ReconcileAlert, "rebuild": ReconcileRebuild, "repersist": ReconcileRepersist} {
+		if action, err := ParseReconcileAction(s); err != nil || action != want {
+			t.Errorf("action %q = %q, %v; want %q", s, action, err, want)
+		}
+	}
+	if _, err := ParseReconcileAction("ignore"); err == nil {
+		t.Error("unknown action accepted")
+	}
+}
+
+// divergeTestActor has the write of a credit of 100 fail, leaving the actor
+// ahead of the store.
+func divergeTestActor(t *testing.T, transactions *MemoryTransactionStore, actor *ClientActor) {
+	t.Helper()
+
+	transactions.SetFaults(StoreFaults{Hook: func(op StoreOperation) error {
+		if op == AddTransactionOperation {
+			return ErrInjectedFault
+		}
+		return nil
+	}})
+	if result := actor.Send(credit(100)); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	transactions.SetFaults(StoreFaults{})
+}
+
+func balanceOf(actor *ClientActor) int {
+	return actor.Send(ActorMessage{Type: QueryHistoryMessage}).Data.(*TransactionHistory).Balance.Total
+}
+
+func TestReconcilerAlertsOnly(t *testing.T) {
+	transactions := NewMemoryTransactionStore(StoreFaults{})
+	actor := spawnTestActor(t, transactions)
+	divergeTestActor(t, transactions, actor)
+
+	report := actor.Send(ActorMessage{Type: ReconcileMessage, Payload: ReconcileAlert}).Data.(ReconciliationReport)
+	if !report.Diverged() || report.FailedWrites != 1 || report.Rebuilt || report.Repersisted != 0 {
+		t.Errorf("report = %+v, want a divergence of 1 failed write left alone", report)
+	}
+	if balance := balanceOf(actor); balance != 100 {
+		t.Errorf("balance = %d, want the 100 in memory", balance)
+	}
+}
+
+func TestReconcilerRepersistsFailedWrites(t *testing.T) {
+	transactions := NewMemoryTransactionStore(StoreFaults{})
+	actor := spawnTestActor(t, transactions)
+	divergeTestActor(t, transactions, actor)
+
+	report := actor.Send(ActorMessage{Type: ReconcileMessage, Payload: ReconcileRepersist}).Data.(ReconciliationReport)
+	if report.Repersisted != 1 {
+		t.Errorf("report = %+v, want the failed write re-persisted", report)
+	}
+
+	report = actor.Send(ActorMessage{Type: ReconcileMessage, Payload: ReconcileAlert}).Data.(ReconciliationReport)
+	if report.Diverged() || report.StoredBalance != 100 {
+		t.Errorf("report after the repersist = %+v, want the store at 100", report)
+	}
+}
+
+func TestReconcilerRebuildsFromTheStore(t *testing.T) {
+	ctx := context.Background()
+	clients := NewMemoryClientStore(StoreFaults{})
+	clients.Add(ctx, Client{ID: 1, CreditLimit: 1000})
+	transactions := NewMemoryTransactionStore(StoreFaults{})
+
+	m := NewActorManager(clients, transactions, NewMemoryLeaseStore(), LeaseOptions{TTL: time.Minute}, SnapshotOptions{Policy: TriggerPolicy{Trigger: SnapshotOnPassivation}}, nil)
+	defer m.Shutdown()
+
+	actor, err := m.Spawn(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another owner writes revisions 1 and 2 and snapshots the last one.
+	for revision := 1; revision <= 2; revision++ {
+		if err := transactions.Add(ctx, Transaction{ClientID: 1, Revision: revision, Amount: 300, Type: CreditTransaction, Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := transactions.SaveSnapshot(ctx, Snapshot{Version: FullStateSnapshotVersion, ClientID: 1, Revision: 2, Balance: 600, CreditLimit: 1000, CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	NewReconciler(m, time.Minute, ReconcileRebuild).ReconcileOnce()
+
+	if balance := balanceOf(actor); balance != 600 {
+		t.Errorf("balance after the rebuild = %d, want the 600 of the store", balance)
+	}
+
+	// the snapshot the state was rebuilt from is not taken again.
+	m.Passivate(1)
+	snapshots, err := transactions.ListSnapshots(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 {
+		t.Errorf("%d snapshots after the passivation, want only the one rebuilt from", len(snapshots))
+	}
+}
@@ -0,0 +1,162 @@
+package app
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SnapshotTrigger int
+
+const (
+	// SnapshotAfterTransaction is checked after every stored transaction.
+	SnapshotAfterTransaction SnapshotTrigger = iota
+	// SnapshotOnPassivation is checked when an actor is passivated.
+	SnapshotOnPassivation
+	// SnapshotOnShutdown is checked for every actor when the process stops.
+	SnapshotOnShutdown
+)
+
+// SnapshotProgress is what a policy knows about a client when deciding. It
+// is only consulted when there are transactions after the last snapshot.
+type SnapshotProgress struct {
+	Revision             int
+	LastSnapshotRevision int
+	LastSnapshotAt       time.Time
+	Now                  time.Time
+}
+
+type SnapshotPolicy interface {
+	ShouldSnapshot(trigger SnapshotTrigger, progress SnapshotProgress) bool
+}
+
+// SnapshotOptions controls when actors take snapshots and how many of them
+// are kept per client; Retain 0 keeps every snapshot.
+type SnapshotOptions struct {
+	Policy SnapshotPolicy
+	Retain int
+}
+
+// EveryEventsPolicy snapshots the revisions that are multiples of N.
+type EveryEventsPolicy struct {
+	N int
+}
+
+func (p EveryEventsPolicy) ShouldSnapshot(trigger SnapshotTrigger, progress SnapshotProgress) bool {
+	return trigger == SnapshotAfterTransaction && progress.Revision%p.N == 0
+}
+
+// EveryIntervalPolicy snapshots a transaction once Interval has passed since
+// the last snapshot.
+type EveryIntervalPolicy struct {
+	Interval time.Duration
+}
+
+func (p EveryIntervalPolicy) ShouldSnapshot(trigger SnapshotTrigger, progress SnapshotProgress) bool {
+	return trigger == SnapshotAfterTransaction && progress.Now.Sub(progress.LastSnapshotAt) >= p.Interval
+}
+
+// TriggerPolicy snapshots whenever Trigger happens.
+type TriggerPolicy struct {
+	Trigger SnapshotTrigger
+}
+
+func (p TriggerPolicy) ShouldSnapshot(trigger SnapshotTrigger, progress SnapshotProgress) bool {
+	return trigger == p.Trigger
+}
+
+// AnySnapshotPolicy snapshots when any of its policies would.
+type AnySnapshotPolicy []SnapshotPolicy
+
+func (p AnySnapshotPolicy) ShouldSnapshot(trigger SnapshotTrigger, progress SnapshotProgress) bool {
+	for _, policy := range p {
+		if policy.ShouldSnapshot(trigger, progress) {
+			return true
+		}
+	}
+	return false
+}
+
+// SnapshotSize is the number of events between snapshots when no policy is
+// configured.
+const SnapshotSize = 100
+
+// ParseSnapshotPolicy reads a comma separated list of policies, such as
+// "events:100,interval:5m,passivation,shutdown". An empty string means a
+// snapshot every SnapshotSize events.
+func ParseSnapshotPolicy(s string) (SnapshotPolicy, error) {
+	if strings.TrimSpace(s) == "" {
+		return EveryEventsPolicy{N: SnapshotSize}, nil
+	}
+
+	var policies AnySnapshotPolicy
+
+	for _, part := range strings.Split(s, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), ":")
+
+		switch name {
+		case "events":
+			n, err := strconv.Atoi(arg)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid snapshot policy %q: events needs a positive count", part)
+			}
+			policies = append(policies, EveryEventsPolicy{N: n})
+		case "interval":
+			interval, err := time.ParseDuration(arg)
+			if err != nil || interval <= 0 {
+				return nil, fmt.Errorf("invalid snapshot policy %q: interval needs a positive duration", part)
+			}
+			policies = append(policies, EveryIntervalPolicy{Interval: interval})
+		case "passivation":
+			policies = append(policies, TriggerPolicy{Trigger: SnapshotOnPassivation})
+		case "shutdown":
+			policies = append(policies, TriggerPolicy{Trigger: SnapshotOnShutdown})
+		default:
+			return nil, fmt.Errorf("invalid snapshot policy %q", part)
+		}
+	}
+
+	if len(policies) == 1 {
+		return policies[0], nil
+	}
+	return policies, nil
+}
+
+// snapshotReplay follows a policy along a log that is replayed, to take
+// snapshots at the revisions an actor applying the log would have.
+type snapshotReplay struct {
+	policy       SnapshotPolicy
+	lastRevision int
+	lastAt       time.Time
+}
+
+// after tells whether the policy snapshots right after t.
+func (r *snapshotReplay) after(t Transaction) bool {
+	return r.check(t, SnapshotAfterTransaction)
+}
+
+// atEnd tells whether the policy snapshots the state left by t, the last
+// transaction, as it would when the actor is passivated or shut down.
+func (r *snapshotReplay) atEnd(t Transaction) bool {
+	return r.check(t, SnapshotOnPassivation) || r.check(t, SnapshotOnShutdown)
+}
+
+func (r *snapshotReplay) check(t Transaction, trigger SnapshotTrigger) bool {
+	if t.Revision == r.lastRevision {
+		return false
+	}
+
+	progress := SnapshotProgress{
+		Revision:             t.Revision,
+		LastSnapshotRevision: r.lastRevision,
+		LastSnapshotAt:       r.lastAt,
+		Now:                  t.Timestamp,
+	}
+	if !r.policy.ShouldSnapshot(trigger, progress) {
+		return false
+	}
+
+	r.lastRevision, r.lastAt = t.Revision, t.Timestamp
+	return true
+}
@@ -0,0 +1,155 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSnapshotPolicy(t *testing.T) {
+	for s, want := range map[string]SnapshotPolicy{
+		"":          EveryEventsPolicy{N: SnapshotSize},
+		"events:10": EveryEventsPolicy{N: 10},
+		"events:100, interval:5m,passivation,shutdown": AnySnapshotPolicy{
+			EveryEventsPolicy{N: 100},
+			EveryIntervalPolicy{Interval: 5 * time.Minute},
+			TriggerPolicy{Trigger: SnapshotOnPassivation},
+			TriggerPolicy{Trigger: SnapshotOnShutdown},
+		},
+	} {
+		policy, err := ParseSnapshotPolicy(s)
+		if err != nil {
+			t.Errorf("policy %q: %v", s, err)
+			continue
+		}
+		if fmt.Sprint(policy) != fmt.Sprint(want) {
+			t.Errorf("policy %q = %v, want %v", s, policy, want)
+		}
+	}
+
+	for _, s := range []string{"events:0", "events:x", "interval:-1s", "interval", "hourly"} {
+		if _, err := ParseSnapshotPolicy(s); err == nil || !strings.Contains(err.Error(), "invalid snapshot policy") {
+			t.Errorf("policy %q: error = %v, want an invalid policy", s, err)
+		}
+	}
+}
+
+func TestSnapshotPolicies(t *testing.T) {
+	now := time.Now()
+	progress := func(revision int, since time.Duration) SnapshotProgress {
+		return SnapshotProgress{Revision: revision, LastSnapshotRevision: 1, LastSnapshotAt: now.Add(-since), Now: now}
+	}
+
+	for _, c := range []struct {
+		policy   SnapshotPolicy
+		trigger  SnapshotTrigger
+		progress SnapshotProgress
+		want     bool
+	}{
+		{EveryEventsPolicy{N: 5}, SnapshotAfterTransaction, progress(10, 0), true},
+		{EveryEventsPolicy{N: 5}, SnapshotAfterTransaction, progress(11, 0), false},
+		{EveryEventsPolicy{N: 5}, SnapshotOnPassivation, progress(10, 0), false},
+		{EveryIntervalPolicy{Interval: time.Minute}, SnapshotAfterTransaction, progress(2, time.Minute), true},
+		{EveryIntervalPolicy{Interval: time.Minute}, SnapshotAfterTransaction, progress(2, time.Second), false},
+		{EveryIntervalPolicy{Interval: time.Minute}, SnapshotOnShutdown, progress(2, time.Hour), false},
+		{TriggerPolicy{Trigger: SnapshotOnShutdown}, SnapshotOnShutdown, progress(2, 0), true},
+		{TriggerPolicy{Trigger: SnapshotOnShutdown}, SnapshotOnPassivation, progress(2, 0), false},
+		{AnySnapshotPolicy{EveryEventsPolicy{N: 5}, TriggerPolicy{Trigger: SnapshotOnPassivation}}, SnapshotOnPassivation, progress(2, 0), true},
+		{AnySnapshotPolicy{EveryEventsPolicy{N: 5}, TriggerPolicy{Trigger: SnapshotOnPassivation}}, SnapshotAfterTransaction, progress(2, 0), false},
+	} {
+		if got := c.policy.ShouldSnapshot(c.trigger, c.progress); got != c.want {
+			t.Errorf("%v on trigger %d at revision %d = %v, want %v", c.policy, c.trigger, c.progress.Revision, got, c.want)
+		}
+	}
+}
+
+func TestSnapshotReplay(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	replay := snapshotReplay{policy: AnySnapshotPolicy{
+		EveryIntervalPolicy{Interval: time.Hour},
+		TriggerPolicy{Trigger: SnapshotOnPassivation},
+	}, lastAt: start}
+
+	var taken []int
+	var last Transaction
+	for revision := 1; revision <= 6; revision++ {
+		last = Transaction{Revision: revision, Timestamp: start.Add(time.Duration(revision) * 25 * time.Minute)}
+		if replay.after(last) {
+			taken = append(taken, revision)
+		}
+	}
+	if replay.atEnd(last) {
+		taken = append(taken, last.Revision)
+	}
+
+	// 50 minutes in, the first hour passes at revision 3 and the next at 6,
+	// which the end does not snapshot again.
+	if fmt.Sprint(taken) != "[3 6]" {
+		t.Errorf("snapshots at %v, want [3 6]", taken)
+	}
+
+	last.Revision++
+	if !replay.atEnd(last) {
+		t.Error("no snapshot at the end after a transaction past the last one")
+	}
+}
+
+// testSnapshotRetention runs the snapshot lookup and retention contract
+// against a store with no snapshot for client 1.
+func testSnapshotRetention(t *testing.T, transactions TransactionStore) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	// the highest revision is not the last one saved.
+	for _, revision := range []int{2, 6, 4} {
+		snapshot := Snapshot{Version: FullStateSnapshotVersion, ClientID: 1, Revision: revision, Balance: revision * 10, CreditLimit: 1000, CreatedAt: now.Add(time.Duration(-revision) * time.Minute)}
+		if err := transactions.SaveSnapshot(ctx, snapshot); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshot, _, err := transactions.GetTransactionHistory(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Revision != 6 || snapshot.Balance != 60 {
+		t.Errorf("last snapshot at revision %d with balance %d, want revision 6 with 60", snapshot.Revision, snapshot.Balance)
+	}
+
+	if err := transactions.PruneSnapshots(ctx, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	snapshots, err := transactions.ListSnapshots(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept []int
+	for _, snapshot := range snapshots {
+		kept = append(kept, snapshot.Revision)
+	}
+	slices.Sort(kept)
+	if fmt.Sprint(kept) != "[4 6]" {
+		t.Errorf("snapshots kept at %v, want [4 6]", kept)
+	}
+}
+
+func TestMemorySnapshotRetention(t *testing.T) {
+	testSnapshotRetention(t, NewMemoryTransactionStore(StoreFaults{}))
+}
+
+func TestSQLiteSnapshotRetention(t *testing.T) {
+	testSnapshotRetention(t, NewSQLiteTransactionStore(openTestSQLite(t)))
+}
+
+func TestFileSnapshotRetention(t *testing.T) {
+	s, err := OpenFileTransactionStore(FileStoreOptions{Dir: t.TempDir(), Fsync: FsyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	testSnapshotRetention(t, s)
+}
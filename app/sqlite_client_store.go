@@ -0,0 +1,53 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+type sqliteClientStore struct {
+	db *sql.DB
+}
+
+func NewSQLiteClientStore(db *sql.DB) ClientStore {
+	return &sqliteClientStore{db: db}
+}
+
+func (s *sqliteClientStore) Add(ctx context.Context, client Client) error {
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO clients (client_id, credit_limit, balance) VALUES (?, ?, ?) ON CONFLICT (client_id) DO NOTHING",
+		client.ID, client.CreditLimit, client.Balance)
+	return err
+}
+
+func (s *sqliteClientStore) GetOne(ctx context.Context, clientID int) (client Client, err error) {
+	err = s.db.QueryRowContext(ctx, "SELECT client_id, credit_limit, balance FROM clients WHERE client_id = ?", clientID).
+		Scan(&client.ID, &client.CreditLimit, &client.Balance)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return client, ErrNotFound
+		}
+
+		return client, err
+	}
+	return client, nil
+}
+
+func (s *sqliteClientStore) GetAll(ctx context.Context) (clients []Client, err error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT client_id, credit_limit, balance FROM clients ORDER BY client_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c Client
+		if err := rows.Scan(&c.ID, &c.CreditLimit, &c.Balance); err != nil {
+			return nil, err
+		}
+		clients = append(clients, c)
+	}
+
+	return clients, rows.Err()
+}
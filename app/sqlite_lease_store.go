@@ -0,0 +1,67 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type sqliteLeaseStore struct {
+	db *sql.DB
+}
+
+func NewSQLiteLeaseStore(db *sql.DB) LeaseStore {
+	return &sqliteLeaseStore{db: db}
+}
+
+func (s *sqliteLeaseStore) Acquire(ctx context.Context, clientID int, owner string, ttl time.Duration) (Lease, error) {
+	now := time.Now()
+	lease := Lease{ClientID: clientID, Owner: owner, ExpiresAt: now.Add(ttl)}
+
+	err := s.db.QueryRowContext(ctx, `INSERT INTO leases (client_id, owner, token, expires_at)
+		VALUES (?1, ?2, 1, ?3)
+		ON CONFLICT (client_id) DO UPDATE
+		SET owner = excluded.owner, token = leases.token + 1, expires_at = excluded.expires_at
+		WHERE leases.owner = excluded.owner OR leases.expires_at <= ?4
+		RETURNING token`,
+		clientID, owner, lease.ExpiresAt.UnixNano(), now.UnixNano()).Scan(&lease.Token)
+	if errors.Is(err, sql.ErrNoRows) {
+		return lease, ErrLeaseHeld
+	}
+	return lease, err
+}
+
+func (s *sqliteLeaseStore) Renew(ctx context.Context, lease Lease, ttl time.Duration) (Lease, error) {
+	expiresAt := time.Now().Add(ttl)
+
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE leases SET expires_at = ? WHERE client_id = ? AND owner = ? AND token = ?",
+		expiresAt.UnixNano(), lease.ClientID, lease.Owner, lease.Token)
+	if err != nil {
+		return lease, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return lease, err
+	} else if n == 0 {
+		return lease, ErrLeaseLost
+	}
+
+	lease.ExpiresAt = expiresAt
+	return lease, nil
+}
+
+func (s *sqliteLeaseStore) Release(ctx context.Context, lease Lease) error {
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE leases SET expires_at = ? WHERE client_id = ? AND owner = ? AND token = ?",
+		time.Now().UnixNano(), lease.ClientID, lease.Owner, lease.Token)
+	return err
+}
+
+func (s *sqliteLeaseStore) Token(ctx context.Context, clientID int) (token int64, err error) {
+	err = s.db.QueryRowContext(ctx, "SELECT token FROM leases WHERE client_id = ?", clientID).Scan(&token)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return token, err
+}
@@ -0,0 +1,94 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqliteOutboxColumns leave out the id, a serial the database assigns.
+const sqliteOutboxColumns = "client_id, revision, event_type, event_version, amount, type, description, transaction_created_at, created_at"
+
+func insertSQLiteOutboxEntry(ctx context.Context, db sqliteExecer, entry OutboxEntry) error {
+	t := entry.Transaction
+	_, err := db.ExecContext(ctx, "INSERT INTO outbox ("+sqliteOutboxColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		entry.ClientID, entry.Revision, entry.EventType, entry.EventVersion,
+		t.Amount, string(t.Type), t.Description, t.Timestamp.UnixNano(), entry.CreatedAt.UnixNano())
+	return err
+}
+
+// sqliteOutboxStore deletes the entries once delivered, as nothing reads
+// them afterwards.
+type sqliteOutboxStore struct {
+	db *sql.DB
+}
+
+func NewSQLiteOutboxStore(db *sql.DB) OutboxStore {
+	return &sqliteOutboxStore{db: db}
+}
+
+func (s *sqliteOutboxStore) Pending(ctx context.Context, limit int) (entries []OutboxEntry, err error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT id, "+sqliteOutboxColumns+" FROM outbox ORDER BY client_id, revision LIMIT ?",
+		limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry OutboxEntry
+		var txType string
+		var id, timestamp, createdAt int64
+		t := &entry.Transaction
+		if err := rows.Scan(&id, &entry.ClientID, &entry.Revision, &entry.EventType, &entry.EventVersion,
+			&t.Amount, &txType, &t.Description, &timestamp, &createdAt); err != nil {
+			return nil, err
+		}
+		entry.ID = strconv.FormatInt(id, 10)
+		t.ClientID = entry.ClientID
+		t.Revision = entry.Revision
+		t.Type = TransactionType(txType)
+		t.Timestamp = time.Unix(0, timestamp)
+		entry.CreatedAt = time.Unix(0, createdAt)
+
+		if err := upcastRow(TransactionEventType, &entry.EventType, &entry.EventVersion, t); err != nil {
+			return nil, fmt.Errorf("error decoding outbox entry of revision %d of client %d: %w", entry.Revision, entry.ClientID, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+func (s *sqliteOutboxStore) MarkDelivered(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	serials, err := parseOutboxSerials(ids)
+	if err != nil {
+		return err
+	}
+
+	args := make([]any, len(serials))
+	for i, serial := range serials {
+		args[i] = serial
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	_, err = s.db.ExecContext(ctx, "DELETE FROM outbox WHERE id IN ("+placeholders+")", args...)
+	return err
+}
+
+func (s *sqliteOutboxStore) Backlog(ctx context.Context) (backlog OutboxBacklog, err error) {
+	var oldest *int64
+	err = s.db.QueryRowContext(ctx, "SELECT count(*), min(created_at) FROM outbox").Scan(&backlog.Pending, &oldest)
+	if oldest != nil {
+		backlog.Oldest = time.Unix(0, *oldest)
+	}
+	return backlog, err
+}
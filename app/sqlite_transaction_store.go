@@ -0,0 +1,279 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const (
+	sqliteTransactionColumns = "client_id, revision, amount, type, description, created_at, event_type, event_version"
+
+	sqliteSnapshotColumns = "version, client_id, revision, balance, credit_limit, history, created_at, event_type"
+	sqliteInsertSnapshot  = "INSERT INTO snapshots (" + sqliteSnapshotColumns + ") VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+)
+
+type sqliteTransactionStore struct {
+	db     *sql.DB
+	outbox bool
+}
+
+func NewSQLiteTransactionStore(db *sql.DB) TransactionStore {
+	return &sqliteTransactionStore{db: db}
+}
+
+// NewSQLiteTransactionStoreWithOutbox writes an OutboxEntry in the same
+// database transaction as each Transaction.
+func NewSQLiteTransactionStoreWithOutbox(db *sql.DB) TransactionStore {
+	return &sqliteTransactionStore{db: db, outbox: true}
+}
+
+// sqliteExecer is what Add needs of either the database or a transaction.
+type sqliteExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func (s *sqliteTransactionStore) Add(ctx context.Context, transaction Transaction) error {
+	var err error
+	if !s.outbox {
+		err = s.insert(ctx, s.db, transaction)
+	} else {
+		err = s.insertWithOutbox(ctx, transaction)
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY {
+		return &RevisionConflictError{ClientID: transaction.ClientID, Revision: transaction.Revision}
+	}
+	return err
+}
+
+func (s *sqliteTransactionStore) insert(ctx context.Context, db sqliteExecer, transaction Transaction) error {
+	result, err := db.ExecContext(ctx, `INSERT INTO transactions (`+sqliteTransactionColumns+`)
+		SELECT ?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8
+		WHERE NOT EXISTS (SELECT 1 FROM leases WHERE client_id = ?1 AND token > ?9)`,
+		transaction.ClientID, transaction.Revision, transaction.Amount, string(transaction.Type), transaction.Description, transaction.Timestamp.UnixNano(),
+		TransactionEventType, TransactionEventVersion, transaction.FencingToken)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrStaleFencingToken
+	}
+	return nil
+}
+
+func (s *sqliteTransactionStore) insertWithOutbox(ctx context.Context, transaction Transaction) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.insert(ctx, tx, transaction); err != nil {
+		return err
+	}
+	if err := insertSQLiteOutboxEntry(ctx, tx, newOutboxEntry(transaction, time.Now())); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteTransactionStore) GetTransactionHistory(ctx context.Context, clientID int) (lastSnapshot Snapshot, transactions []Transaction, err error) {
+	lastSnapshot, err = s.getLastSnapshot(ctx, clientID)
+	if err != nil {
+		return lastSnapshot, nil, err
+	}
+
+	if lastSnapshot.Revision > 0 {
+		log.Printf("loading state of client %d from snapshot revision %d:\n", clientID, lastSnapshot.Revision)
+	}
+
+	err = s.StreamTransactions(ctx, clientID, TransactionFilter{FromRevision: lastSnapshot.ReplayFrom()}, func(t Transaction) error {
+		transactions = append(transactions, t)
+		return nil
+	})
+	if err != nil {
+		return lastSnapshot, nil, err
+	}
+
+	return lastSnapshot, transactions, nil
+}
+
+func (s *sqliteTransactionStore) StreamTransactions(ctx context.Context, clientID int, filter TransactionFilter, fn func(Transaction) error) error {
+	conditions := []string{"client_id = ?"}
+	args := []any{clientID}
+
+	if filter.FromRevision > 0 {
+		conditions = append(conditions, "revision >= ?")
+		args = append(args, filter.FromRevision)
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.From.UnixNano())
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, filter.To.UnixNano())
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+sqliteTransactionColumns+" FROM transactions WHERE "+strings.Join(conditions, " AND ")+" ORDER BY revision",
+		args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t Transaction
+		var txType string
+		var createdAt int64
+		var eventType *string
+		var eventVersion *int
+		if err := rows.Scan(&t.ClientID, &t.Revision, &t.Amount, &txType, &t.Description, &createdAt, &eventType, &eventVersion); err != nil {
+			return err
+		}
+		t.Type = TransactionType(txType)
+		t.Timestamp = time.Unix(0, createdAt)
+
+		if err := upcastRow(TransactionEventType, eventType, eventVersion, &t); err != nil {
+			return fmt.Errorf("error decoding revision %d of client %d: %w", t.Revision, clientID, err)
+		}
+
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (s *sqliteTransactionStore) ListSnapshots(ctx context.Context, clientID int) (snapshots []Snapshot, err error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+sqliteSnapshotColumns+" FROM snapshots WHERE client_id = ? ORDER BY revision",
+		clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		snapshot, err := scanSQLiteSnapshot(rows)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	return snapshots, rows.Err()
+}
+
+func (s *sqliteTransactionStore) ReplaceSnapshots(ctx context.Context, clientID int, snapshots []Snapshot) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM snapshots WHERE client_id = ?", clientID); err != nil {
+		return err
+	}
+
+	for _, snapshot := range snapshots {
+		snapshot.ClientID = clientID
+		args, err := sqliteSnapshotArgs(snapshot)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, sqliteInsertSnapshot, args...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteTransactionStore) getLastSnapshot(ctx context.Context, clientID int) (Snapshot, error) {
+	row := s.db.QueryRowContext(ctx,
+		"SELECT "+sqliteSnapshotColumns+" FROM snapshots WHERE client_id = ? ORDER BY revision DESC LIMIT 1",
+		clientID)
+
+	snapshot, err := scanSQLiteSnapshot(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Snapshot{}, nil
+		}
+
+		return Snapshot{}, err
+	}
+	return snapshot, nil
+}
+
+func (s *sqliteTransactionStore) SaveSnapshot(ctx context.Context, snapshot Snapshot) error {
+	args, err := sqliteSnapshotArgs(snapshot)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, sqliteInsertSnapshot, args...)
+	return err
+}
+
+func (s *sqliteTransactionStore) PruneSnapshots(ctx context.Context, clientID int, keep int) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM snapshots WHERE client_id = ?1 AND id NOT IN (
+		SELECT id FROM snapshots WHERE client_id = ?1 ORDER BY revision DESC LIMIT ?2
+	)`, clientID, keep)
+	return err
+}
+
+func (s *sqliteTransactionStore) DeleteTransactions(ctx context.Context, clientID int, revision int) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM transactions WHERE client_id = ? AND revision <= ?", clientID, revision)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// sqliteSnapshotArgs encodes the history as JSON, which SQLite stores as
+// text.
+func sqliteSnapshotArgs(snapshot Snapshot) ([]any, error) {
+	var history []byte
+	if snapshot.History != nil {
+		var err error
+		if history, err = json.Marshal(snapshot.History); err != nil {
+			return nil, err
+		}
+	}
+
+	return []any{snapshot.Version, snapshot.ClientID, snapshot.Revision, snapshot.Balance, snapshot.CreditLimit, history, snapshot.CreatedAt.UnixNano(), SnapshotEventType}, nil
+}
+
+func scanSQLiteSnapshot(row interface{ Scan(dest ...any) error }) (snapshot Snapshot, err error) {
+	var createdAt int64
+	var history []byte
+	var eventType *string
+	if err := row.Scan(&snapshot.Version, &snapshot.ClientID, &snapshot.Revision, &snapshot.Balance, &snapshot.CreditLimit, &history, &createdAt, &eventType); err != nil {
+		return snapshot, err
+	}
+	snapshot.CreatedAt = time.Unix(0, createdAt)
+
+	if history != nil {
+		if err := json.Unmarshal(history, &snapshot.History); err != nil {
+			return snapshot, err
+		}
+	}
+
+	version := snapshot.Version
+	return snapshot, upcastRow(SnapshotEventType, eventType, &version, &snapshot)
+}
@@ -0,0 +1,36 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSQLiteTransactionStore(t *testing.T) {
+	testTransactionStore(t, NewSQLiteTransactionStore(openTestSQLite(t)))
+}
+
+func TestSQLiteFullStateSnapshots(t *testing.T) {
+	testFullStateSnapshots(t, NewSQLiteTransactionStore(openTestSQLite(t)))
+}
+
+func TestSQLiteClientStore(t *testing.T) {
+	testClientStore(t, NewSQLiteClientStore(openTestSQLite(t)))
+}
+
+func TestSQLiteReadsRowsWithoutEventTypes(t *testing.T) {
+	db := openTestSQLite(t)
+	start := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, row := range legacyTransactionRows {
+		_, err := db.Exec("INSERT INTO transactions (client_id, revision, amount, type, description, created_at) VALUES (1, ?, ?, ?, ?, ?)",
+			row.Revision, row.Amount, string(row.Type), row.Description, start.Add(time.Duration(row.Revision)*time.Minute).UnixNano())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO snapshots (client_id, revision, balance, created_at) VALUES (1, 2, 300, ?)", start.UnixNano()); err != nil {
+		t.Fatal(err)
+	}
+
+	testLegacyRows(t, NewSQLiteTransactionStore(db))
+}
@@ -0,0 +1,107 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type StoreOperation string
+
+const (
+	AddTransactionOperation        StoreOperation = "transactions.add"
+	GetTransactionHistoryOperation StoreOperation = "transactions.history"
+	StreamTransactionsOperation    StoreOperation = "transactions.stream"
+	ListSnapshotsOperation         StoreOperation = "snapshots.list"
+	ReplaceSnapshotsOperation      StoreOperation = "snapshots.replace"
+	SaveSnapshotOperation          StoreOperation = "snapshots.save"
+	PruneSnapshotsOperation        StoreOperation = "snapshots.prune"
+	DeleteTransactionsOperation    StoreOperation = "transactions.delete"
+	AddClientOperation             StoreOperation = "clients.add"
+	GetClientOperation             StoreOperation = "clients.get"
+	GetAllClientsOperation         StoreOperation = "clients.all"
+)
+
+func (op StoreOperation) isWrite() bool {
+	switch op {
+	case AddTransactionOperation, DeleteTransactionsOperation, ReplaceSnapshotsOperation, SaveSnapshotOperation, PruneSnapshotsOperation, AddClientOperation:
+		return true
+	}
+	return false
+}
+
+var (
+	ErrInjectedFault = fmt.Errorf("injected fault")
+	// ErrDropWrite can be returned by a fault hook to acknowledge a write
+	// without storing it.
+	ErrDropWrite = fmt.Errorf("dropped write")
+)
+
+// StoreFaults describes the failures injected by the in-memory stores.
+// Random faults are drawn from a generator seeded with Seed, so a run with
+// the same seed and the same sequence of operations fails the same way.
+type StoreFaults struct {
+	// Latency delays every operation.
+	Latency time.Duration
+	// ErrorRate is the fraction of operations failing with ErrInjectedFault.
+	ErrorRate float64
+	// DropRate is the fraction of writes reported as successful but lost.
+	DropRate float64
+	Seed     int64
+	// Hook runs before every operation, after the latency. Returning an
+	// error fails the operation, or silently drops it if it is ErrDropWrite.
+	Hook func(op StoreOperation) error
+}
+
+type faultInjector struct {
+	mutex  sync.Mutex
+	faults StoreFaults
+	rand   *rand.Rand
+}
+
+func newFaultInjector(faults StoreFaults) *faultInjector {
+	return &faultInjector{faults: faults, rand: rand.New(rand.NewSource(faults.Seed))}
+}
+
+func (f *faultInjector) set(faults StoreFaults) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.faults = faults
+	f.rand = rand.New(rand.NewSource(faults.Seed))
+}
+
+// before decides the fate of an operation: it fails with an error, it is
+// dropped (only writes) or it goes through.
+func (f *faultInjector) before(ctx context.Context, op StoreOperation) (drop bool, err error) {
+	f.mutex.Lock()
+	faults := f.faults
+	fail := faults.ErrorRate > 0 && f.rand.Float64() < faults.ErrorRate
+	drop = op.isWrite() && faults.DropRate > 0 && f.rand.Float64() < faults.DropRate
+	f.mutex.Unlock()
+
+	if faults.Latency > 0 {
+		select {
+		case <-time.After(faults.Latency):
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+
+	if faults.Hook != nil {
+		if err := faults.Hook(op); err != nil {
+			if err == ErrDropWrite && op.isWrite() {
+				return true, nil
+			}
+			return false, err
+		}
+	}
+
+	if fail {
+		return false, fmt.Errorf("%w: %s", ErrInjectedFault, op)
+	}
+
+	return drop, nil
+}
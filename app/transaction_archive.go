@@ -0,0 +1,385 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	ArchiveIndexFile   = "index.json"
+	ArchiveMonthLayout = "2006-01"
+)
+
+// ArchiveSegment is a file of archived transactions of a client, all from
+// the same month, in revision order, as zstd compressed JSON lines.
+type ArchiveSegment struct {
+	ClientID     int       `json:"client_id"`
+	Month        string    `json:"month"`
+	File         string    `json:"file"`
+	FromRevision int       `json:"from_revision"`
+	ToRevision   int       `json:"to_revision"`
+	From         time.Time `json:"from"`
+	To           time.Time `json:"to"`
+	Count        int       `json:"count"`
+	SHA256       string    `json:"sha256"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type archiveIndex struct {
+	Segments []ArchiveSegment `json:"segments"`
+}
+
+// TransactionArchive is a directory of archive segments and the index of
+// the revisions each of them holds. Readers pick up the segments written by
+// other processes, since the index is read again whenever it changes.
+type TransactionArchive struct {
+	dir string
+
+	mutex     sync.RWMutex
+	index     archiveIndex
+	indexTime time.Time
+}
+
+func OpenTransactionArchive(dir string) (*TransactionArchive, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	a := &TransactionArchive{dir: dir}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Lock takes the archive for writing. Only one process archives at a time.
+func (a *TransactionArchive) Lock() (io.Closer, error) {
+	return lockDir(a.dir)
+}
+
+// reload reads the index again if it changed since it was last read.
+func (a *TransactionArchive) reload() error {
+	info, err := os.Stat(filepath.Join(a.dir, ArchiveIndexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	a.mutex.RLock()
+	current := info.ModTime().Equal(a.indexTime)
+	a.mutex.RUnlock()
+	if current {
+		return nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(a.dir, ArchiveIndexFile))
+	if err != nil {
+		return err
+	}
+
+	var index archiveIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return fmt.Errorf("invalid archive index: %w", err)
+	}
+
+	a.mutex.Lock()
+	a.index = index
+	a.indexTime = info.ModTime()
+	a.mutex.Unlock()
+	return nil
+}
+
+// Segments returns the segments of the client in revision order.
+func (a *TransactionArchive) Segments(clientID int) ([]ArchiveSegment, error) {
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	var segments []ArchiveSegment
+	for _, segment := range a.index.Segments {
+		if segment.ClientID == clientID {
+			segments = append(segments, segment)
+		}
+	}
+
+	sort.Slice(segments, func(i, j int) bool { return segments[i].FromRevision < segments[j].FromRevision })
+	return segments, nil
+}
+
+// ArchivedThrough is the last archived revision of the client, or 0.
+func (a *TransactionArchive) ArchivedThrough(clientID int) (int, error) {
+	segments, err := a.Segments(clientID)
+	if err != nil || len(segments) == 0 {
+		return 0, err
+	}
+	return segments[len(segments)-1].ToRevision, nil
+}
+
+// Stream calls fn for every archived transaction of the client matching the
+// filter, in revision order, checking the checksum of each segment before
+// reading it. It returns the last archived revision of the client.
+func (a *TransactionArchive) Stream(ctx context.Context, clientID int, filter TransactionFilter, fn func(Transaction) error) (int, error) {
+	segments, err := a.Segments(clientID)
+	if err != nil || len(segments) == 0 {
+		return 0, err
+	}
+
+	for _, segment := range segments {
+		if segment.ToRevision < filter.FromRevision {
+			continue
+		}
+		if !filter.From.IsZero() && segment.To.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !segment.From.Before(filter.To) {
+			continue
+		}
+
+		err := a.readSegment(ctx, segment, func(t Transaction) error {
+			if t.Revision < filter.FromRevision {
+				return nil
+			}
+			if !filter.From.IsZero() && t.Timestamp.Before(filter.From) {
+				return nil
+			}
+			if !filter.To.IsZero() && !t.Timestamp.Before(filter.To) {
+				return nil
+			}
+			return fn(t)
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return segments[len(segments)-1].ToRevision, nil
+}
+
+// Verify checks the checksum of the segment and that it holds the revisions
+// the index says.
+func (a *TransactionArchive) Verify(ctx context.Context, segment ArchiveSegment) error {
+	count := 0
+	expected := segment.FromRevision
+
+	err := a.readSegment(ctx, segment, func(t Transaction) error {
+		if t.Revision != expected {
+			return fmt.Errorf("found revision %d where %d was expected", t.Revision, expected)
+		}
+		expected++
+		count++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if count != segment.Count {
+		return fmt.Errorf("found %d transactions in %s, the index says %d", count, segment.File, segment.Count)
+	}
+	return nil
+}
+
+func (a *TransactionArchive) readSegment(ctx context.Context, segment ArchiveSegment, fn func(Transaction) error) error {
+	path := filepath.Join(a.dir, segment.File)
+
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+	if sum != segment.SHA256 {
+		return fmt.Errorf("archive segment %s is corrupt: checksum %s, the index says %s", segment.File, sum, segment.SHA256)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder, err := zstd.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer decoder.Close()
+
+	scanner := bufio.NewScanner(decoder)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		t, err := decodeArchivedTransaction(scanner.Bytes())
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", segment.File, err)
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// decodeArchivedTransaction decodes a line of a segment, upcasting it to the
+// current version. Segments written before versioning hold plain
+// transactions.
+func decodeArchivedTransaction(line []byte) (Transaction, error) {
+	var event TransactionEvent
+	if err := json.Unmarshal(line, &event); err != nil {
+		return Transaction{}, err
+	}
+
+	err := eventUpcasters.UpcastRecord(TransactionEventType, event.EventType, event.EventVersion, &event.Transaction)
+	return event.Transaction, err
+}
+
+// write stores the transactions, all of the same client and month and in
+// revision order, in a new segment. The segment is only listed in the index
+// by add.
+func (a *TransactionArchive) write(clientID int, month string, transactions []Transaction) (ArchiveSegment, error) {
+	first, last := transactions[0], transactions[len(transactions)-1]
+
+	segment := ArchiveSegment{
+		ClientID:     clientID,
+		Month:        month,
+		File:         filepath.Join(fmt.Sprint(clientID), fmt.Sprintf("%s-%d-%d.jsonl.zst", month, first.Revision, last.Revision)),
+		FromRevision: first.Revision,
+		ToRevision:   last.Revision,
+		From:         first.Timestamp,
+		To:           last.Timestamp,
+		Count:        len(transactions),
+		CreatedAt:    time.Now(),
+	}
+	for _, t := range transactions {
+		if t.Timestamp.Before(segment.From) {
+			segment.From = t.Timestamp
+		}
+		if t.Timestamp.After(segment.To) {
+			segment.To = t.Timestamp
+		}
+	}
+
+	path := filepath.Join(a.dir, segment.File)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return segment, err
+	}
+
+	hash := sha256.New()
+	err := writeFileAtomically(path, func(w io.Writer) error {
+		encoder, err := zstd.NewWriter(io.MultiWriter(w, hash))
+		if err != nil {
+			return err
+		}
+
+		enc := json.NewEncoder(encoder)
+		for _, t := range transactions {
+			t.FencingToken = 0
+			if err := enc.Encode(NewTransactionEvent(t)); err != nil {
+				encoder.Close()
+				return err
+			}
+		}
+		return encoder.Close()
+	})
+	if err != nil {
+		return segment, err
+	}
+
+	segment.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return segment, nil
+}
+
+// add lists the segments in the index.
+func (a *TransactionArchive) add(segments []ArchiveSegment) error {
+	if err := a.reload(); err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	index := archiveIndex{Segments: append(append([]ArchiveSegment(nil), a.index.Segments...), segments...)}
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(a.dir, ArchiveIndexFile)
+	err = writeFileAtomically(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	a.index = index
+	a.indexTime = info.ModTime()
+	return nil
+}
+
+// writeFileAtomically writes path through a temporary file, so readers only
+// ever see it whole.
+func writeFileAtomically(path string, write func(w io.Writer) error) error {
+	tmp := path + ".tmp"
+
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -46,4 +46,7 @@ type Transaction struct {
 	Description string          `json:"descricao" bson:"description"`
 	Timestamp   time.Time       `json:"realizada_em" bson:"created_at"`
 	Revision    int             `json:"revision,omitempty" bson:"revision"`
+	// FencingToken is the lease token of the writer, checked by the store
+	// and never persisted.
+	FencingToken int64 `json:"-" bson:"-"`
 }
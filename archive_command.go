@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/feralc/rinha-backend-2024/app"
+	"github.com/feralc/rinha-backend-2024/config"
+)
+
+func runArchiveCommand(ctx context.Context, cfg config.Backend, args []string) {
+	flags := flag.NewFlagSet("archive", flag.ExitOnError)
+	clientID := flags.Int("client", 0, "id of the client to archive, defaults to every client")
+	retention := flags.Duration("retention", app.DefaultArchiveRetention, "keep transactions younger than this in the store")
+	flags.Parse(args)
+
+	stores := setupStores(ctx, cfg, false)
+	defer stores.close()
+
+	if stores.archive == nil {
+		log.Fatalf("no archive directory is configured\n")
+	}
+	if cfg.EventLog.Dir != "" {
+		log.Fatalf("the event log is compacted instead of archived\n")
+	}
+
+	lock, err := stores.archive.Lock()
+	if err != nil {
+		log.Fatalf("failed to lock archive: %v\n", err)
+	}
+	defer lock.Close()
+
+	archiver := app.NewArchiver(stores.clients, stores.transactions, stores.archive, *retention)
+
+	printReport := func(report app.ArchiveReport) {
+		for _, segment := range report.Segments {
+			log.Printf("client %d: archived revisions %d to %d of %s in %s\n", report.ClientID, segment.FromRevision, segment.ToRevision, segment.Month, segment.File)
+		}
+		log.Printf("client %d: %d transactions archived, %d deleted from the store, archived through revision %d\n",
+			report.ClientID, report.Archived, report.Deleted, report.ArchivedThrough)
+	}
+
+	now := time.Now()
+	if *clientID > 0 {
+		report, err := archiver.Archive(ctx, *clientID, now)
+		if err != nil {
+			log.Fatalf("failed to archive client %d: %v\n", *clientID, err)
+		}
+		printReport(report)
+	} else if err := archiver.ArchiveAll(ctx, now, printReport); err != nil {
+		log.Fatalf("failed to archive clients: %v\n", err)
+	}
+}
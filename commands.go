@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/feralc/rinha-backend-2024/config"
+)
+
+func runCommand(ctx context.Context, cfg config.Backend, name string, args []string) {
+	switch name {
+	case "export":
+		runExportCommand(ctx, cfg, args)
+	case "import":
+		runImportCommand(ctx, cfg, args)
+	case "verify":
+		runVerifyCommand(ctx, cfg, args)
+	case "project":
+		runProjectCommand(ctx, cfg, args)
+	case "archive":
+		runArchiveCommand(ctx, cfg, args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+		fmt.Fprintln(os.Stderr, "usage: main [config flags] [export|import|verify|project|archive] [flags]")
+		os.Exit(2)
+	}
+}
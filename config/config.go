@@ -0,0 +1,307 @@
+// Package config loads the typed configuration of the backend and of the
+// load balancer.
+//
+// A configuration is a struct whose fields are described by tags: yaml is
+// the key of the field in the configuration file, and with the keys of the
+// enclosing structs joined by dots also the name of its flag, env is the
+// environment variable that sets it, default its value when nothing else
+// does and usage the help of the flag. Fields tagged secret:"true" are
+// redacted by -print-config.
+//
+// Each source overrides the previous one: defaults, the YAML file given by
+// -config or CONFIG_FILE, environment variables and flags.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const FileEnv = "CONFIG_FILE"
+
+// Validator is a configuration that checks itself once loaded.
+type Validator interface {
+	Validate() error
+}
+
+// Load fills cfg, a pointer to a configuration struct, from every source and
+// validates it. It returns the arguments left after the flags.
+func Load(cfg Validator, name string, args []string) ([]string, error) {
+	rest, _, _, err := load(cfg, name, args)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return rest, nil
+}
+
+// MustLoad is Load for the main function of a binary: it exits when the
+// configuration is invalid, and after printing it with -print-config.
+func MustLoad(cfg Validator, name string, args []string) []string {
+	rest, print, _, err := load(cfg, name, args)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+
+	if print {
+		if err := Print(os.Stdout, cfg); err != nil {
+			log.Fatalf("%v\n", err)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("invalid configuration:\n%v\n", err)
+	}
+
+	if print {
+		os.Exit(0)
+	}
+	return rest
+}
+
+// Print writes the configuration as YAML, with its secrets redacted.
+func Print(w io.Writer, cfg any) error {
+	value := reflect.ValueOf(cfg).Elem()
+
+	redacted := reflect.New(value.Type()).Elem()
+	redacted.Set(value)
+
+	for _, f := range fieldsOf(redacted, "") {
+		if f.secret && f.value.Kind() == reflect.String {
+			f.value.SetString(redact(f.value.String()))
+		}
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(redacted.Interface()); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// load returns the arguments left after the flags, whether -print-config was
+// given and the configuration file read, if any.
+func load(cfg any, name string, args []string) (rest []string, print bool, path string, err error) {
+	fields := fieldsOf(reflect.ValueOf(cfg).Elem(), "")
+
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	file := flags.String("config", os.Getenv(FileEnv), "YAML configuration file (env "+FileEnv+")")
+	flags.BoolVar(&print, "print-config", false, "print the configuration and exit")
+
+	values := make([]*flagValue, len(fields))
+	for i, f := range fields {
+		values[i] = &flagValue{field: f}
+
+		usage := f.usage
+		if f.env != "" {
+			usage += " (env " + f.env + ")"
+		}
+		flags.Var(values[i], f.name, usage)
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, false, "", err
+	}
+
+	for _, f := range fields {
+		if f.def == "" {
+			continue
+		}
+		if err := setValue(f.value, f.def); err != nil {
+			return nil, false, "", fmt.Errorf("default of %s: %w", f.name, err)
+		}
+	}
+
+	if *file != "" {
+		if err := loadFile(cfg, *file); err != nil {
+			return nil, false, "", err
+		}
+	}
+
+	for _, f := range fields {
+		if f.env == "" {
+			continue
+		}
+		if s := os.Getenv(f.env); s != "" {
+			if err := setValue(f.value, s); err != nil {
+				return nil, false, "", fmt.Errorf("%s: %w", f.env, err)
+			}
+		}
+	}
+
+	// flags were only recorded while parsing, so they are applied last.
+	for _, v := range values {
+		if !v.set {
+			continue
+		}
+		if err := setValue(v.field.value, v.raw); err != nil {
+			return nil, false, "", fmt.Errorf("-%s: %w", v.field.name, err)
+		}
+	}
+
+	return flags.Args(), print, *file, nil
+}
+
+func loadFile(cfg any, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error reading configuration file: %w", err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(cfg); err != nil && err != io.EOF {
+		return fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+	return nil
+}
+
+type field struct {
+	name   string
+	env    string
+	def    string
+	usage  string
+	secret bool
+	value  reflect.Value
+}
+
+// fieldsOf lists the settable fields of the struct, going into nested ones.
+func fieldsOf(v reflect.Value, prefix string) []field {
+	var fields []field
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+
+		key, _, _ := strings.Cut(sf.Tag.Get("yaml"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+
+		if sf.Type.Kind() == reflect.Struct {
+			fields = append(fields, fieldsOf(v.Field(i), prefix+key+".")...)
+			continue
+		}
+
+		fields = append(fields, field{
+			name:   prefix + key,
+			env:    sf.Tag.Get("env"),
+			def:    sf.Tag.Get("default"),
+			usage:  sf.Tag.Get("usage"),
+			secret: sf.Tag.Get("secret") == "true",
+			value:  v.Field(i),
+		})
+	}
+	return fields
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setValue(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// flagValue records the flag given for a field, so it is applied after the
+// file and the environment.
+type flagValue struct {
+	field field
+	raw   string
+	set   bool
+}
+
+func (v *flagValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return v.field.def
+}
+
+func (v *flagValue) Set(s string) error {
+	// checked now so a bad flag is reported as such by the flag package.
+	if err := setValue(reflect.New(v.field.value.Type()).Elem(), s); err != nil {
+		return err
+	}
+	v.raw, v.set = s, true
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	return v.field.value.Kind() == reflect.Bool
+}
+
+// redact hides the password of a URL, or the whole value if it is not one.
+func redact(s string) string {
+	if s == "" {
+		return s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" {
+		return "xxxxx"
+	}
+	return u.Redacted()
+}
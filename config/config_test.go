@@ -0,0 +1,89 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(file, []byte("server:\n  port: 7000\nleases:\n  ttl: 3s\n  owner: file\nsqlite:\n  path: file.db\n"), 0o644)
+
+	t.Setenv(FileEnv, file)
+	t.Setenv("LEASE_TTL", "4s")
+	t.Setenv("SQLITE_PATH", "env.db")
+	t.Setenv("APP_BACKENDS", "127.0.0.1:8080=2,127.0.0.1:8081")
+
+	var cfg Backend
+	rest, err := Load(&cfg, "main", []string{"-sqlite.path", "flag.db", "verify", "-client", "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Store.Driver != "mongo" || cfg.Mongo.MaxPoolSize != 100 {
+		t.Errorf("defaults not applied: %+v %+v", cfg.Store, cfg.Mongo)
+	}
+	if cfg.Server.Port != 7000 || cfg.Leases.Owner != "file" {
+		t.Errorf("file not applied: %+v %+v", cfg.Server, cfg.Leases)
+	}
+	if cfg.Leases.TTL != 4*time.Second {
+		t.Errorf("ttl = %s, want the 4s of the environment", cfg.Leases.TTL)
+	}
+	if cfg.SQLite.Path != "flag.db" {
+		t.Errorf("sqlite path = %q, want the one of the flag", cfg.SQLite.Path)
+	}
+	if strings.Join(cfg.Import.Backends, ",") != "127.0.0.1:8080=2,127.0.0.1:8081" {
+		t.Errorf("import backends = %v, want the ones of the environment", cfg.Import.Backends)
+	}
+	if strings.Join(rest, " ") != "verify -client 1" {
+		t.Errorf("rest = %v", rest)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Setenv("APP_PORT", "abc")
+	if _, err := Load(&LoadBalancer{}, "lb", nil); err == nil || !strings.Contains(err.Error(), "APP_PORT") {
+		t.Errorf("error = %v, want one about APP_PORT", err)
+	}
+
+	t.Setenv("APP_PORT", "9999")
+	t.Setenv("APP_BACKENDS", "127.0.0.1:8080, backend")
+	_, err := Load(&LoadBalancer{}, "lb", nil)
+	if err == nil || !strings.Contains(err.Error(), `invalid address "backend"`) {
+		t.Errorf("error = %v, want one about the backend without a port", err)
+	}
+
+	t.Setenv("APP_BACKENDS", "127.0.0.1:8080=2,127.0.0.1:8081=0")
+	_, err = Load(&LoadBalancer{}, "lb", nil)
+	if err == nil || !strings.Contains(err.Error(), "invalid weight") {
+		t.Errorf("error = %v, want one about the weight", err)
+	}
+
+	t.Setenv("DISCOVERY_MODE", "dns")
+	_, err = Load(&LoadBalancer{}, "lb", nil)
+	if err == nil || !strings.Contains(err.Error(), "only listed with static discovery") || !strings.Contains(err.Error(), "discovery.dns_name") {
+		t.Errorf("error = %v, want ones about the backends listed and the missing name", err)
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	var cfg Backend
+	if _, err := Load(&cfg, "main", []string{"-postgres.url", "postgres://user:secret@db/rinha"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := Print(&out, &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(out.String(), "secret") || !strings.Contains(out.String(), "postgres://user:xxxxx@db/rinha") {
+		t.Errorf("password not redacted:\n%s", out.String())
+	}
+	if cfg.Postgres.URL != "postgres://user:secret@db/rinha" {
+		t.Errorf("printing changed the configuration")
+	}
+}
@@ -0,0 +1,164 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoadBalancer is the configuration of the HTTP load balancer.
+type LoadBalancer struct {
+	Port           int           `yaml:"port" env:"APP_PORT" default:"9999" usage:"port of the HTTP server"`
+	Backends       []string      `yaml:"backends" env:"APP_BACKENDS" usage:"comma separated addresses of the gRPC backends, each optionally followed by =weight"`
+	DrainTimeout   time.Duration `yaml:"drain_timeout" env:"DRAIN_TIMEOUT" default:"30s" usage:"time requests in flight through a removed backend are given to finish"`
+	WatchInterval  time.Duration `yaml:"watch_interval" env:"CONFIG_WATCH_INTERVAL" default:"5s" usage:"time between checks of the configuration file for changes, disabled when 0"`
+	Hash           string        `yaml:"hash" env:"RING_HASH" default:"fnv1a" usage:"hash function of the ring clients are routed by: fnv1a, crc32 or sha256"`
+	VirtualNodes   int           `yaml:"virtual_nodes" env:"RING_VIRTUAL_NODES" default:"160" usage:"points on the ring per unit of backend weight"`
+	HandoffTimeout time.Duration `yaml:"handoff_timeout" env:"HANDOFF_TIMEOUT" default:"5s" usage:"time a client is given to move from the backend that served it last to its new one, waiting for the lease of one that died"`
+
+	Timeouts     Timeouts     `yaml:"timeouts"`
+	Discovery    Discovery    `yaml:"discovery"`
+	HealthChecks HealthChecks `yaml:"health_checks"`
+	Outliers     Outliers     `yaml:"outliers"`
+}
+
+// Timeouts are the deadlines of the requests of each route, passed on to
+// the backends.
+type Timeouts struct {
+	Transaction time.Duration `yaml:"transaction" env:"TRANSACTION_TIMEOUT" default:"2s" usage:"time a transaction is given, unlimited when 0"`
+	History     time.Duration `yaml:"history" env:"HISTORY_TIMEOUT" default:"2s" usage:"time a statement is given, unlimited when 0"`
+	Export      time.Duration `yaml:"export" env:"EXPORT_TIMEOUT" default:"5m" usage:"time a ledger export is given, unlimited when 0"`
+}
+
+// Discovery is where the backends come from: the backends list, or DNS
+// records or a registry file polled for changes.
+type Discovery struct {
+	Mode     string        `yaml:"mode" env:"DISCOVERY_MODE" default:"static" usage:"source of the backends: static (the backends list), dns (A records), srv (SRV records) or file (JSON registry)"`
+	DNSName  string        `yaml:"dns_name" env:"DISCOVERY_DNS_NAME" usage:"name whose A or SRV records are the backends"`
+	DNSPort  int           `yaml:"dns_port" env:"DISCOVERY_DNS_PORT" default:"8080" usage:"port of the backends found by A records"`
+	File     string        `yaml:"file" env:"DISCOVERY_FILE" usage:"JSON registry of the backends"`
+	Interval time.Duration `yaml:"interval" env:"DISCOVERY_INTERVAL" default:"10s" usage:"time between discoveries of the backends"`
+}
+
+// HealthChecks are the active checks of the backends through the gRPC health
+// protocol.
+type HealthChecks struct {
+	Interval           time.Duration `yaml:"interval" env:"HEALTH_CHECK_INTERVAL" default:"2s" usage:"time between health checks of each backend, disabled when 0"`
+	Timeout            time.Duration `yaml:"timeout" env:"HEALTH_CHECK_TIMEOUT" default:"1s" usage:"time each health check is given"`
+	UnhealthyThreshold int           `yaml:"unhealthy_threshold" env:"HEALTH_CHECK_UNHEALTHY_THRESHOLD" default:"2" usage:"failed checks in a row that take a backend out of the ring"`
+	HealthyThreshold   int           `yaml:"healthy_threshold" env:"HEALTH_CHECK_HEALTHY_THRESHOLD" default:"2" usage:"passed checks in a row that put an unhealthy backend back"`
+}
+
+// Outliers is the passive detection of failing backends, from the results of
+// the requests routed to them.
+type Outliers struct {
+	ConsecutiveErrors int           `yaml:"consecutive_errors" env:"OUTLIER_CONSECUTIVE_ERRORS" default:"5" usage:"requests failing in a row that eject a backend, disabled when 0"`
+	EjectionTime      time.Duration `yaml:"ejection_time" env:"OUTLIER_EJECTION_TIME" default:"30s" usage:"time an ejected backend is left out of the ring"`
+}
+
+func (c *LoadBalancer) Validate() error {
+	var errs []error
+
+	if !validPort(c.Port) {
+		errs = append(errs, fmt.Errorf("port: invalid port %d", c.Port))
+	}
+
+	if c.Timeouts.Transaction < 0 || c.Timeouts.History < 0 || c.Timeouts.Export < 0 {
+		errs = append(errs, errors.New("timeouts: must not be negative"))
+	}
+
+	switch c.Discovery.Mode {
+	case "static":
+		if len(c.Backends) == 0 {
+			errs = append(errs, errors.New("backends: at least one backend is required"))
+		}
+	case "dns", "srv", "file":
+		if len(c.Backends) > 0 {
+			errs = append(errs, fmt.Errorf("backends: only listed with static discovery, not %s", c.Discovery.Mode))
+		}
+		if c.Discovery.Interval <= 0 {
+			errs = append(errs, errors.New("discovery.interval: must be positive"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("discovery.mode: unknown mode %q", c.Discovery.Mode))
+	}
+	if (c.Discovery.Mode == "dns" || c.Discovery.Mode == "srv") && c.Discovery.DNSName == "" {
+		errs = append(errs, fmt.Errorf("discovery.dns_name: required by %s discovery", c.Discovery.Mode))
+	}
+	if c.Discovery.Mode == "dns" && !validPort(c.Discovery.DNSPort) {
+		errs = append(errs, fmt.Errorf("discovery.dns_port: invalid port %d", c.Discovery.DNSPort))
+	}
+	if c.Discovery.Mode == "file" && c.Discovery.File == "" {
+		errs = append(errs, errors.New("discovery.file: required by file discovery"))
+	}
+	seen := make(map[string]bool)
+	for _, backend := range c.Backends {
+		address, _, err := ParseBackendAddress(backend)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("backends: %v", err))
+			continue
+		}
+		if seen[address] {
+			errs = append(errs, fmt.Errorf("backends: %s is listed twice", address))
+		}
+		seen[address] = true
+	}
+
+	if c.VirtualNodes <= 0 {
+		errs = append(errs, errors.New("virtual_nodes: must be positive"))
+	}
+
+	if c.HandoffTimeout <= 0 {
+		errs = append(errs, errors.New("handoff_timeout: must be positive"))
+	}
+
+	if c.DrainTimeout <= 0 {
+		errs = append(errs, errors.New("drain_timeout: must be positive"))
+	}
+	if c.WatchInterval < 0 {
+		errs = append(errs, errors.New("watch_interval: must not be negative"))
+	}
+
+	if c.HealthChecks.Interval < 0 {
+		errs = append(errs, errors.New("health_checks.interval: must not be negative"))
+	}
+	if c.HealthChecks.Interval > 0 {
+		if c.HealthChecks.Timeout <= 0 {
+			errs = append(errs, errors.New("health_checks.timeout: must be positive"))
+		}
+		if c.HealthChecks.UnhealthyThreshold <= 0 || c.HealthChecks.HealthyThreshold <= 0 {
+			errs = append(errs, errors.New("health_checks: thresholds must be positive"))
+		}
+	}
+
+	if c.Outliers.ConsecutiveErrors < 0 {
+		errs = append(errs, errors.New("outliers.consecutive_errors: must not be negative"))
+	}
+	if c.Outliers.ConsecutiveErrors > 0 && c.Outliers.EjectionTime <= 0 {
+		errs = append(errs, errors.New("outliers.ejection_time: must be positive"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// ParseBackendAddress splits a backend of the list into its address and its
+// weight, which is 1 unless given as address=weight.
+func ParseBackendAddress(s string) (address string, weight int, err error) {
+	address, w, found := strings.Cut(s, "=")
+
+	weight = 1
+	if found {
+		weight, err = strconv.Atoi(w)
+		if err != nil || weight <= 0 {
+			return "", 0, fmt.Errorf("invalid weight in %q, want a positive integer", s)
+		}
+	}
+
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return "", 0, fmt.Errorf("invalid address %q: %v", address, err)
+	}
+	return address, weight, nil
+}
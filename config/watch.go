@@ -0,0 +1,85 @@
+package config
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+)
+
+// Watch loads the configuration again from the same arguments on SIGHUP, and
+// when its file changes, checking the modification time of the file every
+// interval. Every valid configuration is sent to the returned channel as a
+// new value of the type of cfg; invalid ones are logged and skipped. The
+// channel is closed when ctx is done.
+func Watch(ctx context.Context, cfg Validator, name string, args []string, interval time.Duration) <-chan Validator {
+	configType := reflect.TypeOf(cfg).Elem()
+
+	_, _, path, _ := load(reflect.New(configType).Interface(), name, args)
+	modTime := fileModTime(path)
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	var ticker *time.Ticker
+	if path != "" && interval > 0 {
+		ticker = time.NewTicker(interval)
+		tick = ticker.C
+	}
+
+	reloads := make(chan Validator)
+	go func() {
+		defer close(reloads)
+		defer signal.Stop(hangup)
+		if ticker != nil {
+			defer ticker.Stop()
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangup:
+				modTime = fileModTime(path)
+				log.Println("reloading configuration on SIGHUP")
+			case <-tick:
+				current := fileModTime(path)
+				if current.Equal(modTime) {
+					continue
+				}
+				modTime = current
+				log.Printf("reloading configuration, %s changed\n", path)
+			}
+
+			next := reflect.New(configType).Interface().(Validator)
+			if _, err := Load(next, name, args); err != nil {
+				log.Printf("configuration not reloaded: %v\n", err)
+				continue
+			}
+
+			select {
+			case reloads <- next:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return reloads
+}
+
+func fileModTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
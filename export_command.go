@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/feralc/rinha-backend-2024/app"
+	"github.com/feralc/rinha-backend-2024/config"
+)
+
+func runExportCommand(ctx context.Context, cfg config.Backend, args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	clientID := flags.Int("client", 0, "id of the client to export")
+	format := flags.String("format", string(app.JSONLLedgerFormat), "output format: ofx or jsonl (ndjson); csv files can only be imported")
+	from := flags.String("from", "", "start of the period, YYYY-MM-DD or RFC 3339 (inclusive)")
+	to := flags.String("to", "", "end of the period, YYYY-MM-DD or RFC 3339 (inclusive for dates)")
+	output := flags.String("output", "", "file to write to, defaults to stdout")
+	flags.Parse(args)
+
+	if *clientID <= 0 {
+		log.Fatalf("a client id is required\n")
+	}
+
+	ledgerFormat, err := app.ParseLedgerFormat(*format)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+
+	start, end, err := app.ParseLedgerPeriod(*from, *to)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		out, err = os.Create(*output)
+		if err != nil {
+			log.Fatalf("failed to create output file: %v\n", err)
+		}
+		defer out.Close()
+	}
+
+	stores := setupStores(ctx, cfg, false)
+	defer stores.close()
+
+	exporter := app.NewLedgerExporter(stores.clients, stores.transactions)
+
+	w := bufio.NewWriter(out)
+	if err := exporter.Export(ctx, *clientID, ledgerFormat, start, end, w); err != nil {
+		log.Fatalf("failed to export ledger of client %d: %v\n", *clientID, err)
+	}
+
+	if err := w.Flush(); err != nil {
+		log.Fatalf("failed to write ledger: %v\n", err)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/feralc/rinha-backend-2024/app"
+	"github.com/feralc/rinha-backend-2024/config"
+	"github.com/feralc/rinha-backend-2024/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func runImportCommand(ctx context.Context, cfg config.Backend, args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	clientID := flags.Int("client", 0, "id of the client to import transactions into")
+	file := flags.String("file", "", "JSONL or CSV file with the transactions, in chronological order")
+	format := flags.String("format", "", "input format: jsonl or csv, defaults to the file extension")
+	backends := flags.String("backends", strings.Join(cfg.Import.Backends, ","), "comma separated backends checked for a live actor of the client")
+	dryRun := flags.Bool("dry-run", false, "validate the file without writing anything")
+	flags.Parse(args)
+
+	if *clientID <= 0 || *file == "" {
+		log.Fatalf("a client id and a file are required\n")
+	}
+
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(*file), ".")
+	}
+
+	ledgerFormat, err := app.ParseImportFormat(*format)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatalf("failed to open input file: %v\n", err)
+	}
+	defer f.Close()
+
+	records, err := app.ReadLedgerRecords(f, ledgerFormat)
+	if err != nil {
+		log.Fatalf("failed to read %s: %v\n", *file, err)
+	}
+
+	// a dry run writes nothing, so a live actor does not matter.
+	if !*dryRun {
+		if err := ensureNoLiveActor(ctx, *clientID, *backends); err != nil {
+			log.Fatalf("refusing to import: %v\n", err)
+		}
+	}
+
+	stores := setupStores(ctx, cfg, false)
+	defer stores.close()
+
+	importer := app.NewLedgerImporter(stores.clients, stores.transactions, stores.leases, snapshotOptions(cfg.Snapshots).Policy)
+
+	result, err := importer.Import(ctx, *clientID, records, *dryRun)
+	if err != nil {
+		log.Fatalf("failed to import ledger of client %d: %v\n", *clientID, err)
+	}
+
+	if *dryRun {
+		log.Printf("dry run: %d transactions of client %d are valid (revisions %d to %d, final balance %d)\n",
+			len(records), *clientID, result.FirstRevision, result.LastRevision, result.Balance)
+		return
+	}
+
+	log.Printf("imported %d transactions of client %d (revisions %d to %d, final balance %d)\n",
+		result.Imported, *clientID, result.FirstRevision, result.LastRevision, result.Balance)
+}
+
+// ensureNoLiveActor asks every backend whether it holds an actor for the
+// client. An actor keeps its state in memory, so importing under it would
+// leave it with a stale balance and revision. Without backends to ask it
+// fails, since nothing tells the client is free.
+func ensureNoLiveActor(ctx context.Context, clientID int, backends string) error {
+	checked := 0
+	for _, address := range strings.Split(backends, ",") {
+		if strings.TrimSpace(address) == "" {
+			continue
+		}
+
+		// the list of the load balancer may carry weights.
+		address, _, err := config.ParseBackendAddress(strings.TrimSpace(address))
+		if err != nil {
+			return err
+		}
+
+		active, err := isActorActive(ctx, address, clientID)
+		if err != nil {
+			return fmt.Errorf("could not check backend %s: %w", address, err)
+		}
+		if active {
+			return fmt.Errorf("client %d has a live actor on backend %s", clientID, address)
+		}
+		checked++
+	}
+
+	if checked == 0 {
+		return fmt.Errorf("no backends to check for a live actor of client %d, set -backends", clientID)
+	}
+	return nil
+}
+
+func isActorActive(ctx context.Context, address string, clientID int) (bool, error) {
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	status, err := proto.NewTransactionServiceClient(conn).GetActorStatus(ctx, &proto.ActorStatusRequest{
+		ClientID: int32(clientID),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return status.Active, nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/feralc/rinha-backend-2024/config"
+	"github.com/feralc/rinha-backend-2024/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// backend is the connection to one of the gRPC backends. It counts the
+// requests in flight through it, so it is only closed once they are done.
+type backend struct {
+	address string
+	conn    *grpc.ClientConn
+	client  proto.TransactionServiceClient
+	admin   proto.ActorAdminServiceClient
+
+	mutex    sync.Mutex
+	inflight int
+	retired  bool
+	drained  chan struct{}
+
+	health backendHealth
+}
+
+func dialBackend(address string) (*backend, error) {
+	b := &backend{
+		address: address,
+		drained: make(chan struct{}),
+	}
+
+	conn, err := grpc.Dial(address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithIdleTimeout(0),
+		grpc.WithChainUnaryInterceptor(b.observeUnary),
+		grpc.WithChainStreamInterceptor(b.observeStream),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	b.conn = conn
+	b.client = proto.NewTransactionServiceClient(conn)
+	b.admin = proto.NewActorAdminServiceClient(conn)
+	return b, nil
+}
+
+// acquire counts a request in flight, unless the backend was retired.
+func (b *backend) acquire() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.retired {
+		return false
+	}
+	b.inflight++
+	return true
+}
+
+func (b *backend) release() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.inflight--
+	if b.retired && b.inflight == 0 {
+		close(b.drained)
+	}
+}
+
+// retire takes no more requests and closes the connection once the ones in
+// flight are done, or after timeout.
+func (b *backend) retire(timeout time.Duration) {
+	b.mutex.Lock()
+	b.retired = true
+	if b.inflight == 0 {
+		close(b.drained)
+	}
+	b.mutex.Unlock()
+
+	select {
+	case <-b.drained:
+	case <-time.After(timeout):
+		log.Printf("closing backend %s with requests still in flight\n", b.address)
+	}
+	b.stopHealthChecks()
+	b.conn.Close()
+}
+
+type backendTable struct {
+	backends       []*backend
+	ring           *hashRing
+	handoffTimeout time.Duration
+}
+
+// backendPool routes the requests to the backends of the configuration. A
+// reload swaps the whole table at once, so every request sees either the old
+// backends or the new ones.
+type backendPool struct {
+	current atomic.Pointer[backendTable]
+
+	// updates are applied one at a time.
+	mutex sync.Mutex
+
+	owners      map[int]*clientOwner
+	ownersMutex sync.Mutex
+}
+
+// update dials the backends that were added and retires the ones that were
+// removed, keeping the connections of the others.
+func (p *backendPool) update(cfg config.LoadBalancer) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	removed := make(map[string]*backend)
+	if current := p.current.Load(); current != nil {
+		for _, b := range current.backends {
+			removed[b.address] = b
+		}
+	}
+
+	hash, err := lookupHashFunc(cfg.Hash)
+	if err != nil {
+		return err
+	}
+
+	nodes := make([]ringNode, len(cfg.Backends))
+	for i, backend := range cfg.Backends {
+		address, weight, err := config.ParseBackendAddress(backend)
+		if err != nil {
+			return err
+		}
+		nodes[i] = ringNode{name: address, weight: weight}
+	}
+
+	next := &backendTable{ring: newHashRing(hash, cfg.VirtualNodes, nodes), handoffTimeout: cfg.HandoffTimeout}
+	var added []*backend
+
+	for _, node := range nodes {
+		address := node.name
+
+		if b, ok := removed[address]; ok {
+			next.backends = append(next.backends, b)
+			delete(removed, address)
+			continue
+		}
+
+		b, err := dialBackend(address)
+		if err != nil {
+			for _, b := range added {
+				b.conn.Close()
+			}
+			return fmt.Errorf("failed to connect to gRPC backend %s: %w", address, err)
+		}
+		next.backends = append(next.backends, b)
+		added = append(added, b)
+	}
+
+	for _, b := range next.backends {
+		b.configureHealth(cfg.HealthChecks, cfg.Outliers)
+	}
+
+	p.current.Store(next)
+
+	for _, b := range added {
+		log.Printf("backend %s added\n", b.address)
+	}
+	for _, b := range removed {
+		log.Printf("backend %s removed, draining its requests\n", b.address)
+		go b.retire(cfg.DrainTimeout)
+	}
+	return nil
+}
+
+var errNoBackend = errors.New("no backend available")
+
+// route calls handle with the backend of the client, counting the request in
+// flight until handle returns, which tells whether the client was served.
+// The clients of unhealthy backends go to the next healthy one on the ring;
+// when none is healthy they are routed as usual. A client moved to another
+// backend is handed off to it first. It fails with errNoBackend when there is
+// no backend to route to, or with the error of the handoff.
+func (p *backendPool) route(ctx context.Context, clientID int, handle func(b *backend) bool) error {
+	for {
+		table := p.current.Load()
+		if len(table.backends) == 0 {
+			return errNoBackend
+		}
+
+		b := table.backends[table.ring.lookup(strconv.Itoa(clientID), func(node int) bool {
+			return table.backends[node].available()
+		})]
+
+		// a backend is only retired after the table without it is stored,
+		// so loading the table again finds its replacement.
+		if b.acquire() {
+			defer b.release()
+			return p.serve(ctx, clientID, b, table.handoffTimeout, handle)
+		}
+	}
+}
+
+// read calls handle with the backend of the client for a request that only
+// reads the store, such as an export. The client is neither handed off nor
+// recorded as served, so reads cannot move its actor between backends.
+func (p *backendPool) read(ctx context.Context, clientID int, handle func(b *backend) bool) error {
+	for {
+		table := p.current.Load()
+		if len(table.backends) == 0 {
+			return errNoBackend
+		}
+
+		b := table.backends[table.ring.lookup(strconv.Itoa(clientID), func(node int) bool {
+			return table.backends[node].available()
+		})]
+
+		if b.acquire() {
+			defer b.release()
+			handle(b)
+			return nil
+		}
+	}
+}
+
+// close retires every backend, waiting for their requests.
+func (p *backendPool) close(timeout time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, b := range p.current.Load().backends {
+		wg.Add(1)
+		go func(b *backend) {
+			defer wg.Done()
+			b.retire(timeout)
+		}(b)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/feralc/rinha-backend-2024/config"
+	"google.golang.org/grpc/connectivity"
+)
+
+func TestBackendPoolDrainsRemovedBackends(t *testing.T) {
+	pool := &backendPool{}
+
+	cfg := config.LoadBalancer{Backends: []string{"127.0.0.1:1", "127.0.0.1:2"}, DrainTimeout: time.Minute, Hash: "fnv1a", VirtualNodes: 160}
+	if err := pool.update(cfg); err != nil {
+		t.Fatal(err)
+	}
+	first := pool.current.Load().backends
+
+	// a request to the second backend stays in flight across the reload.
+	clientID := 1
+	for first[pool.current.Load().ring.lookup(strconv.Itoa(clientID), nil)] != first[1] {
+		clientID++
+	}
+
+	inflight, done := make(chan struct{}), make(chan struct{})
+	go pool.route(context.Background(), clientID, func(b *backend) bool {
+		close(inflight)
+		<-done
+		return true
+	})
+	<-inflight
+
+	cfg.Backends = []string{"127.0.0.1:1", "127.0.0.1:3"}
+	if err := pool.update(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	next := pool.current.Load().backends
+	if next[0] != first[0] {
+		t.Errorf("the connection of a kept backend was replaced")
+	}
+	if next[1].address != "127.0.0.1:3" {
+		t.Errorf("second backend = %s, want the added one", next[1].address)
+	}
+
+	var routed string
+	pool.route(context.Background(), clientID, func(b *backend) bool {
+		routed = b.address
+		return true
+	})
+	if routed == "127.0.0.1:2" {
+		t.Errorf("routed to the removed backend after the reload")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if first[1].conn.GetState() == connectivity.Shutdown {
+		t.Fatal("the removed backend was closed with a request in flight")
+	}
+
+	close(done)
+	select {
+	case <-first[1].drained:
+	case <-time.After(time.Second):
+		t.Fatal("the removed backend was not drained")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for first[1].conn.GetState() != connectivity.Shutdown {
+		if time.Now().After(deadline) {
+			t.Fatal("the removed backend was not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
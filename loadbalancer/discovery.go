@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/feralc/rinha-backend-2024/config"
+)
+
+// discoverer lists the backends to route to, as address=weight.
+type discoverer interface {
+	discover(ctx context.Context) ([]string, error)
+}
+
+// newDiscoverer returns nil for static discovery, where the backends are
+// the ones of the configuration.
+func newDiscoverer(cfg config.Discovery) discoverer {
+	switch cfg.Mode {
+	case "dns":
+		return &dnsDiscoverer{name: cfg.DNSName, port: cfg.DNSPort}
+	case "srv":
+		return &srvDiscoverer{name: cfg.DNSName}
+	case "file":
+		return &fileDiscoverer{path: cfg.File}
+	}
+	return nil
+}
+
+// dnsDiscoverer finds a backend at the port for every address of the name.
+type dnsDiscoverer struct {
+	name string
+	port int
+}
+
+func (d *dnsDiscoverer) discover(ctx context.Context) ([]string, error) {
+	addresses, err := net.DefaultResolver.LookupHost(ctx, d.name)
+	if err != nil {
+		return nil, err
+	}
+
+	backends := make([]string, len(addresses))
+	for i, address := range addresses {
+		backends[i] = net.JoinHostPort(address, strconv.Itoa(d.port))
+	}
+	return backends, nil
+}
+
+// srvDiscoverer finds the backends in the SRV records of the name, taking
+// only the ones of the lowest priority. Their weights are ignored, since the
+// ring weights are relative to the configuration of the virtual nodes.
+type srvDiscoverer struct {
+	name string
+}
+
+func (d *srvDiscoverer) discover(ctx context.Context) ([]string, error) {
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", d.name)
+	if err != nil {
+		return nil, err
+	}
+
+	var backends []string
+	for _, record := range records {
+		// the records come sorted by priority.
+		if record.Priority != records[0].Priority {
+			break
+		}
+		backends = append(backends, net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port))))
+	}
+	return backends, nil
+}
+
+// fileDiscoverer reads the backends from a JSON registry like
+//
+//	{"backends": [{"address": "10.0.0.1:8080", "weight": 2}]}
+//
+// where the weight is 1 when left out.
+type fileDiscoverer struct {
+	path string
+}
+
+type registry struct {
+	Backends []struct {
+		Address string `json:"address"`
+		Weight  int    `json:"weight"`
+	} `json:"backends"`
+}
+
+func (d *fileDiscoverer) discover(ctx context.Context) ([]string, error) {
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var r registry
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("invalid registry %s: %w", d.path, err)
+	}
+
+	backends := make([]string, len(r.Backends))
+	seen := make(map[string]bool)
+	for i, b := range r.Backends {
+		if seen[b.Address] {
+			return nil, fmt.Errorf("invalid registry %s: %s is listed twice", d.path, b.Address)
+		}
+		seen[b.Address] = true
+
+		backends[i] = b.Address
+		if b.Weight != 0 {
+			backends[i] += "=" + strconv.Itoa(b.Weight)
+		}
+		if _, _, err := config.ParseBackendAddress(backends[i]); err != nil {
+			return nil, fmt.Errorf("invalid registry %s: %w", d.path, err)
+		}
+	}
+	return backends, nil
+}
+
+// discoverBackends sends the backends found every interval, when they
+// change. A discovery that fails or finds none keeps the last ones, so a
+// DNS hiccup does not empty the ring.
+func discoverBackends(ctx context.Context, d discoverer, interval time.Duration) <-chan []string {
+	found := make(chan []string)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var last []string
+		for {
+			discoverCtx, cancel := context.WithTimeout(ctx, interval)
+			backends, err := d.discover(discoverCtx)
+			cancel()
+
+			if err == nil && len(backends) == 0 {
+				err = errors.New("no backends found")
+			}
+
+			switch {
+			case ctx.Err() != nil:
+				return
+			case err != nil:
+				log.Printf("error discovering backends: %v\n", err)
+			default:
+				slices.Sort(backends)
+				if !slices.Equal(backends, last) {
+					last = backends
+					select {
+					case found <- backends:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return found
+}
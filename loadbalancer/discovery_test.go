@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/feralc/rinha-backend-2024/config"
+)
+
+func TestFileDiscovererReadsTheRegistry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backends.json")
+	d := &fileDiscoverer{path: path}
+
+	os.WriteFile(path, []byte(`{"backends": [{"address": "10.0.0.1:8080", "weight": 2}, {"address": "10.0.0.2:8080"}]}`), 0o644)
+	backends, err := d.discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(backends) != "[10.0.0.1:8080=2 10.0.0.2:8080]" {
+		t.Errorf("backends = %v", backends)
+	}
+
+	for registry, want := range map[string]string{
+		`{"backends": [{"address": "10.0.0.1"}]}`:                                    "invalid address",
+		`{"backends": [{"address": "10.0.0.1:8080", "weight": -1}]}`:                 "invalid weight",
+		`{"backends": [{"address": "10.0.0.1:8080"}, {"address": "10.0.0.1:8080"}]}`: "listed twice",
+		`{"backends": [`: "invalid registry",
+	} {
+		os.WriteFile(path, []byte(registry), 0o644)
+		if _, err := d.discover(context.Background()); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("registry %s: error = %v, want one about %q", registry, err, want)
+		}
+	}
+}
+
+// fakeDiscoverer finds the backends it is set to.
+type fakeDiscoverer struct {
+	mutex    sync.Mutex
+	backends []string
+	err      error
+}
+
+func (d *fakeDiscoverer) set(backends []string, err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.backends, d.err = backends, err
+}
+
+func (d *fakeDiscoverer) discover(ctx context.Context) ([]string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append([]string(nil), d.backends...), d.err
+}
+
+func TestDiscoverBackendsSendsChanges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := &fakeDiscoverer{backends: []string{"10.0.0.2:8080", "10.0.0.1:8080"}}
+	found := discoverBackends(ctx, d, 10*time.Millisecond)
+
+	receive := func() []string {
+		t.Helper()
+		select {
+		case backends := <-found:
+			return backends
+		case <-time.After(time.Second):
+			t.Fatal("no backends sent")
+			return nil
+		}
+	}
+
+	if backends := receive(); fmt.Sprint(backends) != "[10.0.0.1:8080 10.0.0.2:8080]" {
+		t.Errorf("first backends = %v", backends)
+	}
+
+	// failures and empty answers keep the last backends.
+	d.set(nil, errors.New("lookup failed"))
+	time.Sleep(30 * time.Millisecond)
+	d.set(nil, nil)
+	time.Sleep(30 * time.Millisecond)
+	d.set([]string{"10.0.0.1:8080", "10.0.0.2:8080"}, nil)
+	time.Sleep(30 * time.Millisecond)
+
+	select {
+	case backends := <-found:
+		t.Fatalf("sent %v without a change", backends)
+	default:
+	}
+
+	d.set([]string{"10.0.0.1:8080", "10.0.0.3:8080"}, nil)
+	if backends := receive(); fmt.Sprint(backends) != "[10.0.0.1:8080 10.0.0.3:8080]" {
+		t.Errorf("changed backends = %v", backends)
+	}
+}
+
+func TestBackendPoolWithoutBackends(t *testing.T) {
+	pool := &backendPool{}
+	if err := pool.update(config.LoadBalancer{Hash: "fnv1a", VirtualNodes: 160}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := pool.route(context.Background(), 1, func(b *backend) bool {
+		t.Error("routed without backends")
+		return true
+	})
+	if !errors.Is(err, errNoBackend) {
+		t.Errorf("error = %v, want errNoBackend", err)
+	}
+}
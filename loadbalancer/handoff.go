@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/feralc/rinha-backend-2024/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+// clientOwner is the backend that last served a client. It is only known for
+// the clients served since the load balancer started.
+type clientOwner struct {
+	mutex   sync.Mutex
+	backend *backend
+}
+
+func (p *backendPool) owner(clientID int) *clientOwner {
+	p.ownersMutex.Lock()
+	defer p.ownersMutex.Unlock()
+
+	if p.owners == nil {
+		p.owners = make(map[int]*clientOwner)
+	}
+
+	owner, ok := p.owners[clientID]
+	if !ok {
+		owner = &clientOwner{}
+		p.owners[clientID] = owner
+	}
+	return owner
+}
+
+// forget drops the owner of a client no backend served yet, so requests for
+// clients that do not exist leave nothing behind.
+func (p *backendPool) forget(clientID int, owner *clientOwner) {
+	p.ownersMutex.Lock()
+	defer p.ownersMutex.Unlock()
+
+	if p.owners[clientID] == owner {
+		delete(p.owners, clientID)
+	}
+}
+
+// serve calls handle with b, handing the client off to b first when another
+// backend served it last. The requests of the client wait for the handoff,
+// which is given timeout within the deadline of ctx. If it fails the request
+// is refused, since the old backend may still own the client, and the next
+// one tries again. A backend only becomes the owner of a client it did not
+// get by handoff once handle reports the client served.
+func (p *backendPool) serve(ctx context.Context, clientID int, b *backend, timeout time.Duration, handle func(b *backend) bool) error {
+	owner := p.owner(clientID)
+
+	owner.mutex.Lock()
+	if owner.backend != nil && owner.backend != b {
+		handoffCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := handOff(handoffCtx, clientID, owner.backend, b)
+		cancel()
+
+		if err != nil {
+			log.Printf("error handing off client %d from %s to %s: %v\n", clientID, owner.backend.address, b.address, err)
+			owner.mutex.Unlock()
+			return fmt.Errorf("client %d is being handed off to another backend: %w", clientID, err)
+		}
+		log.Printf("client %d handed off from %s to %s\n", clientID, owner.backend.address, b.address)
+		owner.backend = b
+	}
+	owner.mutex.Unlock()
+
+	served := handle(b)
+
+	owner.mutex.Lock()
+	defer owner.mutex.Unlock()
+
+	switch {
+	case owner.backend != nil:
+	case served:
+		owner.backend = b
+	default:
+		p.forget(clientID, owner)
+	}
+	return nil
+}
+
+// hydrateBackoff is the first wait between the hydrations of a client whose
+// lease is still held, doubled up to maxHydrateBackoff.
+const (
+	hydrateBackoff    = 50 * time.Millisecond
+	maxHydrateBackoff = time.Second
+)
+
+// handOff has the old backend flush and passivate the actor of the client,
+// then the new one hydrate it from the store. A backend that is down is not
+// asked: the lease of its actor expires and the new one takes over then, so
+// the hydration is tried again until it does or ctx is done.
+func handOff(ctx context.Context, clientID int, from, to *backend) error {
+	var revision int64
+
+	if from.available() {
+		result, err := from.admin.HandOff(ctx, &proto.HandOffRequest{ClientID: int32(clientID)})
+		switch {
+		case err == nil:
+			revision = result.Revision
+		case from.conn.GetState() == connectivity.Shutdown || from.failed(err):
+			// closed after being removed, or down since the last check.
+		default:
+			return err
+		}
+	}
+
+	backoff := hydrateBackoff
+	for {
+		_, err := to.admin.Hydrate(ctx, &proto.HydrateRequest{ClientID: int32(clientID), Revision: revision})
+		// the new backend answers Unavailable while the lease is held.
+		if status.Code(err) != codes.Unavailable || to.failed(err) {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff = min(2*backoff, maxHydrateBackoff)
+	}
+}
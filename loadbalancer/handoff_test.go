@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/feralc/rinha-backend-2024/config"
+	"github.com/feralc/rinha-backend-2024/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeActorAdmin records the handoffs it is asked for.
+type fakeActorAdmin struct {
+	*proto.UnimplementedActorAdminServiceServer
+	name string
+
+	mutex *sync.Mutex
+	calls *[]string
+	// leasedUntil is when the lease of a previous owner expires, before
+	// which hydrations are refused.
+	leasedUntil time.Time
+}
+
+func (s *fakeActorAdmin) record(call string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	*s.calls = append(*s.calls, call)
+}
+
+func (s *fakeActorAdmin) HandOff(ctx context.Context, req *proto.HandOffRequest) (*proto.HandOffResult, error) {
+	s.record(fmt.Sprintf("%s handoff %d", s.name, req.ClientID))
+	return &proto.HandOffResult{Revision: 7}, nil
+}
+
+func (s *fakeActorAdmin) Hydrate(ctx context.Context, req *proto.HydrateRequest) (*proto.HydrateResult, error) {
+	s.record(fmt.Sprintf("%s hydrate %d at %d", s.name, req.ClientID, req.Revision))
+
+	s.mutex.Lock()
+	leased := time.Now().Before(s.leasedUntil)
+	s.mutex.Unlock()
+	if leased {
+		return nil, status.Error(codes.Unavailable, "lease held")
+	}
+	return &proto.HydrateResult{Revision: req.Revision}, nil
+}
+
+func TestBackendPoolHandsOffMovedClients(t *testing.T) {
+	var mutex sync.Mutex
+	var calls []string
+
+	addresses := make(map[string]string)
+	var backends []string
+	for _, name := range []string{"a", "b", "c"} {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := grpc.NewServer()
+		proto.RegisterActorAdminServiceServer(server, &fakeActorAdmin{name: name, mutex: &mutex, calls: &calls})
+		go server.Serve(lis)
+		t.Cleanup(server.Stop)
+
+		addresses[lis.Addr().String()] = name
+		backends = append(backends, lis.Addr().String())
+	}
+
+	pool := &backendPool{}
+	cfg := config.LoadBalancer{Backends: backends[:2], DrainTimeout: time.Second, HandoffTimeout: time.Second, Hash: "fnv1a", VirtualNodes: 160}
+	if err := pool.update(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer pool.close(time.Second)
+
+	routed := func(clientID int) (name string) {
+		pool.route(context.Background(), clientID, func(b *backend) bool {
+			name = addresses[b.address]
+			return true
+		})
+		return name
+	}
+
+	// the clients are first served by a or b.
+	owners := make(map[int]string)
+	for clientID := 1; clientID <= 100; clientID++ {
+		owners[clientID] = routed(clientID)
+	}
+
+	cfg.Backends = backends
+	if err := pool.update(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// one of them moves to the added backend.
+	clientID := 1
+	for pool.current.Load().backends[pool.current.Load().ring.lookup(fmt.Sprint(clientID), nil)].address != backends[2] {
+		clientID++
+	}
+
+	mutex.Lock()
+	if len(calls) > 0 {
+		t.Fatalf("clients handed off before moving: %v", calls)
+	}
+	mutex.Unlock()
+
+	if name := routed(clientID); name != "c" {
+		t.Fatalf("client %d routed to %s, want the added backend", clientID, name)
+	}
+	routed(clientID)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	want := []string{
+		fmt.Sprintf("%s handoff %d", owners[clientID], clientID),
+		fmt.Sprintf("c hydrate %d at 7", clientID),
+	}
+	if fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestBackendPoolRecordsOnlyServedClients(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	pool := &backendPool{}
+	cfg := config.LoadBalancer{Backends: []string{lis.Addr().String()}, DrainTimeout: time.Second, HandoffTimeout: time.Second, Hash: "fnv1a", VirtualNodes: 160}
+	if err := pool.update(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer pool.close(time.Second)
+
+	for clientID := 1; clientID <= 100; clientID++ {
+		pool.route(context.Background(), clientID, func(b *backend) bool { return clientID == 1 })
+	}
+
+	pool.ownersMutex.Lock()
+	defer pool.ownersMutex.Unlock()
+	if len(pool.owners) != 1 || pool.owners[1] == nil {
+		t.Errorf("owners of %d clients, want only the served client 1", len(pool.owners))
+	}
+}
+
+func TestBackendPoolRefusesClientsWhenTheHandoffFails(t *testing.T) {
+	var mutex sync.Mutex
+	var calls []string
+
+	// the old backend hands off, but the new one cannot hydrate.
+	var backends []string
+	for _, admin := range []bool{true, false} {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := grpc.NewServer()
+		if admin {
+			proto.RegisterActorAdminServiceServer(server, &fakeActorAdmin{name: "old", mutex: &mutex, calls: &calls})
+		}
+		go server.Serve(lis)
+		t.Cleanup(server.Stop)
+		backends = append(backends, lis.Addr().String())
+	}
+
+	pool := &backendPool{}
+	cfg := config.LoadBalancer{Backends: backends[:1], DrainTimeout: time.Second, HandoffTimeout: time.Second, Hash: "fnv1a", VirtualNodes: 160}
+	if err := pool.update(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer pool.close(time.Second)
+
+	if err := pool.route(context.Background(), 1, func(b *backend) bool { return true }); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Backends = backends[1:]
+	if err := pool.update(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	err := pool.route(context.Background(), 1, func(b *backend) bool {
+		t.Error("routed to the new backend without a handoff")
+		return true
+	})
+	if err == nil {
+		t.Error("routed with the handoff failing")
+	}
+}
+
+func TestBackendPoolWaitsForTheLeaseOfADeadOwner(t *testing.T) {
+	var mutex sync.Mutex
+	var calls []string
+
+	const ttl = 300 * time.Millisecond
+
+	var servers []*grpc.Server
+	var backends []string
+	admins := []*fakeActorAdmin{
+		{name: "dead", mutex: &mutex, calls: &calls},
+		{name: "next", mutex: &mutex, calls: &calls},
+	}
+	for _, admin := range admins {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := grpc.NewServer()
+		proto.RegisterActorAdminServiceServer(server, admin)
+		go server.Serve(lis)
+		t.Cleanup(server.Stop)
+
+		servers = append(servers, server)
+		backends = append(backends, lis.Addr().String())
+	}
+
+	pool := &backendPool{}
+	cfg := config.LoadBalancer{Backends: backends[:1], DrainTimeout: time.Second, HandoffTimeout: 2 * time.Second, Hash: "fnv1a", VirtualNodes: 160}
+	if err := pool.update(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer pool.close(time.Second)
+
+	if err := pool.route(context.Background(), 1, func(b *backend) bool { return true }); err != nil {
+		t.Fatal(err)
+	}
+
+	// the owner dies holding the lease of the client, which the next
+	// backend only gets once it expires.
+	servers[0].Stop()
+	mutex.Lock()
+	admins[1].leasedUntil = time.Now().Add(ttl)
+	mutex.Unlock()
+
+	cfg.Backends = backends[1:]
+	if err := pool.update(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if err := pool.route(context.Background(), 1, func(b *backend) bool { return true }); err != nil {
+		t.Fatalf("client refused after its owner died: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < ttl || elapsed > ttl+time.Second {
+		t.Errorf("client served after %s, want soon after the lease expired in %s", elapsed, ttl)
+	}
+}
+
+func TestBackendPoolReadsWithoutHandingOff(t *testing.T) {
+	var mutex sync.Mutex
+	var calls []string
+
+	var backends []string
+	for _, name := range []string{"old", "new"} {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := grpc.NewServer()
+		proto.RegisterActorAdminServiceServer(server, &fakeActorAdmin{name: name, mutex: &mutex, calls: &calls})
+		go server.Serve(lis)
+		t.Cleanup(server.Stop)
+		backends = append(backends, lis.Addr().String())
+	}
+
+	pool := &backendPool{}
+	cfg := config.LoadBalancer{Backends: backends[:1], DrainTimeout: time.Second, HandoffTimeout: time.Second, Hash: "fnv1a", VirtualNodes: 160}
+	if err := pool.update(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer pool.close(time.Second)
+
+	// a client the added backend takes over.
+	cfg.Backends = backends
+	next := &backendPool{}
+	if err := next.update(cfg); err != nil {
+		t.Fatal(err)
+	}
+	clientID := 1
+	for next.current.Load().backends[next.current.Load().ring.lookup(fmt.Sprint(clientID), nil)].address != backends[1] {
+		clientID++
+	}
+	next.close(time.Second)
+
+	if err := pool.route(context.Background(), clientID, func(b *backend) bool { return true }); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.update(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	var read string
+	if err := pool.read(context.Background(), clientID, func(b *backend) bool {
+		read = b.address
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if read != backends[1] {
+		t.Errorf("read from %s, want the backend of the client %s", read, backends[1])
+	}
+
+	mutex.Lock()
+	if len(calls) > 0 {
+		t.Errorf("a read handed the client off: %v", calls)
+	}
+	mutex.Unlock()
+
+	// the client still belongs to the old backend until a request moves it.
+	if err := pool.route(context.Background(), clientID, func(b *backend) bool { return true }); err != nil {
+		t.Fatal(err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	want := []string{fmt.Sprintf("old handoff %d", clientID), fmt.Sprintf("new hydrate %d at 7", clientID)}
+	if fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// hashFunc places the keys and the virtual nodes on the ring.
+type hashFunc func(key []byte) uint64
+
+var hashFuncs = map[string]hashFunc{
+	"fnv1a": func(key []byte) uint64 {
+		h := fnv.New64a()
+		h.Write(key)
+		return mix64(h.Sum64())
+	},
+	"crc32": func(key []byte) uint64 {
+		return mix64(uint64(crc32.ChecksumIEEE(key)))
+	},
+	"sha256": func(key []byte) uint64 {
+		sum := sha256.Sum256(key)
+		return binary.BigEndian.Uint64(sum[:8])
+	},
+}
+
+// mix64 spreads the bits of hashes that barely differ for similar keys, like
+// the names of the virtual nodes of a backend.
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+func lookupHashFunc(name string) (hashFunc, error) {
+	hash, ok := hashFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash function %q", name)
+	}
+	return hash, nil
+}
+
+type ringNode struct {
+	name   string
+	weight int
+}
+
+type ringPoint struct {
+	hash uint64
+	node int
+}
+
+// hashRing maps keys to nodes by consistent hashing. Each node is placed on
+// the ring as virtualNodes points per unit of weight, and a key belongs to
+// the node of the first point at or after its hash, so adding or removing a
+// node only moves the keys of the points next to its own.
+type hashRing struct {
+	hash   hashFunc
+	points []ringPoint
+}
+
+func newHashRing(hash hashFunc, virtualNodes int, nodes []ringNode) *hashRing {
+	r := &hashRing{hash: hash}
+
+	for i, node := range nodes {
+		for v := 0; v < virtualNodes*node.weight; v++ {
+			key := node.name + "#" + strconv.Itoa(v)
+			r.points = append(r.points, ringPoint{hash: hash([]byte(key)), node: i})
+		}
+	}
+
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i].hash < r.points[j].hash })
+	return r
+}
+
+// lookup returns the index of the node owning the key. Nodes not accepted
+// are skipped, walking the ring from the hash of the key, so their keys are
+// spread over the others exactly as if they were not on the ring. When no
+// node is accepted the owner is returned anyway.
+func (r *hashRing) lookup(key string, accept func(node int) bool) int {
+	h := r.hash([]byte(key))
+
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	for n := 0; n < len(r.points); n++ {
+		point := r.points[(start+n)%len(r.points)]
+		if accept == nil || accept(point.node) {
+			return point.node
+		}
+	}
+	return r.points[start%len(r.points)].node
+}
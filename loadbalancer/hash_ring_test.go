@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+)
+
+const ringTestClients = 100000
+
+func ringNodes(n int) []ringNode {
+	nodes := make([]ringNode, n)
+	for i := range nodes {
+		nodes[i] = ringNode{name: fmt.Sprintf("10.0.0.%d:8080", i+1), weight: 1}
+	}
+	return nodes
+}
+
+func assignments(r *hashRing, nodes []ringNode) []string {
+	owners := make([]string, ringTestClients)
+	for i := range owners {
+		owners[i] = nodes[r.lookup(strconv.Itoa(i+1), nil)].name
+	}
+	return owners
+}
+
+func TestHashRingAddingANodeMovesOnlyItsShare(t *testing.T) {
+	for name, hash := range hashFuncs {
+		t.Run(name, func(t *testing.T) {
+			for _, n := range []int{2, 4, 8} {
+				nodes := ringNodes(n + 1)
+
+				before := assignments(newHashRing(hash, 160, nodes[:n]), nodes)
+				after := assignments(newHashRing(hash, 160, nodes), nodes)
+
+				moved := 0
+				for i := range before {
+					if before[i] != after[i] {
+						moved++
+						if after[i] != nodes[n].name {
+							t.Fatalf("client %d moved from %s to %s, not to the new node", i+1, before[i], after[i])
+						}
+					}
+				}
+
+				// the new node takes about 1/(n+1) of the clients.
+				share := float64(moved) / ringTestClients
+				want := 1 / float64(n+1)
+				if math.Abs(share-want) > want*0.25 {
+					t.Errorf("adding a node to %d moved %.3f of the clients, want about %.3f", n, share, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHashRingFollowsWeights(t *testing.T) {
+	nodes := ringNodes(3)
+	nodes[0].weight = 2
+
+	counts := make(map[string]int)
+	for _, owner := range assignments(newHashRing(hashFuncs["fnv1a"], 160, nodes), nodes) {
+		counts[owner]++
+	}
+
+	for i, node := range nodes {
+		share := float64(counts[node.name]) / ringTestClients
+		want := float64(node.weight) / 4
+		if math.Abs(share-want) > want*0.2 {
+			t.Errorf("node %d got %.3f of the clients, want about %.3f", i, share, want)
+		}
+	}
+}
+
+func TestHashRingSkipsNodesAsIfRemoved(t *testing.T) {
+	nodes := ringNodes(4)
+	hash := hashFuncs["fnv1a"]
+
+	full := newHashRing(hash, 160, nodes)
+	without := assignments(newHashRing(hash, 160, nodes[:3]), nodes)
+
+	for i := range without {
+		owner := nodes[full.lookup(strconv.Itoa(i+1), func(node int) bool { return node != 3 })].name
+		if owner != without[i] {
+			t.Fatalf("client %d skipped to %s, want %s as without the node", i+1, owner, without[i])
+		}
+	}
+
+	if got := full.lookup("1", func(int) bool { return false }); got != full.lookup("1", nil) {
+		t.Errorf("with no node accepted, client 1 went to %d instead of its owner", got)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/feralc/rinha-backend-2024/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// backendHealth tells whether a backend takes requests. Active checks mark it
+// unhealthy after failing in a row, and requests failing in a row eject it
+// for a while.
+type backendHealth struct {
+	mutex    sync.Mutex
+	checks   config.HealthChecks
+	outliers config.Outliers
+	stop     context.CancelFunc
+
+	unhealthy    bool
+	failedChecks int
+	passedChecks int
+
+	errors       int
+	ejectedUntil time.Time
+}
+
+// configureHealth applies the settings of the configuration, restarting the
+// active checks when they changed.
+func (b *backend) configureHealth(checks config.HealthChecks, outliers config.Outliers) {
+	h := &b.health
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.outliers = outliers
+	if h.stop != nil && h.checks == checks {
+		return
+	}
+
+	if h.stop != nil {
+		h.stop()
+		h.stop = nil
+	}
+	h.checks = checks
+	h.unhealthy, h.failedChecks, h.passedChecks = false, 0, 0
+
+	if checks.Interval > 0 {
+		ctx, stop := context.WithCancel(context.Background())
+		h.stop = stop
+		go b.checkHealth(ctx, checks)
+	}
+}
+
+func (b *backend) stopHealthChecks() {
+	b.health.mutex.Lock()
+	defer b.health.mutex.Unlock()
+
+	if b.health.stop != nil {
+		b.health.stop()
+		b.health.stop = nil
+	}
+}
+
+// available tells whether the backend passes its health checks and is not
+// ejected.
+func (b *backend) available() bool {
+	b.health.mutex.Lock()
+	defer b.health.mutex.Unlock()
+
+	return !b.health.unhealthy && !time.Now().Before(b.health.ejectedUntil)
+}
+
+func (b *backend) checkHealth(ctx context.Context, checks config.HealthChecks) {
+	client := healthpb.NewHealthClient(b.conn)
+
+	ticker := time.NewTicker(checks.Interval)
+	defer ticker.Stop()
+
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, checks.Timeout)
+		resp, err := client.Check(checkCtx, &healthpb.HealthCheckRequest{})
+		cancel()
+
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil && resp.Status != healthpb.HealthCheckResponse_SERVING {
+			err = fmt.Errorf("status %s", resp.Status)
+		}
+		b.recordCheck(err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *backend) recordCheck(err error) {
+	h := &b.health
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if err != nil {
+		h.passedChecks = 0
+		h.failedChecks++
+		if !h.unhealthy && h.failedChecks >= h.checks.UnhealthyThreshold {
+			log.Printf("backend %s is unhealthy, routing its clients to the next ones on the ring: %v\n", b.address, err)
+			h.unhealthy = true
+		}
+		return
+	}
+
+	h.failedChecks = 0
+	h.passedChecks++
+	if h.unhealthy && h.passedChecks >= h.checks.HealthyThreshold {
+		log.Printf("backend %s is healthy again\n", b.address)
+		h.unhealthy = false
+	}
+}
+
+// recordResult counts the outcome of a request routed to the backend.
+func (b *backend) recordResult(err error) {
+	h := &b.health
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.outliers.ConsecutiveErrors <= 0 {
+		return
+	}
+	if !b.failed(err) {
+		h.errors = 0
+		return
+	}
+
+	h.errors++
+	if h.errors >= h.outliers.ConsecutiveErrors {
+		log.Printf("backend %s failed %d requests in a row, ejecting it for %s: %v\n", b.address, h.errors, h.outliers.EjectionTime, err)
+		h.ejectedUntil = time.Now().Add(h.outliers.EjectionTime)
+		h.errors = 0
+	}
+}
+
+// failed tells whether an error means the backend could not serve the
+// request. The backend itself answers Unavailable for clients leased to
+// another one or a store that is down, and requests past their deadline may
+// just have waited on a busy actor, which ejecting it would not fix, so only
+// the ones of a broken connection count.
+func (b *backend) failed(err error) bool {
+	switch status.Code(err) {
+	case codes.DeadlineExceeded, codes.Unavailable:
+		return b.conn.GetState() != connectivity.Ready
+	}
+	return false
+}
+
+func (b *backend) observeUnary(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if method != healthpb.Health_Check_FullMethodName {
+		b.recordResult(err)
+	}
+	return err
+}
+
+func (b *backend) observeStream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		b.recordResult(err)
+		return nil, err
+	}
+	return &observedStream{ClientStream: stream, backend: b}, nil
+}
+
+// observedStream counts the outcome of every message received.
+type observedStream struct {
+	grpc.ClientStream
+	backend *backend
+}
+
+func (s *observedStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != io.EOF {
+		s.backend.recordResult(err)
+	}
+	return err
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/feralc/rinha-backend-2024/config"
+	"github.com/feralc/rinha-backend-2024/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func startHealthServer(t *testing.T) (string, *health.Server) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := grpc.NewServer()
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	proto.RegisterActorAdminServiceServer(server, &fakeActorAdmin{mutex: &sync.Mutex{}, calls: &[]string{}})
+
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	return lis.Addr().String(), healthServer
+}
+
+func TestBackendPoolRoutesAroundUnhealthyBackends(t *testing.T) {
+	first, _ := startHealthServer(t)
+	second, secondHealth := startHealthServer(t)
+
+	pool := &backendPool{}
+	cfg := config.LoadBalancer{
+		Backends:       []string{first, second},
+		DrainTimeout:   time.Second,
+		HandoffTimeout: time.Second,
+		Hash:           "fnv1a",
+		VirtualNodes:   160,
+		HealthChecks:   config.HealthChecks{Interval: 10 * time.Millisecond, Timeout: time.Second, UnhealthyThreshold: 2, HealthyThreshold: 2},
+	}
+	if err := pool.update(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer pool.close(time.Second)
+
+	routed := func(clientID int) (address string) {
+		pool.route(context.Background(), clientID, func(b *backend) bool {
+			address = b.address
+			return true
+		})
+		return address
+	}
+	waitRouted := func(clientID int, want string) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for routed(clientID) != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("client %d routed to %s, want %s", clientID, routed(clientID), want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	clientID := 1
+	for routed(clientID) != second {
+		clientID++
+	}
+
+	secondHealth.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	waitRouted(clientID, first)
+
+	secondHealth.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	waitRouted(clientID, second)
+}
+
+func TestBackendEjectedAfterConsecutiveFailures(t *testing.T) {
+	b, err := dialBackend("127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.conn.Close()
+	b.configureHealth(config.HealthChecks{}, config.Outliers{ConsecutiveErrors: 3, EjectionTime: 50 * time.Millisecond})
+
+	// nothing listens on the port, so every call fails to connect.
+	for i := 0; i < 3; i++ {
+		if !b.available() {
+			t.Fatalf("ejected after %d failures, want 3", i)
+		}
+		b.client.GetActorStatus(context.Background(), &proto.ActorStatusRequest{ClientID: 1})
+	}
+	if b.available() {
+		t.Fatal("not ejected after 3 failures in a row")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !b.available() {
+		t.Error("still ejected after the ejection time")
+	}
+}
+
+func TestBackendNotEjectedForDeadlinesOfAReadyConnection(t *testing.T) {
+	address, _ := startHealthServer(t)
+	b, err := dialBackend(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.conn.Close()
+	b.configureHealth(config.HealthChecks{}, config.Outliers{ConsecutiveErrors: 3, EjectionTime: time.Minute})
+
+	if _, err := healthpb.NewHealthClient(b.conn).Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the connection is up, the requests just run out of time.
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+		b.admin.HandOff(ctx, &proto.HandOffRequest{ClientID: 1})
+		cancel()
+	}
+	if !b.available() {
+		t.Error("ejected for requests past their deadline on a ready connection")
+	}
+}
@@ -4,62 +4,140 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
-	"strings"
+	"syscall"
 	"time"
 
 	"github.com/feralc/rinha-backend-2024/app"
+	"github.com/feralc/rinha-backend-2024/config"
 	"github.com/feralc/rinha-backend-2024/proto"
-	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/status"
 )
 
-var (
-	targetBackends []proto.TransactionServiceClient
-)
-
 func main() {
-	addresses := strings.Split(os.Getenv("APP_BACKENDS"), ",")
-	port, _ := strconv.Atoi(os.Getenv("APP_PORT"))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	args := os.Args[1:]
+
+	var cfg config.LoadBalancer
+	config.MustLoad(&cfg, "load_balancer", args)
+
+	pool := &backendPool{}
+	if err := pool.update(cfg); err != nil {
+		log.Fatalf("%v\n", err)
+	}
+
+	var discovered <-chan []string
+	if d := newDiscoverer(cfg.Discovery); d != nil {
+		log.Printf("discovering backends by %s every %s\n", cfg.Discovery.Mode, cfg.Discovery.Interval)
+		discovered = discoverBackends(ctx, d, cfg.Discovery.Interval)
+	}
+
+	go watchBackends(ctx, pool, cfg, args, discovered)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/clientes/{id}/transacoes", loadBalance(pool.route, cfg.Timeouts.Transaction, handleTransaction))
+	mux.HandleFunc("/clientes/{id}/extrato", loadBalance(pool.route, cfg.Timeouts.History, handleHistory))
+	// exports read the store, so they leave the actor where it is.
+	mux.HandleFunc("/clientes/{id}/extrato/exportacao", loadBalance(pool.read, cfg.Timeouts.Export, handleExport))
+
+	server := &http.Server{Addr: fmt.Sprintf(":%d", cfg.Port), Handler: mux}
 
-	defer connectBackends(addresses)()
+	go func() {
+		<-ctx.Done()
+		log.Println("shutting down")
 
-	http.HandleFunc("/clientes/{id}/transacoes", loadBalance(handleTransaction))
-	http.HandleFunc("/clientes/{id}/extrato", loadBalance(handleHistory))
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.DrainTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
 
-	fmt.Printf("load balancer listening on port %d...\n", port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
+	fmt.Printf("load balancer listening on port %d...\n", cfg.Port)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+
+	pool.close(cfg.DrainTimeout)
 }
 
-func connectBackends(addresses []string) func() {
-	targetBackends = make([]proto.TransactionServiceClient, len(addresses))
-	conns := make([]*grpc.ClientConn, len(addresses))
+// watchBackends applies the tunables of every configuration reloaded, and
+// the backends discovered unless they are the static ones of the
+// configuration. The port, the watch interval, the discovery and the timeouts
+// only change on restart.
+func watchBackends(ctx context.Context, pool *backendPool, cfg config.LoadBalancer, args []string, discovered <-chan []string) {
+	reloads := config.Watch(ctx, &cfg, "load_balancer", args, cfg.WatchInterval)
 
-	for i, address := range addresses {
-		conn, err := grpc.Dial(address, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithIdleTimeout(0))
-		if err != nil {
-			log.Fatalf("failed to connect to gRPC backend: %v", err)
+	for {
+		select {
+		case reloaded, ok := <-reloads:
+			if !ok {
+				return
+			}
+
+			next, err := reloadedConfig(cfg, *reloaded.(*config.LoadBalancer))
+			if err != nil {
+				log.Printf("configuration not applied: %v\n", err)
+				continue
+			}
+			if err := pool.update(next); err != nil {
+				log.Printf("configuration not applied: %v\n", err)
+				continue
+			}
+			cfg = next
+			log.Printf("configuration reloaded, routing to %d backends\n", len(cfg.Backends))
+
+		case backends := <-discovered:
+			next := cfg
+			next.Backends = backends
+
+			if err := pool.update(next); err != nil {
+				log.Printf("backends discovered not applied: %v\n", err)
+				continue
+			}
+			cfg = next
+			log.Printf("backends discovered, routing to %d backends\n", len(cfg.Backends))
 		}
+	}
+}
 
-		conns[i] = conn
-		grpcClient := proto.NewTransactionServiceClient(conn)
-		targetBackends[i] = grpcClient
+// reloadedConfig keeps what only changes on restart from the current
+// configuration, and its backends when they are discovered. A reload that
+// changes the discovery mode is refused, since the backends of one mode are
+// not the ones of the other: a static configuration reloaded into DNS
+// discovery would route to none.
+func reloadedConfig(cfg, next config.LoadBalancer) (config.LoadBalancer, error) {
+	if next.Discovery.Mode != cfg.Discovery.Mode {
+		return cfg, fmt.Errorf("the discovery mode only changes on restart, from %q to %q", cfg.Discovery.Mode, next.Discovery.Mode)
+	}
+	if next.Port != cfg.Port || next.WatchInterval != cfg.WatchInterval || next.Discovery != cfg.Discovery || next.Timeouts != cfg.Timeouts {
+		log.Println("the port, the watch interval, the discovery and the timeouts only change on restart")
 	}
 
-	return func() {
-		for _, c := range conns {
-			c.Close()
-		}
+	next.Port, next.WatchInterval, next.Discovery, next.Timeouts = cfg.Port, cfg.WatchInterval, cfg.Discovery, cfg.Timeouts
+	if cfg.Discovery.Mode != "static" {
+		next.Backends = cfg.Backends
 	}
+	return next, nil
 }
 
-func loadBalance(handler func(clientID int, backend proto.TransactionServiceClient) func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+// loadBalance routes the requests to the backend of the client with route,
+// giving them timeout to complete, unless it is 0. The deadline goes along to
+// the backend, and a client that disconnects cancels its request.
+func loadBalance(route func(ctx context.Context, clientID int, handle func(b *backend) bool) error, timeout time.Duration, handler func(clientID int, backend proto.TransactionServiceClient) func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if timeout > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+
 		clientIDStr := r.PathValue("id")
 		clientID, err := strconv.Atoi(clientIDStr)
 		if err != nil {
@@ -67,10 +145,47 @@ func loadBalance(handler func(clientID int, backend proto.TransactionServiceClie
 			return
 		}
 
-		targetIndex := clientID % len(targetBackends)
-		targetBackend := targetBackends[targetIndex]
+		// only a client served successfully is known to exist, and to have
+		// its actor on the backend.
+		err = route(r.Context(), clientID, func(b *backend) bool {
+			recorder := &statusRecorder{ResponseWriter: w}
+			handler(clientID, b.client)(recorder, r)
+			return recorder.status < http.StatusMultipleChoices
+		})
+		switch {
+		case err == nil:
+		case r.Context().Err() == context.DeadlineExceeded:
+			http.Error(w, err.Error(), http.StatusGatewayTimeout)
+		default:
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		}
+	}
+}
 
-		handler(clientID, targetBackend)(w, r)
+// statusRecorder remembers the status of the response written through it,
+// which is 0 until one is.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
 	}
 }
 
@@ -99,7 +214,7 @@ func handleTransaction(clientID int, backend proto.TransactionServiceClient) fun
 			txType = proto.TransactionType_DEBIT_TRANSACTION
 		}
 
-		result, err := backend.DoTransaction(context.Background(), &proto.TransactionRequest{
+		result, err := backend.DoTransaction(r.Context(), &proto.TransactionRequest{
 			ClientID:    int32(clientID),
 			Amount:      int32(req.Amount),
 			Type:        txType,
@@ -107,11 +222,18 @@ func handleTransaction(clientID int, backend proto.TransactionServiceClient) fun
 		})
 
 		if err != nil {
-			if status.Code(err) == codes.NotFound {
+			switch status.Code(err) {
+			case codes.NotFound:
 				http.Error(w, "client not found", http.StatusNotFound)
-				return
+			case codes.Aborted:
+				http.Error(w, err.Error(), http.StatusConflict)
+			case codes.Unavailable:
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			case codes.DeadlineExceeded:
+				http.Error(w, err.Error(), http.StatusGatewayTimeout)
+			default:
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 			}
-			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 			return
 		}
 
@@ -125,17 +247,21 @@ func handleTransaction(clientID int, backend proto.TransactionServiceClient) fun
 
 func handleHistory(clientID int, backend proto.TransactionServiceClient) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		result, err := backend.GetHistory(context.Background(), &proto.HistoryRequest{
+		result, err := backend.GetHistory(r.Context(), &proto.HistoryRequest{
 			ClientID: int32(clientID),
 		})
 
 		if err != nil {
-			if status.Code(err) == codes.NotFound {
+			switch status.Code(err) {
+			case codes.NotFound:
 				http.Error(w, "client not found", http.StatusNotFound)
-				return
+			case codes.Unavailable:
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			case codes.DeadlineExceeded:
+				http.Error(w, err.Error(), http.StatusGatewayTimeout)
+			default:
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 			}
-
-			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 			return
 		}
 
@@ -161,3 +287,76 @@ func handleHistory(clientID int, backend proto.TransactionServiceClient) func(w
 		})
 	}
 }
+
+func handleExport(clientID int, backend proto.TransactionServiceClient) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		format, err := app.ParseLedgerFormat(query.Get("formato"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		from, to, err := app.ParseLedgerPeriod(query.Get("de"), query.Get("ate"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		req := &proto.ExportRequest{
+			ClientID: int32(clientID),
+			Format:   string(format),
+		}
+		if !from.IsZero() {
+			req.From = from.Unix()
+		}
+		if !to.IsZero() {
+			req.To = to.Unix()
+		}
+
+		stream, err := backend.ExportLedger(r.Context(), req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		// The first chunk carries any error raised before the export started,
+		// so it is read before committing to a successful response.
+		chunk, err := stream.Recv()
+		if err != nil && err != io.EOF {
+			switch status.Code(err) {
+			case codes.NotFound:
+				http.Error(w, "client not found", http.StatusNotFound)
+			case codes.InvalidArgument:
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			case codes.Unavailable:
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			case codes.DeadlineExceeded:
+				http.Error(w, err.Error(), http.StatusGatewayTimeout)
+			default:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"cliente-%d.%s\"", clientID, format))
+
+		flusher, _ := w.(http.Flusher)
+
+		for err == nil {
+			if _, err := w.Write(chunk.Data); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			chunk, err = stream.Recv()
+		}
+
+		if err != io.EOF {
+			log.Printf("export of client %d interrupted: %v\n", clientID, err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/feralc/rinha-backend-2024/config"
+	"github.com/feralc/rinha-backend-2024/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// slowTransactionService answers once the deadline of the request passed.
+type slowTransactionService struct {
+	*proto.UnimplementedTransactionServiceServer
+	deadlines chan time.Time
+}
+
+func (s *slowTransactionService) DoTransaction(ctx context.Context, req *proto.TransactionRequest) (*proto.TransactionResult, error) {
+	deadline, _ := ctx.Deadline()
+	s.deadlines <- deadline
+
+	<-ctx.Done()
+	return nil, status.FromContextError(ctx.Err()).Err()
+}
+
+func TestLoadBalanceTimesOutSlowBackends(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := &slowTransactionService{deadlines: make(chan time.Time, 1)}
+	server := grpc.NewServer()
+	proto.RegisterTransactionServiceServer(server, service)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	pool := &backendPool{}
+	if err := pool.update(config.LoadBalancer{Backends: []string{lis.Addr().String()}, Hash: "fnv1a", VirtualNodes: 160}); err != nil {
+		t.Fatal(err)
+	}
+	defer pool.close(time.Second)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/clientes/{id}/transacoes", loadBalance(pool.route, 100*time.Millisecond, handleTransaction))
+
+	started := time.Now()
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/clientes/1/transacoes", strings.NewReader(`{"valor": 10, "tipo": "c", "descricao": "lenta"}`)))
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want 504", w.Code)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("answered after %s, want about the 100ms of the timeout", elapsed)
+	}
+
+	deadline := <-service.deadlines
+	if deadline.IsZero() || deadline.Sub(started) > 200*time.Millisecond {
+		t.Errorf("backend deadline = %v, want the one of the route", deadline)
+	}
+}
+
+func TestReloadKeepsTheDiscovery(t *testing.T) {
+	static := config.LoadBalancer{Backends: []string{"10.0.0.1:8080"}, Discovery: config.Discovery{Mode: "static"}}
+
+	reloaded := config.LoadBalancer{Discovery: config.Discovery{Mode: "dns", DNSName: "backends", DNSPort: 8080, Interval: time.Second}}
+	if _, err := reloadedConfig(static, reloaded); err == nil {
+		t.Error("reload from static to dns discovery applied")
+	}
+
+	dns := reloaded
+	dns.Backends = []string{"10.0.0.2:8080"}
+	reloaded.VirtualNodes = 80
+	next, err := reloadedConfig(dns, reloaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(next.Backends) != "[10.0.0.2:8080]" || next.VirtualNodes != 80 {
+		t.Errorf("reloaded backends = %v, virtual nodes = %d; want the discovered backends and 80", next.Backends, next.VirtualNodes)
+	}
+
+	reloaded = static
+	reloaded.Backends = []string{"10.0.0.3:8080"}
+	if next, err := reloadedConfig(static, reloaded); err != nil || fmt.Sprint(next.Backends) != "[10.0.0.3:8080]" {
+		t.Errorf("static reload = %v, %v; want the backends reloaded", next.Backends, err)
+	}
+}
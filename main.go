@@ -2,93 +2,252 @@ package main
 
 import (
 	"context"
+	"errors"
+	"expvar"
+	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/feralc/rinha-backend-2024/app"
+	"github.com/feralc/rinha-backend-2024/config"
 	"github.com/feralc/rinha-backend-2024/proto"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 func main() {
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	mongoClient := setupMongoDB(ctx)
-	defer mongoClient.Disconnect(context.Background())
+	var cfg config.Backend
+	args := config.MustLoad(&cfg, "main", os.Args[1:])
+	if err := checkConfig(cfg); err != nil {
+		log.Fatalf("invalid configuration:\n%v\n", err)
+	}
+
+	if len(args) > 0 {
+		runCommand(ctx, cfg, args[0], args[1:])
+		return
+	}
+
+	stores := setupStores(ctx, cfg, true)
+	defer stores.close()
 
-	initializeMongoDB(ctx, mongoClient)
+	addInitialClients(ctx, stores.clients)
 
-	transactionStore := app.NewMongoDBTransactionStore(mongoClient)
-	clientsStore := app.NewMongoDBClientStore(mongoClient)
+	closeRelay := startOutboxRelay(ctx, cfg, stores)
+	defer closeRelay()
 
-	addInitialClients(ctx, clientsStore)
+	projector, projectorEvents, closeProjections := setupProjections(stores, cfg.Projections)
+	defer closeProjections()
 
-	actorManager := app.NewActorManager(clientsStore, transactionStore)
+	actorManager := app.NewActorManager(stores.clients, stores.transactions, stores.leases, leaseOptions(cfg.Leases), snapshotOptions(cfg.Snapshots), projectorEvents)
+
+	startReconciler(ctx, actorManager, cfg.Reconcile)
+	startMetricsServer(cfg.Server.MetricsPort)
 
 	grpcServer := grpc.NewServer()
 
-	proto.RegisterTransactionServiceServer(grpcServer, app.NewTransactionService(actorManager))
+	ledgerExporter := app.NewLedgerExporter(stores.clients, stores.transactions)
+
+	proto.RegisterTransactionServiceServer(grpcServer, app.NewTransactionService(actorManager, ledgerExporter, statementReads(projector, cfg.Projections)))
+	proto.RegisterActorAdminServiceServer(grpcServer, app.NewActorAdminService(actorManager))
+
+	// the load balancer checks the health of the backends to route around
+	// the ones that are down or shutting down.
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
 
-	port := os.Getenv("APP_PORT")
-	lis, err := net.Listen("tcp", ":"+port)
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.Server.Port))
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
-	log.Printf("gRPC server listening on :%s\n", port)
+
+	go func() {
+		<-ctx.Done()
+		log.Println("shutting down")
+		healthServer.Shutdown()
+		grpcServer.GracefulStop()
+	}()
+
+	log.Printf("gRPC server listening on :%d\n", cfg.Server.Port)
 	if err := grpcServer.Serve(lis); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
+
+	// passivating every actor releases their leases, so other backends can
+	// take over the clients without waiting for them to expire.
+	actorManager.Shutdown()
+}
+
+// checkConfig checks the settings config leaves to the backend, as only it
+// knows how to parse them.
+func checkConfig(cfg config.Backend) error {
+	var errs []error
+
+	switch app.SegmentLayout(cfg.EventLog.Layout) {
+	case app.GlobalSegments, app.ClientSegments:
+	default:
+		errs = append(errs, fmt.Errorf("event_log.layout: invalid layout %q, want global or client", cfg.EventLog.Layout))
+	}
+	switch app.FsyncPolicy(cfg.EventLog.Fsync) {
+	case app.FsyncAlways, app.FsyncInterval, app.FsyncNever:
+	default:
+		errs = append(errs, fmt.Errorf("event_log.fsync: invalid policy %q, want always, interval or never", cfg.EventLog.Fsync))
+	}
+
+	if _, err := app.ParseSnapshotPolicy(cfg.Snapshots.Policy); err != nil {
+		errs = append(errs, fmt.Errorf("snapshots.policy: %w", err))
+	}
+	if _, err := app.ParseReconcileAction(cfg.Reconcile.Action); err != nil {
+		errs = append(errs, fmt.Errorf("reconcile.action: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+func leaseOptions(cfg config.Leases) app.LeaseOptions {
+	return app.LeaseOptions{Owner: cfg.Owner, TTL: cfg.TTL}
 }
 
-func setupMongoDB(ctx context.Context) *mongo.Client {
+func snapshotOptions(cfg config.Snapshots) app.SnapshotOptions {
+	// already validated with the rest of the configuration.
+	policy, _ := app.ParseSnapshotPolicy(cfg.Policy)
+	return app.SnapshotOptions{Policy: policy, Retain: cfg.Retention}
+}
+
+// startOutboxRelay publishes the stored transactions to the NATS server of
+// the configuration, if any, relaying them from the outbox so each leaves at
+// least once. The returned function waits for the relay to stop.
+func startOutboxRelay(ctx context.Context, cfg config.Backend, stores storeSet) func() {
+	url := cfg.Events.NATSURL
+	if url == "" {
+		return func() {}
+	}
+
+	nats := app.NewNATSEventPublisher(url, cfg.Events.NATSSubject)
+	relay := app.NewOutboxRelay(stores.outbox, nats, stores.leases, leaseOptions(cfg.Leases), cfg.Events.OutboxInterval)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		relay.Run(ctx)
+	}()
+
+	log.Printf("relaying the outbox to %s\n", url)
+	return func() { <-done }
+}
+
+// setupProjections keeps the read models up to date when projections are
+// enabled, from the events of the actors in this process. It returns the projector
+// and the publisher the actors feed it through. Those events are best-effort,
+// the projector catching up from the store on the ones dropped.
+func setupProjections(stores storeSet, cfg config.Projections) (*app.Projector, app.EventPublisher, func()) {
+	if !cfg.Enabled {
+		return nil, nil, func() {}
+	}
+
+	projector := app.NewProjector(stores.projections, stores.transactions, stores.clients)
+
+	local := app.NewLocalEventPublisher()
+	events, unsubscribe := local.Subscribe(app.DefaultEventBuffer)
+	dispatcher := app.NewEventDispatcher(local, app.DefaultEventBuffer)
+
+	// the projector outlives the server context to apply the events still
+	// queued when it stops.
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		projector.Run(ctx, events, cfg.CatchUpInterval)
+	}()
+
+	log.Println("projecting statements and daily totals")
+	return projector, dispatcher, func() {
+		closeCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := dispatcher.Close(closeCtx); err != nil {
+			log.Printf("events left unprojected: %v\n", err)
+		}
+		unsubscribe()
+		stop()
+		<-done
+	}
+}
+
+// statementReads returns the projector when statements are read from the
+// projection, so they are served without going through the actors.
+func statementReads(projector *app.Projector, cfg config.Projections) *app.Projector {
+	if cfg.StatementReads != "projection" {
+		return nil
+	}
+	return projector
+}
+
+func startReconciler(ctx context.Context, actorManager *app.ActorManager, cfg config.Reconcile) {
+	if cfg.Interval <= 0 {
+		return
+	}
+
+	action, _ := app.ParseReconcileAction(cfg.Action)
+
+	log.Printf("reconciling actors with the store every %s (action: %s)\n", cfg.Interval, action)
+	go app.NewReconciler(actorManager, cfg.Interval, action).Run(ctx)
+}
+
+func startMetricsServer(port int) {
+	if port == 0 {
+		return
+	}
+
+	go func() {
+		log.Printf("metrics listening on :%d\n", port)
+		if err := http.ListenAndServe(fmt.Sprintf(":%d", port), expvar.Handler()); err != nil {
+			log.Printf("metrics server stopped: %v\n", err)
+		}
+	}()
+}
+
+func setupMongoDB(ctx context.Context, cfg config.Mongo) *mongo.Client {
 	clientOptions := options.Client().
-		ApplyURI("mongodb://127.0.0.1:27017").
-		SetMinPoolSize(25).
-		SetMaxPoolSize(100)
+		ApplyURI(cfg.URI).
+		SetMinPoolSize(uint64(cfg.MinPoolSize)).
+		SetMaxPoolSize(uint64(cfg.MaxPoolSize))
 
 	mongoClient, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
 		log.Fatalf("failed to connect to MongoDB: %v\n", err)
 	}
+
+	if err := app.PingMongoDB(ctx, mongoClient, cfg.ConnectAttempts, cfg.ConnectBackoff); err != nil {
+		log.Fatalf("%v\n", err)
+	}
 	return mongoClient
 }
 
-func initializeMongoDB(ctx context.Context, client *mongo.Client) {
+func initializeMongoDB(ctx context.Context, client *mongo.Client, drop bool) {
 	db := client.Database(app.DatabaseName)
 
-	if shouldDropDB, _ := strconv.ParseBool(os.Getenv("DROP_DB_ON_START")); shouldDropDB {
+	if drop {
 		log.Printf("dropping database %s\n", app.DatabaseName)
 		if err := db.Drop(ctx); err != nil {
 			log.Fatalf("failed to drop database: %v\n", err)
 		}
 	}
 
-	db.Collection(app.TransactionsCollectionName).Indexes().CreateMany(ctx, []mongo.IndexModel{
-		{
-			Keys:    bson.M{"client_id": 1},
-			Options: options.Index(),
-		},
-		{
-			Keys:    bson.M{"revision": 1},
-			Options: options.Index(),
-		},
-	})
-
-	db.Collection(app.SnapshotsCollectionName).Indexes().CreateMany(ctx, []mongo.IndexModel{
-		{
-			Keys:    bson.M{"created_at": 1},
-			Options: options.Index(),
-		},
-		{
-			Keys:    bson.M{"client_id": 1},
-			Options: options.Index(),
-		},
-	})
+	// the stores rely on the unique indexes for correctness.
+	if err := app.CreateMongoIndexes(ctx, db); err != nil {
+		log.Fatalf("failed to create indexes: %v\n", err)
+	}
 }
 
 func addInitialClients(ctx context.Context, clientsStore app.ClientStore) {
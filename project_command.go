@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"github.com/feralc/rinha-backend-2024/app"
+	"github.com/feralc/rinha-backend-2024/config"
+)
+
+func runProjectCommand(ctx context.Context, cfg config.Backend, args []string) {
+	flags := flag.NewFlagSet("project", flag.ExitOnError)
+	clientID := flags.Int("client", 0, "id of the client to rebuild, defaults to every client")
+	flags.Parse(args)
+
+	stores := setupStores(ctx, cfg, false)
+	defer stores.close()
+
+	if stores.projections == nil {
+		log.Fatalf("the selected store keeps no read models\n")
+	}
+
+	projector := app.NewProjector(stores.projections, stores.transactions, stores.clients)
+
+	printView := func(view app.StatementView) {
+		log.Printf("client %d: statement rebuilt up to revision %d, balance %d\n", view.ClientID, view.Revision, view.Balance)
+	}
+
+	if *clientID > 0 {
+		view, err := projector.Rebuild(ctx, *clientID)
+		if err != nil {
+			log.Fatalf("failed to rebuild the read models of client %d: %v\n", *clientID, err)
+		}
+		printView(view)
+	} else if err := projector.RebuildAll(ctx, printView); err != nil {
+		log.Fatalf("failed to rebuild the read models: %v\n", err)
+	}
+}
@@ -1,7 +1,7 @@
 // Code generated by protoc-gen-go-grpc. DO NOT EDIT.
 // versions:
 // - protoc-gen-go-grpc v1.2.0
-// - protoc             v3.12.4
+// - protoc             (unknown)
 // source: app.proto
 
 package proto
@@ -24,6 +24,8 @@ const _ = grpc.SupportPackageIsVersion7
 type TransactionServiceClient interface {
 	DoTransaction(ctx context.Context, in *TransactionRequest, opts ...grpc.CallOption) (*TransactionResult, error)
 	GetHistory(ctx context.Context, in *HistoryRequest, opts ...grpc.CallOption) (*AccountStatement, error)
+	ExportLedger(ctx context.Context, in *ExportRequest, opts ...grpc.CallOption) (TransactionService_ExportLedgerClient, error)
+	GetActorStatus(ctx context.Context, in *ActorStatusRequest, opts ...grpc.CallOption) (*ActorStatus, error)
 }
 
 type transactionServiceClient struct {
@@ -52,12 +54,55 @@ func (c *transactionServiceClient) GetHistory(ctx context.Context, in *HistoryRe
 	return out, nil
 }
 
+func (c *transactionServiceClient) ExportLedger(ctx context.Context, in *ExportRequest, opts ...grpc.CallOption) (TransactionService_ExportLedgerClient, error) {
+	stream, err := c.cc.NewStream(ctx, &TransactionService_ServiceDesc.Streams[0], "/app.TransactionService/ExportLedger", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &transactionServiceExportLedgerClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TransactionService_ExportLedgerClient interface {
+	Recv() (*ExportChunk, error)
+	grpc.ClientStream
+}
+
+type transactionServiceExportLedgerClient struct {
+	grpc.ClientStream
+}
+
+func (x *transactionServiceExportLedgerClient) Recv() (*ExportChunk, error) {
+	m := new(ExportChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *transactionServiceClient) GetActorStatus(ctx context.Context, in *ActorStatusRequest, opts ...grpc.CallOption) (*ActorStatus, error) {
+	out := new(ActorStatus)
+	err := c.cc.Invoke(ctx, "/app.TransactionService/GetActorStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // TransactionServiceServer is the server API for TransactionService service.
 // All implementations must embed UnimplementedTransactionServiceServer
 // for forward compatibility
 type TransactionServiceServer interface {
 	DoTransaction(context.Context, *TransactionRequest) (*TransactionResult, error)
 	GetHistory(context.Context, *HistoryRequest) (*AccountStatement, error)
+	ExportLedger(*ExportRequest, TransactionService_ExportLedgerServer) error
+	GetActorStatus(context.Context, *ActorStatusRequest) (*ActorStatus, error)
 	mustEmbedUnimplementedTransactionServiceServer()
 }
 
@@ -71,6 +116,12 @@ func (UnimplementedTransactionServiceServer) DoTransaction(context.Context, *Tra
 func (UnimplementedTransactionServiceServer) GetHistory(context.Context, *HistoryRequest) (*AccountStatement, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetHistory not implemented")
 }
+func (UnimplementedTransactionServiceServer) ExportLedger(*ExportRequest, TransactionService_ExportLedgerServer) error {
+	return status.Errorf(codes.Unimplemented, "method ExportLedger not implemented")
+}
+func (UnimplementedTransactionServiceServer) GetActorStatus(context.Context, *ActorStatusRequest) (*ActorStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetActorStatus not implemented")
+}
 func (UnimplementedTransactionServiceServer) mustEmbedUnimplementedTransactionServiceServer() {}
 
 // UnsafeTransactionServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -120,6 +171,45 @@ func _TransactionService_GetHistory_Handler(srv interface{}, ctx context.Context
 	return interceptor(ctx, in, info, handler)
 }
 
+func _TransactionService_ExportLedger_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TransactionServiceServer).ExportLedger(m, &transactionServiceExportLedgerServer{stream})
+}
+
+type TransactionService_ExportLedgerServer interface {
+	Send(*ExportChunk) error
+	grpc.ServerStream
+}
+
+type transactionServiceExportLedgerServer struct {
+	grpc.ServerStream
+}
+
+func (x *transactionServiceExportLedgerServer) Send(m *ExportChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _TransactionService_GetActorStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ActorStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TransactionServiceServer).GetActorStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/app.TransactionService/GetActorStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TransactionServiceServer).GetActorStatus(ctx, req.(*ActorStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // TransactionService_ServiceDesc is the grpc.ServiceDesc for TransactionService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -135,6 +225,138 @@ var TransactionService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetHistory",
 			Handler:    _TransactionService_GetHistory_Handler,
 		},
+		{
+			MethodName: "GetActorStatus",
+			Handler:    _TransactionService_GetActorStatus_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ExportLedger",
+			Handler:       _TransactionService_ExportLedger_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "app.proto",
+}
+
+// ActorAdminServiceClient is the client API for ActorAdminService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ActorAdminServiceClient interface {
+	HandOff(ctx context.Context, in *HandOffRequest, opts ...grpc.CallOption) (*HandOffResult, error)
+	Hydrate(ctx context.Context, in *HydrateRequest, opts ...grpc.CallOption) (*HydrateResult, error)
+}
+
+type actorAdminServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewActorAdminServiceClient(cc grpc.ClientConnInterface) ActorAdminServiceClient {
+	return &actorAdminServiceClient{cc}
+}
+
+func (c *actorAdminServiceClient) HandOff(ctx context.Context, in *HandOffRequest, opts ...grpc.CallOption) (*HandOffResult, error) {
+	out := new(HandOffResult)
+	err := c.cc.Invoke(ctx, "/app.ActorAdminService/HandOff", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *actorAdminServiceClient) Hydrate(ctx context.Context, in *HydrateRequest, opts ...grpc.CallOption) (*HydrateResult, error) {
+	out := new(HydrateResult)
+	err := c.cc.Invoke(ctx, "/app.ActorAdminService/Hydrate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ActorAdminServiceServer is the server API for ActorAdminService service.
+// All implementations must embed UnimplementedActorAdminServiceServer
+// for forward compatibility
+type ActorAdminServiceServer interface {
+	HandOff(context.Context, *HandOffRequest) (*HandOffResult, error)
+	Hydrate(context.Context, *HydrateRequest) (*HydrateResult, error)
+	mustEmbedUnimplementedActorAdminServiceServer()
+}
+
+// UnimplementedActorAdminServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedActorAdminServiceServer struct {
+}
+
+func (UnimplementedActorAdminServiceServer) HandOff(context.Context, *HandOffRequest) (*HandOffResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method HandOff not implemented")
+}
+func (UnimplementedActorAdminServiceServer) Hydrate(context.Context, *HydrateRequest) (*HydrateResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Hydrate not implemented")
+}
+func (UnimplementedActorAdminServiceServer) mustEmbedUnimplementedActorAdminServiceServer() {}
+
+// UnsafeActorAdminServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ActorAdminServiceServer will
+// result in compilation errors.
+type UnsafeActorAdminServiceServer interface {
+	mustEmbedUnimplementedActorAdminServiceServer()
+}
+
+func RegisterActorAdminServiceServer(s grpc.ServiceRegistrar, srv ActorAdminServiceServer) {
+	s.RegisterService(&ActorAdminService_ServiceDesc, srv)
+}
+
+func _ActorAdminService_HandOff_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HandOffRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ActorAdminServiceServer).HandOff(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/app.ActorAdminService/HandOff",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ActorAdminServiceServer).HandOff(ctx, req.(*HandOffRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ActorAdminService_Hydrate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HydrateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ActorAdminServiceServer).Hydrate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/app.ActorAdminService/Hydrate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ActorAdminServiceServer).Hydrate(ctx, req.(*HydrateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ActorAdminService_ServiceDesc is the grpc.ServiceDesc for ActorAdminService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ActorAdminService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "app.ActorAdminService",
+	HandlerType: (*ActorAdminServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "HandOff",
+			Handler:    _ActorAdminService_HandOff_Handler,
+		},
+		{
+			MethodName: "Hydrate",
+			Handler:    _ActorAdminService_Hydrate_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "app.proto",
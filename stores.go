@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+
+	"github.com/feralc/rinha-backend-2024/app"
+	"github.com/feralc/rinha-backend-2024/config"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type storeSet struct {
+	transactions app.TransactionStore
+	clients      app.ClientStore
+	leases       app.LeaseStore
+	// outbox is only set when the outbox is enabled.
+	outbox app.OutboxStore
+	// projections is nil for the stores that keep no read models.
+	projections app.ProjectionStore
+	// archive is only set when an archive directory is.
+	archive *app.TransactionArchive
+	close   func()
+}
+
+// setupStores connects to the database of the configured driver, keeping the
+// transactions in segment files instead when an event log directory is set,
+// and reads the archived ones from the archive directory when set. Only the
+// server initializes the schema; commands work on whatever is already there.
+func setupStores(ctx context.Context, cfg config.Backend, initialize bool) storeSet {
+	stores := setupTransactionLog(ctx, cfg, initialize)
+
+	dir := cfg.Archive.Dir
+	if dir == "" {
+		return stores
+	}
+
+	archive, err := app.OpenTransactionArchive(dir)
+	if err != nil {
+		log.Fatalf("failed to open archive in %s: %v\n", dir, err)
+	}
+
+	stores.transactions = app.NewArchivedTransactionStore(stores.transactions, archive)
+	stores.archive = archive
+	return stores
+}
+
+func setupTransactionLog(ctx context.Context, cfg config.Backend, initialize bool) storeSet {
+	stores := setupDatabase(ctx, cfg, initialize)
+
+	if cfg.EventLog.Dir == "" {
+		return stores
+	}
+
+	fileStore := setupFileTransactionStore(cfg.EventLog, stores.leases)
+	closeDatabase := stores.close
+
+	stores.transactions = fileStore
+	stores.close = func() {
+		if err := fileStore.Close(); err != nil {
+			log.Printf("error closing event log: %v\n", err)
+		}
+		closeDatabase()
+	}
+	return stores
+}
+
+func setupDatabase(ctx context.Context, cfg config.Backend, initialize bool) storeSet {
+	switch cfg.Store.Driver {
+	case "mongo":
+		mongoClient := setupMongoDB(ctx, cfg.Mongo)
+		if initialize {
+			initializeMongoDB(ctx, mongoClient, cfg.Store.DropOnStart)
+		}
+
+		// one breaker for every store, as they all go down with MongoDB.
+		options := app.MongoOptions{
+			OperationTimeout: cfg.Mongo.OperationTimeout,
+			Breaker:          app.NewCircuitBreaker("MongoDB", cfg.Mongo.BreakerThreshold, cfg.Mongo.BreakerCooldown),
+		}
+
+		stores := storeSet{
+			transactions: app.NewMongoDBTransactionStore(mongoClient, options),
+			clients:      app.NewMongoDBClientStore(mongoClient, options),
+			leases:       app.NewMongoDBLeaseStore(mongoClient, options),
+			projections:  app.NewMongoDBProjectionStore(mongoClient, options),
+			close: func() {
+				mongoClient.Disconnect(context.Background())
+			},
+		}
+		if cfg.Events.Outbox {
+			// a standalone server would fail every write of the outbox.
+			supported, err := app.MongoSupportsTransactions(ctx, mongoClient)
+			if err != nil {
+				log.Fatalf("failed to check MongoDB for transactions: %v\n", err)
+			}
+			if !supported {
+				log.Fatalf("events.outbox needs MongoDB to run as a replica set, as it writes the outbox in the same transaction\n")
+			}
+
+			stores.transactions = app.NewMongoDBTransactionStoreWithOutbox(mongoClient, options)
+			stores.outbox = app.NewMongoDBOutboxStore(mongoClient, options)
+		}
+		return stores
+	case "postgres":
+		pool := setupPostgres(ctx, cfg.Postgres)
+		if initialize {
+			initializePostgres(ctx, pool, cfg.Store.DropOnStart)
+		}
+
+		stores := storeSet{
+			transactions: app.NewPostgresTransactionStore(pool),
+			clients:      app.NewPostgresClientStore(pool),
+			leases:       app.NewPostgresLeaseStore(pool),
+			close:        pool.Close,
+		}
+		if cfg.Events.Outbox {
+			stores.transactions = app.NewPostgresTransactionStoreWithOutbox(pool)
+			stores.outbox = app.NewPostgresOutboxStore(pool)
+		}
+		return stores
+	case "memory":
+		log.Println("using in-memory stores, nothing will be persisted")
+		transactions := app.NewMemoryTransactionStore(app.StoreFaults{})
+		leases := app.NewMemoryLeaseStore()
+		transactions.SetLeases(leases)
+		return storeSet{
+			transactions: transactions,
+			clients:      app.NewMemoryClientStore(app.StoreFaults{}),
+			leases:       leases,
+			projections:  app.NewMemoryProjectionStore(),
+			close:        func() {},
+		}
+	case "sqlite":
+		db := setupSQLite(ctx, cfg.SQLite.Path, initialize, cfg.Store.DropOnStart)
+		stores := storeSet{
+			transactions: app.NewSQLiteTransactionStore(db),
+			clients:      app.NewSQLiteClientStore(db),
+			leases:       app.NewSQLiteLeaseStore(db),
+			close: func() {
+				db.Close()
+			},
+		}
+		if cfg.Events.Outbox {
+			stores.transactions = app.NewSQLiteTransactionStoreWithOutbox(db)
+			stores.outbox = app.NewSQLiteOutboxStore(db)
+		}
+		return stores
+	default:
+		log.Fatalf("unknown store driver %q\n", cfg.Store.Driver)
+		return storeSet{}
+	}
+}
+
+func setupPostgres(ctx context.Context, cfg config.Postgres) *pgxpool.Pool {
+	poolConfig, err := pgxpool.ParseConfig(cfg.URL)
+	if err != nil {
+		log.Fatalf("invalid postgres url: %v\n", err)
+	}
+	poolConfig.MinConns = int32(cfg.MinConns)
+	poolConfig.MaxConns = int32(cfg.MaxConns)
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		log.Fatalf("failed to connect to postgres: %v\n", err)
+	}
+	return pool
+}
+
+func initializePostgres(ctx context.Context, pool *pgxpool.Pool, drop bool) {
+	if drop {
+		log.Println("dropping postgres schema")
+		if err := app.DropPostgresSchema(ctx, pool); err != nil {
+			log.Fatalf("failed to drop schema: %v\n", err)
+		}
+	}
+
+	if err := app.MigratePostgres(ctx, pool); err != nil {
+		log.Fatalf("failed to migrate postgres: %v\n", err)
+	}
+}
+
+func setupSQLite(ctx context.Context, path string, initialize, drop bool) *sql.DB {
+	db, err := app.OpenSQLite(path)
+	if err != nil {
+		log.Fatalf("failed to open sqlite database %s: %v\n", path, err)
+	}
+
+	if !initialize {
+		return db
+	}
+
+	if drop {
+		log.Printf("dropping sqlite schema of %s\n", path)
+		if err := app.DropSQLiteSchema(ctx, db); err != nil {
+			log.Fatalf("failed to drop schema: %v\n", err)
+		}
+	}
+
+	if err := app.MigrateSQLite(ctx, db); err != nil {
+		log.Fatalf("failed to migrate sqlite: %v\n", err)
+	}
+
+	return db
+}
+
+func setupFileTransactionStore(cfg config.EventLog, leases app.LeaseStore) *app.FileTransactionStore {
+	store, err := app.OpenFileTransactionStore(app.FileStoreOptions{
+		Dir:             cfg.Dir,
+		Layout:          app.SegmentLayout(cfg.Layout),
+		Fsync:           app.FsyncPolicy(cfg.Fsync),
+		FsyncInterval:   cfg.FsyncInterval,
+		CompactInterval: cfg.CompactInterval,
+		SegmentSize:     cfg.SegmentSize,
+		Leases:          leases,
+	})
+	if err != nil {
+		log.Fatalf("failed to open event log in %s: %v\n", cfg.Dir, err)
+	}
+
+	log.Printf("using event log in %s\n", cfg.Dir)
+	return store
+}
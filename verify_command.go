@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/feralc/rinha-backend-2024/app"
+	"github.com/feralc/rinha-backend-2024/config"
+)
+
+func runVerifyCommand(ctx context.Context, cfg config.Backend, args []string) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	clientID := flags.Int("client", 0, "id of the client to verify, defaults to every client")
+	repair := flags.Bool("repair", false, "regenerate the snapshots of clients whose snapshots are wrong")
+	flags.Parse(args)
+
+	stores := setupStores(ctx, cfg, false)
+	defer stores.close()
+
+	verifier := app.NewLedgerVerifier(stores.clients, stores.transactions, stores.archive, snapshotOptions(cfg.Snapshots))
+
+	issues := 0
+	printReport := func(report app.VerificationReport) {
+		for _, issue := range report.Issues {
+			log.Println(issue)
+		}
+		if report.SnapshotsRepaired {
+			log.Printf("client %d: snapshots regenerated\n", report.ClientID)
+		}
+		log.Printf("client %d: %d transactions, %d snapshots, last revision %d, balance %d, %d issues\n",
+			report.ClientID, report.Transactions, report.Snapshots, report.LastRevision, report.Balance, len(report.Issues))
+		issues += len(report.Issues)
+	}
+
+	if *clientID > 0 {
+		report, err := verifier.Verify(ctx, *clientID, *repair)
+		if err != nil {
+			log.Fatalf("failed to verify client %d: %v\n", *clientID, err)
+		}
+		printReport(report)
+	} else if err := verifier.VerifyAll(ctx, *repair, printReport); err != nil {
+		log.Fatalf("failed to verify clients: %v\n", err)
+	}
+
+	if issues > 0 {
+		stores.close()
+		os.Exit(1)
+	}
+}